@@ -2,12 +2,8 @@ package cmd
 
 import (
 	"github.com/MarcoTomasRodriguez/hget/internal/download"
-	"github.com/MarcoTomasRodriguez/hget/pkg/codec"
 	"github.com/MarcoTomasRodriguez/hget/pkg/logger"
-	"github.com/MarcoTomasRodriguez/hget/pkg/progressbar"
-	"github.com/spf13/afero"
 	"github.com/spf13/cobra"
-	"github.com/spf13/viper"
 )
 
 // removeCmd represents the remove command.
@@ -24,9 +20,11 @@ INFO: _download removed successfully.
 	Run: func(cmd *cobra.Command, args []string) {
 		// Initialize downloader.
 		logger := logger.NewConsoleLogger()
-		fs := afero.NewBasePathFs(afero.NewOsFs(), viper.GetString("download_folder"))
-		storage := download.NewStorage(fs, codec.NewYAMLCodec())
-		downloader := download.NewDownloader(download.NewNetwork(), storage, progressbar.NewProgressBar(), logger)
+		downloader, err := newDownloader(logger, download.Config{}, download.NetworkConfig{})
+		if err != nil {
+			logger.Error(err.Error())
+			return
+		}
 
 		// Delete download using first command line argument as id.
 		if err := downloader.DeleteDownloadById(args[0]); err != nil {
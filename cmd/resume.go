@@ -2,12 +2,10 @@ package cmd
 
 import (
 	"context"
+	"errors"
 	"github.com/MarcoTomasRodriguez/hget/internal/download"
-	"github.com/MarcoTomasRodriguez/hget/pkg/codec"
 	"github.com/MarcoTomasRodriguez/hget/pkg/ctxutil"
 	"github.com/MarcoTomasRodriguez/hget/pkg/logger"
-	"github.com/MarcoTomasRodriguez/hget/pkg/progressbar"
-	"github.com/spf13/afero"
 	"github.com/spf13/cobra"
 	"github.com/spf13/viper"
 	"os"
@@ -26,28 +24,152 @@ $ hget resume 01cc0f0a3d94af18-file1.txt`,
 	Run: func(cmd *cobra.Command, args []string) {
 		// Initialize downloader.
 		logger := logger.NewConsoleLogger()
-		fs := afero.NewBasePathFs(afero.NewOsFs(), viper.GetString("download_folder"))
-		storage := download.NewStorage(fs, codec.NewYAMLCodec())
-		downloader := download.NewDownloader(download.NewNetwork(), storage, progressbar.NewProgressBar(), logger)
+		config, err := downloaderConfigFromFlags(cmd)
+		if err != nil {
+			logger.Error(err.Error())
+			return
+		}
+
+		networkConfig, err := networkConfigFromFlags(cmd)
+		if err != nil {
+			logger.Error(err.Error())
+			return
+		}
 
 		// Read download specification.
-		download, err := downloader.FindDownloadById(args[0])
+		spec, err := newStorage().ReadDownloadSpec(args[0])
+		if err != nil {
+			logger.Error(err.Error())
+			return
+		}
+
+		// Send the saved request headers and cookies, unless overridden by the flags.
+		if spec.Header, err = headerFromFlags(cmd, spec.Header); err != nil {
+			logger.Error(err.Error())
+			return
+		}
+
+		if spec.CookieFile, err = cookieFileFromFlags(cmd, spec.CookieFile); err != nil {
+			logger.Error(err.Error())
+			return
+		}
+
+		networkConfig.HTTP.Header = spec.Header
+		if networkConfig.HTTP.CookieJar, err = loadCookieJar(spec.CookieFile); err != nil {
+			logger.Error(err.Error())
+			return
+		}
+
+		// Connect to the same servers, unless overridden by the flags.
+		if spec.DNS, err = dnsConfigFromFlags(cmd, spec.DNS); err != nil {
+			logger.Error(err.Error())
+			return
+		}
+
+		networkConfig.HTTP.DNS = spec.DNS
+
+		// Connect to any address of the host if pinning is disabled.
+		if noPin, _ := cmd.Flags().GetBool("no-pin"); noPin {
+			spec.Address = ""
+		}
+
+		// Authenticate with the credentials of the flags, or get them again from where they were given, and the saved
+		// netrc file, unless overridden by the flags.
+		credentials, credentialSource := credentialsFromFlags(cmd, nil, nil)
+		if credentialSource == nil && spec.CredentialSource != nil {
+			if credentials, err = credentialsFromSource(*spec.CredentialSource); err != nil {
+				logger.Error(err.Error())
+				return
+			}
+
+			credentialSource = spec.CredentialSource
+		}
+
+		spec.Credentials, spec.CredentialSource = credentials, credentialSource
+		if spec.NetrcFile, err = netrcFileFromFlags(cmd, spec.NetrcFile); err != nil {
+			logger.Error(err.Error())
+			return
+		}
+
+		_, origin, _ := splitURLCredentials(spec.URL, networkConfig.DefaultScheme)
+		auth, err := authConfig(origin, spec.Credentials, spec.NetrcFile)
+		if err != nil {
+			logger.Error(err.Error())
+			return
+		}
+
+		networkConfig.HTTP.Auth, networkConfig.FTP.Auth, networkConfig.SFTP.Auth = auth, auth, auth
+
+		// Limit the connections to each host to the number of segments.
+		networkConfig.HTTP.Transport.MaxConnsPerHost = len(spec.Segments)
+
+		downloader, err := newDownloader(logger, config, networkConfig)
+		if err != nil {
+			logger.Error(err.Error())
+			return
+		}
+
+		// Override the saved rate limit if the flag is set.
+		if cmd.Flags().Changed("limit-rate") {
+			if spec.RateLimit, err = rateLimitFromFlags(cmd); err != nil {
+				logger.Error(err.Error())
+				return
+			}
+		}
+
+		// Override the saved content coding options if the flags are set.
+		if cmd.Flags().Changed("compressed") {
+			spec.Compressed, _ = cmd.Flags().GetBool("compressed")
+		}
+
+		if cmd.Flags().Changed("decode") {
+			spec.Decode, _ = cmd.Flags().GetBool("decode")
+		}
+
+		// Check that the remote file did not change, as the saved segments would be spliced with the new version.
+		restart, _ := cmd.Flags().GetBool("restart")
+		if spec, err = downloader.ValidateDownload(spec); err != nil {
+			if !errors.Is(err, download.ResourceChangedErr) {
+				logger.Error(err.Error())
+				return
+			}
+
+			if !restart {
+				logger.Error(err.Error())
+				logger.Info("Use `hget resume --restart %s` to discard the saved segments and download it again.", spec.Id)
+				return
+			}
+
+			logger.Warn("The remote file changed, restarting the download...")
+		}
+
+		// Discard the saved segments if requested, e.g. after a failed checksum verification.
+		if restart {
+			if spec, err = downloader.RestartDownload(spec); err != nil {
+				logger.Error(err.Error())
+				return
+			}
+		}
 
 		// Start download.
 		ctx := ctxutil.NewCancelableContext(context.Background())
-		if err = downloader.Download(download, ctx); err != nil {
+		if err = downloader.Download(spec, ctx); err != nil {
+			exitOnChecksumMismatch(logger, spec.Id, err)
 			logger.Error(err.Error())
+			if errors.Is(err, download.ResourceChangedErr) {
+				logger.Info("Use `hget resume --restart %s` to discard the saved segments and download it again.", spec.Id)
+			}
 			return
 		}
 
 		// Move download to output folder.
-		if err := os.Rename(filepath.Join(viper.GetString("download_folder"), download.Id, "output"), download.Name); err != nil {
+		if err := os.Rename(filepath.Join(viper.GetString("download_folder"), spec.Id, "output"), spec.Name); err != nil {
 			logger.Error(err.Error())
 			return
 		}
 
 		// Delete internal download folder.
-		if err := downloader.DeleteDownloadById(download.Id); err != nil {
+		if err := downloader.DeleteDownloadById(spec.Id); err != nil {
 			logger.Error(err.Error())
 			return
 		}
@@ -56,5 +178,7 @@ $ hget resume 01cc0f0a3d94af18-file1.txt`,
 
 // init registers the resume command.
 func init() {
+	addDownloadFlags(resumeCmd)
+	resumeCmd.Flags().Bool("restart", false, "Discard the saved segments and download again, e.g. if the remote file changed or failed verification.")
 	rootCmd.AddCommand(resumeCmd)
 }
@@ -0,0 +1,80 @@
+package download
+
+import (
+	"github.com/MarcoTomasRodriguez/hget/pkg/httputil"
+	"net/http"
+	"net/url"
+)
+
+// Credentials authenticate the requests with a username and password (Basic), or with a token (Bearer).
+type Credentials struct {
+	Username string `yaml:"username,omitempty"`
+	Password string `yaml:"password,omitempty"`
+	Token    string `yaml:"token,omitempty"`
+}
+
+// CredentialSource records where the credentials of a download were given, so that hget resume can get them again.
+// The password and token are never saved.
+type CredentialSource struct {
+	// From is where the credentials were given: "url", "user" or "bearer" for the flags, or "env".
+	From string `yaml:"from"`
+	// Env is the environment variable holding the credentials, read again on resume.
+	Env string `yaml:"env,omitempty"`
+	// Username is the username of the credentials, which is not secret.
+	Username string `yaml:"username,omitempty"`
+	// Secret reports whether the credentials have a password or a token, which must be given again on resume.
+	Secret bool `yaml:"secret,omitempty"`
+}
+
+// AuthConfig configures the authentication of the networks.
+type AuthConfig struct {
+	// Origin is the origin of the URL the credentials were given for, e.g. "https://example.com". The credentials
+	// are never sent to other origins, such as a CDN the requests are redirected to, or a mirror.
+	Origin string
+	// Credentials authenticate the requests sent to the origin, if any.
+	Credentials *Credentials
+	// Netrc holds the credentials of each host, used for the requests not authenticated by the credentials.
+	Netrc httputil.Netrc
+}
+
+// credentialsOf returns the credentials of a URL: the credentials of the configuration if the URL belongs to their
+// origin, or else those of the netrc machine of its host, if any.
+func (c AuthConfig) credentialsOf(u *url.URL) *Credentials {
+	if c.Credentials != nil && c.Origin == httputil.Origin(u) {
+		return c.Credentials
+	}
+
+	if machine, ok := c.Netrc.Lookup(u.Hostname()); ok {
+		return &Credentials{Username: machine.Login, Password: machine.Password}
+	}
+
+	return nil
+}
+
+// authTransport authenticates each request, including those following redirects, with the credentials of its origin.
+// Requests already carrying an Authorization header are sent as is.
+type authTransport struct {
+	config AuthConfig
+	next   http.RoundTripper
+}
+
+func (t authTransport) RoundTrip(request *http.Request) (*http.Response, error) {
+	if request.Header.Get("Authorization") != "" {
+		return t.next.RoundTrip(request)
+	}
+
+	credentials := t.config.credentialsOf(request.URL)
+	if credentials == nil {
+		return t.next.RoundTrip(request)
+	}
+
+	// A round tripper must not modify the request.
+	request = request.Clone(request.Context())
+	if credentials.Token != "" {
+		request.Header.Set("Authorization", "Bearer "+credentials.Token)
+	} else {
+		request.SetBasicAuth(credentials.Username, credentials.Password)
+	}
+
+	return t.next.RoundTrip(request)
+}
@@ -0,0 +1,158 @@
+package download_test
+
+import (
+	"bytes"
+	"context"
+	"github.com/MarcoTomasRodriguez/hget/internal/download"
+	"github.com/MarcoTomasRodriguez/hget/pkg/httputil"
+	"github.com/samber/lo"
+	"github.com/stretchr/testify/suite"
+	"math/rand"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+type AuthSuite struct {
+	suite.Suite
+	content []byte
+	// origin redirects the requests to the cdn.
+	origin *httptest.Server
+	cdn    *httptest.Server
+	mu     sync.Mutex
+	// authorizations are the Authorization headers received by each server.
+	authorizations map[string][]string
+	// headers are the headers of the requests received by each server.
+	headers map[string][]http.Header
+}
+
+func (s *AuthSuite) SetupTest() {
+	s.content = make([]byte, 2583)
+	rand.Read(s.content)
+	s.authorizations = map[string][]string{}
+	s.headers = map[string][]http.Header{}
+
+	s.cdn = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		s.record("cdn", r)
+		http.ServeContent(w, r, "file.bin", time.Time{}, bytes.NewReader(s.content))
+	}))
+
+	// The cdn is reached through its host name, so that it has another origin and host than the origin server.
+	cdnURL := strings.Replace(s.cdn.URL, "127.0.0.1", "localhost", 1)
+	s.origin = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		s.record("origin", r)
+		if r.Header.Get("Authorization") == "" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+
+		http.Redirect(w, r, cdnURL+r.URL.Path, http.StatusFound)
+	}))
+}
+
+func (s *AuthSuite) TearDownTest() {
+	s.origin.Close()
+	s.cdn.Close()
+}
+
+func (s *AuthSuite) record(server string, r *http.Request) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.authorizations[server] = append(s.authorizations[server], r.Header.Get("Authorization"))
+	s.headers[server] = append(s.headers[server], r.Header.Clone())
+}
+
+func (s *AuthSuite) networkWith(credentials *download.Credentials, netrc httputil.Netrc) download.Network {
+	u, _ := url.Parse(s.origin.URL)
+
+	network, err := download.NewHTTPNetwork(download.HTTPConfig{
+		Auth: download.AuthConfig{Origin: httputil.Origin(u), Credentials: credentials, Netrc: netrc},
+	})
+	s.Require().NoError(err)
+
+	return network
+}
+
+func (s *AuthSuite) download(network download.Network) {
+	resource, err := network.FetchResource(s.origin.URL + "/file.bin")
+	s.Require().NoError(err)
+
+	buffer := new(bytes.Buffer)
+	s.NoError(network.DownloadResource(resource, 0, resource.Size, buffer, context.TODO()))
+	s.Equal(s.content, buffer.Bytes())
+}
+
+func (s *AuthSuite) TestHTTPNetwork_ShouldScopeBasicCredentialsToOrigin() {
+	s.download(s.networkWith(&download.Credentials{Username: "alice", Password: "s3cret"}, nil))
+
+	request, _ := http.NewRequest("GET", "/", nil)
+	request.SetBasicAuth("alice", "s3cret")
+
+	s.Equal([]string{request.Header.Get("Authorization")}, lo.Uniq(s.authorizations["origin"]))
+	s.Equal([]string{""}, lo.Uniq(s.authorizations["cdn"]))
+}
+
+func (s *AuthSuite) TestHTTPNetwork_ShouldScopeBearerTokenToOrigin() {
+	s.download(s.networkWith(&download.Credentials{Token: "abc123"}, nil))
+
+	s.Equal([]string{"Bearer abc123"}, lo.Uniq(s.authorizations["origin"]))
+	s.Equal([]string{""}, lo.Uniq(s.authorizations["cdn"]))
+}
+
+func (s *AuthSuite) TestHTTPNetwork_ShouldLookUpNetrcByHost() {
+	netrc := httputil.Netrc{
+		{Name: "127.0.0.1", Login: "alice", Password: "s3cret"},
+		{Name: "localhost", Login: "bob", Password: "hunter2"},
+	}
+
+	s.download(s.networkWith(nil, netrc))
+
+	originRequest, _ := http.NewRequest("GET", "/", nil)
+	originRequest.SetBasicAuth("alice", "s3cret")
+	cdnRequest, _ := http.NewRequest("GET", "/", nil)
+	cdnRequest.SetBasicAuth("bob", "hunter2")
+
+	s.Equal([]string{originRequest.Header.Get("Authorization")}, lo.Uniq(s.authorizations["origin"]))
+	s.Equal([]string{cdnRequest.Header.Get("Authorization")}, lo.Uniq(s.authorizations["cdn"]))
+}
+
+func (s *AuthSuite) TestHTTPNetwork_ShouldScopeSecretHeadersToOrigin() {
+	u, _ := url.Parse(s.origin.URL)
+	network, err := download.NewHTTPNetwork(download.HTTPConfig{
+		Auth:   download.AuthConfig{Origin: httputil.Origin(u)},
+		Header: http.Header{"Authorization": {"Bearer SECRET"}, "X-Api-Key": {"SECRET"}, "User-Agent": {"hget-test"}},
+	})
+	s.Require().NoError(err)
+
+	// The segments are requested from the cdn the origin redirected to, as a resumed download does.
+	resource, err := network.FetchResource(s.origin.URL + "/file.bin")
+	s.Require().NoError(err)
+	s.Require().NotEqual(s.origin.URL+"/file.bin", resource.URL)
+
+	buffer := new(bytes.Buffer)
+	s.NoError(network.DownloadResource(resource, 0, 999, buffer, context.TODO()))
+	s.NoError(network.DownloadResource(resource, 1000, resource.Size, buffer, context.TODO()))
+	s.Equal(s.content, buffer.Bytes())
+
+	s.Equal([]string{"Bearer SECRET"}, lo.Uniq(s.authorizations["origin"]))
+	s.NotEmpty(s.headers["cdn"])
+	for _, header := range s.headers["cdn"] {
+		s.Empty(header.Get("Authorization"))
+		s.Empty(header.Get("X-Api-Key"))
+		s.Equal("hget-test", header.Get("User-Agent"))
+	}
+}
+
+func (s *AuthSuite) TestHTTPNetwork_ShouldFailIfUnauthorized() {
+	_, err := s.networkWith(nil, nil).FetchResource(s.origin.URL + "/file.bin")
+	s.ErrorIs(err, download.StatusError{StatusCode: http.StatusUnauthorized, Status: "401 Unauthorized"})
+}
+
+func TestAuthSuite(t *testing.T) {
+	suite.Run(t, new(AuthSuite))
+}
@@ -0,0 +1,187 @@
+package download
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/md5"
+	"crypto/sha1"
+	"crypto/sha256"
+	"crypto/sha512"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"hash"
+	"net/url"
+	"path"
+	"regexp"
+	"strings"
+)
+
+var (
+	UnsupportedChecksumErr = errors.New("unsupported checksum algorithm")
+	InvalidChecksumErr     = errors.New("invalid checksum digest")
+	ChecksumMismatchErr    = errors.New("checksum mismatch")
+)
+
+// Supported checksum algorithms.
+const (
+	MD5    = "md5"
+	SHA1   = "sha1"
+	SHA256 = "sha256"
+	SHA512 = "sha512"
+)
+
+// checksumSidecarSuffixes lists, in order of preference, the suffixes appended to a resource URL by checksum files
+// that describe a single resource.
+var checksumSidecarSuffixes = []struct{ suffix, algorithm string }{
+	{".sha512", SHA512},
+	{".sha256", SHA256},
+	{".md5", MD5},
+}
+
+// checksumSidecarFiles lists, in order of preference, the checksum files published in the same directory as the
+// resource that describe every file in that directory.
+var checksumSidecarFiles = []struct{ filename, algorithm string }{
+	{"SHA512SUMS", SHA512},
+	{"SHA256SUMS", SHA256},
+	{"MD5SUMS", MD5},
+}
+
+// bsdChecksumLine matches the BSD-style checksum format, e.g. "SHA256 (file.tar.gz) = 2cf2...".
+var bsdChecksumLine = regexp.MustCompile(`^\w+ \((.+)\) = ([0-9A-Fa-f]+)$`)
+
+// hashes maps each supported checksum algorithm to its hash constructor.
+var hashes = map[string]func() hash.Hash{
+	MD5:    md5.New,
+	SHA1:   sha1.New,
+	SHA256: sha256.New,
+	SHA512: sha512.New,
+}
+
+// Checksum stores the expected digest of a download and the algorithm used to compute it.
+type Checksum struct {
+	Algorithm string `yaml:"algorithm"`
+	Digest    string `yaml:"digest"`
+}
+
+// NewChecksum validates the algorithm and the hex-encoded digest, and returns a normalized checksum.
+func NewChecksum(algorithm string, digest string) (Checksum, error) {
+	algorithm = strings.ToLower(algorithm)
+	digest = strings.ToLower(strings.TrimSpace(digest))
+
+	newHash, ok := hashes[algorithm]
+	if !ok {
+		return Checksum{}, UnsupportedChecksumErr
+	}
+
+	// Check that the digest is hex-encoded and has the length of the algorithm's output.
+	if decoded, err := hex.DecodeString(digest); err != nil || len(decoded) != newHash().Size() {
+		return Checksum{}, InvalidChecksumErr
+	}
+
+	return Checksum{Algorithm: algorithm, Digest: digest}, nil
+}
+
+// Hash returns a new hash for the checksum's algorithm.
+func (c Checksum) Hash() (hash.Hash, error) {
+	newHash, ok := hashes[c.Algorithm]
+	if !ok {
+		return nil, UnsupportedChecksumErr
+	}
+
+	return newHash(), nil
+}
+
+// Verify compares the sum of a hash against the expected digest.
+func (c Checksum) Verify(h hash.Hash) error {
+	if actual := hex.EncodeToString(h.Sum(nil)); actual != c.Digest {
+		return fmt.Errorf("%w: expected %s %s, got %s", ChecksumMismatchErr, c.Algorithm, c.Digest, actual)
+	}
+
+	return nil
+}
+
+// ChecksumSidecar describes a checksum file that may be published next to a resource.
+type ChecksumSidecar struct {
+	URL       string
+	Algorithm string
+	// Filename is the name of the resource as it may appear inside the checksum file.
+	Filename string
+}
+
+// ChecksumSidecars returns, in order of preference, the checksum files that may be published next to a resource, e.g.
+// <url>.sha256 or SHA256SUMS in the same directory.
+func ChecksumSidecars(rawURL string) ([]ChecksumSidecar, error) {
+	resourceURL, err := url.Parse(rawURL)
+	if err != nil {
+		return nil, err
+	}
+
+	// Checksum files do not depend on the query or the fragment of the resource URL.
+	resourceURL.RawQuery = ""
+	resourceURL.Fragment = ""
+
+	filename := path.Base(resourceURL.Path)
+	sidecars := make([]ChecksumSidecar, 0, len(checksumSidecarSuffixes)+len(checksumSidecarFiles))
+
+	for _, s := range checksumSidecarSuffixes {
+		sidecarURL := *resourceURL
+		sidecarURL.Path += s.suffix
+		sidecarURL.RawPath = ""
+		sidecars = append(sidecars, ChecksumSidecar{URL: sidecarURL.String(), Algorithm: s.algorithm, Filename: filename})
+	}
+
+	for _, s := range checksumSidecarFiles {
+		sidecarURL := *resourceURL
+		sidecarURL.Path = path.Join(path.Dir(resourceURL.Path), s.filename)
+		sidecarURL.RawPath = ""
+		sidecars = append(sidecars, ChecksumSidecar{URL: sidecarURL.String(), Algorithm: s.algorithm, Filename: filename})
+	}
+
+	return sidecars, nil
+}
+
+// ParseChecksumFile extracts the checksum of a file from the content of a checksum file. It supports the GNU format
+// ("<digest>  <filename>", optionally with a "*" binary marker), the BSD format ("SHA256 (<filename>) = <digest>"),
+// and files containing only the digest. Entries naming a file are only accepted if it matches one of the filenames.
+func ParseChecksumFile(algorithm string, content []byte, filenames ...string) (Checksum, bool) {
+	matches := func(name string) bool {
+		name = strings.TrimPrefix(name, "./")
+		for _, filename := range filenames {
+			if filename != "" && name == filename {
+				return true
+			}
+		}
+
+		return false
+	}
+
+	scanner := bufio.NewScanner(bytes.NewReader(content))
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		var digest, name string
+		if groups := bsdChecksumLine.FindStringSubmatch(line); groups != nil {
+			name, digest = groups[1], groups[2]
+		} else {
+			fields := strings.Fields(line)
+			digest = fields[0]
+			if len(fields) > 1 {
+				name = strings.TrimPrefix(strings.Join(fields[1:], " "), "*")
+			}
+		}
+
+		if name != "" && !matches(name) {
+			continue
+		}
+
+		if checksum, err := NewChecksum(algorithm, digest); err == nil {
+			return checksum, true
+		}
+	}
+
+	return Checksum{}, false
+}
@@ -0,0 +1,95 @@
+package download_test
+
+import (
+	"crypto/sha256"
+	"github.com/MarcoTomasRodriguez/hget/internal/download"
+	"github.com/stretchr/testify/assert"
+	"testing"
+)
+
+const helloSha256 = "2cf24dba5fb0a30e26e83b2ac5b9e29e1b161e5c1fa7425e73043362938b9824"
+
+func TestNewChecksum(t *testing.T) {
+	testCases := []struct {
+		name      string
+		algorithm string
+		digest    string
+		err       error
+	}{
+		{"valid sha256", "sha256", helloSha256, nil},
+		{"uppercase", "SHA256", " 2CF24DBA5FB0A30E26E83B2AC5B9E29E1B161E5C1FA7425E73043362938B9824 ", nil},
+		{"valid md5", "md5", "5d41402abc4b2a76b9719d911017c592", nil},
+		{"unsupported algorithm", "crc32", "3610a686", download.UnsupportedChecksumErr},
+		{"wrong length", "sha512", helloSha256, download.InvalidChecksumErr},
+		{"not hex", "md5", "zz41402abc4b2a76b9719d911017c592", download.InvalidChecksumErr},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			checksum, err := download.NewChecksum(tc.algorithm, tc.digest)
+			if tc.err != nil {
+				assert.ErrorIs(t, err, tc.err)
+				return
+			}
+
+			assert.NoError(t, err)
+			assert.Regexp(t, "^[0-9a-f]+$", checksum.Digest)
+		})
+	}
+}
+
+func TestChecksum_Verify(t *testing.T) {
+	checksum := download.Checksum{Algorithm: download.SHA256, Digest: helloSha256}
+
+	h := sha256.New()
+	h.Write([]byte("hello"))
+	assert.NoError(t, checksum.Verify(h))
+
+	h.Write([]byte("!"))
+	assert.ErrorIs(t, checksum.Verify(h), download.ChecksumMismatchErr)
+}
+
+func TestChecksumSidecars(t *testing.T) {
+	sidecars, err := download.ChecksumSidecars("https://go.dev/dl/go1.19.1.src.tar.gz?mirror=1")
+	assert.NoError(t, err)
+
+	urls := make([]string, len(sidecars))
+	for i, sidecar := range sidecars {
+		assert.Equal(t, "go1.19.1.src.tar.gz", sidecar.Filename)
+		urls[i] = sidecar.URL
+	}
+
+	assert.Equal(t, []string{
+		"https://go.dev/dl/go1.19.1.src.tar.gz.sha512",
+		"https://go.dev/dl/go1.19.1.src.tar.gz.sha256",
+		"https://go.dev/dl/go1.19.1.src.tar.gz.md5",
+		"https://go.dev/dl/SHA512SUMS",
+		"https://go.dev/dl/SHA256SUMS",
+		"https://go.dev/dl/MD5SUMS",
+	}, urls)
+}
+
+func TestParseChecksumFile(t *testing.T) {
+	testCases := []struct {
+		name    string
+		content string
+		found   bool
+	}{
+		{"digest only", helloSha256 + "\n", true},
+		{"gnu format", "0000000000000000000000000000000000000000000000000000000000000000  other.txt\n" + helloSha256 + "  hello.txt\n", true},
+		{"gnu binary format", helloSha256 + " *./hello.txt\n", true},
+		{"bsd format", "SHA256 (hello.txt) = " + helloSha256 + "\n", true},
+		{"other file", helloSha256 + "  other.txt\n", false},
+		{"invalid digest", "# comment\nnot-a-digest\n", false},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			checksum, found := download.ParseChecksumFile(download.SHA256, []byte(tc.content), "hello.txt")
+			assert.Equal(t, tc.found, found)
+			if tc.found {
+				assert.Equal(t, helloSha256, checksum.Digest)
+			}
+		})
+	}
+}
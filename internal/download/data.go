@@ -0,0 +1,164 @@
+package download
+
+import (
+	"context"
+	"encoding/base64"
+	"fmt"
+	"github.com/MarcoTomasRodriguez/hget/pkg/fsutil"
+	"github.com/MarcoTomasRodriguez/hget/pkg/httputil"
+	"io"
+	"mime"
+	"net/url"
+	"strings"
+)
+
+// defaultDataFilename is the filename of the data URLs without valid filename parameter.
+const defaultDataFilename = "data.bin"
+
+var InvalidDataURLErr = fmt.Errorf("%w: invalid data url", httputil.InvalidUrlErr)
+
+// dataNetwork implements the Network interface for the data scheme (RFC 2397), whose content is embedded in the URL.
+type dataNetwork struct{}
+
+// dataURL holds the content of a data URL and its media type.
+type dataURL struct {
+	mediaType string
+	params    map[string]string
+	content   []byte
+}
+
+// parseDataURL parses a data URL, e.g. "data:text/plain;base64,aGdldA==". The content is percent-decoded, then
+// base64-decoded if the base64 extension is set.
+func parseDataURL(rawURL string) (dataURL, error) {
+	scheme, rest, ok := strings.Cut(strings.TrimSpace(rawURL), ":")
+	if !ok || !strings.EqualFold(scheme, "data") {
+		return dataURL{}, fmt.Errorf("%w: %s", UnsupportedSchemeErr, scheme)
+	}
+
+	header, data, ok := strings.Cut(rest, ",")
+	if !ok {
+		return dataURL{}, fmt.Errorf("%w: missing comma", InvalidDataURLErr)
+	}
+
+	isBase64 := false
+	if trimmed := strings.TrimSuffix(header, ";base64"); trimmed != header {
+		header, isBase64 = trimmed, true
+	}
+
+	// The media type defaults to text/plain, with the US-ASCII charset.
+	mediaType, params := "text/plain", map[string]string{"charset": "US-ASCII"}
+	if strings.TrimSpace(header) != "" {
+		if header[0] == ';' {
+			header = mediaType + header
+		}
+
+		var err error
+		if mediaType, params, err = mime.ParseMediaType(header); err != nil {
+			return dataURL{}, fmt.Errorf("%w: %v", InvalidDataURLErr, err)
+		}
+	}
+
+	content, err := url.PathUnescape(data)
+	if err != nil {
+		return dataURL{}, fmt.Errorf("%w: %v", InvalidDataURLErr, err)
+	}
+
+	decoded := []byte(content)
+	if isBase64 {
+		// Whitespace and missing padding are tolerated, as in most browsers.
+		content = strings.Join(strings.Fields(content), "")
+		if decoded, err = base64.RawStdEncoding.DecodeString(strings.TrimRight(content, "=")); err != nil {
+			return dataURL{}, fmt.Errorf("%w: %v", InvalidDataURLErr, err)
+		}
+	}
+
+	return dataURL{mediaType: mediaType, params: params, content: decoded}, nil
+}
+
+// filename returns the filename of the filename or name parameter of the media type, if valid.
+func (d dataURL) filename() string {
+	for _, param := range []string{"filename", "name"} {
+		if filename := d.params[param]; filename != "" && fsutil.ValidateFilename(filename) {
+			return filename
+		}
+	}
+
+	return defaultDataFilename
+}
+
+// FetchResource decodes a data URL and describes its content. The filename is taken from the filename or name parameter,
+// if any, e.g. "data:text/plain;filename=notes.txt,hget".
+func (n dataNetwork) FetchResource(URL string) (Resource, error) {
+	data, err := parseDataURL(URL)
+	if err != nil {
+		return Resource{}, err
+	}
+
+	return Resource{
+		URL:          URL,
+		Filename:     data.filename(),
+		Size:         int64(len(data.content)),
+		AcceptRanges: true,
+		ContentType:  data.mediaType,
+	}, nil
+}
+
+// ReadResource decodes a small data URL.
+func (n dataNetwork) ReadResource(URL string) ([]byte, error) {
+	data, err := parseDataURL(URL)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(data.content) > maxReadResourceSize {
+		return nil, ResourceTooLargeErr
+	}
+
+	return data.content, nil
+}
+
+// DownloadResource decodes a data URL, and writes a range of its content.
+func (n dataNetwork) DownloadResource(resource Resource, start int64, end int64, writer io.Writer, ctx context.Context) error {
+	// Check if the segment has an overflow.
+	if start < 0 || start > end {
+		return SegmentOverflowErr
+	}
+
+	// Check if download already finished. The end point is inclusive, except for the last segment, whose end point is
+	// the size of the resource.
+	if start == end && end >= resource.Size {
+		return nil
+	}
+
+	data, err := parseDataURL(resource.URL)
+	if err != nil {
+		return err
+	}
+
+	size := int64(len(data.content))
+	if resource.Size > 0 && size != resource.Size {
+		return ResourceChangedErr
+	}
+
+	// The end point is inclusive, except for the last segment, whose end point is the size of the resource.
+	if end >= size {
+		end = size - 1
+	}
+
+	if start > end {
+		return SegmentOverflowErr
+	}
+
+	if _, err := writer.Write(data.content[start : end+1]); err != nil {
+		return BufferCopyErr
+	}
+
+	return nil
+}
+
+// NewDataNetwork creates a network for the data scheme.
+func NewDataNetwork() Network {
+	return &dataNetwork{}
+}
+
+var _ Network = (*dataNetwork)(nil)
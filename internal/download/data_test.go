@@ -0,0 +1,87 @@
+package download_test
+
+import (
+	"bytes"
+	"context"
+	"github.com/MarcoTomasRodriguez/hget/internal/download"
+	"github.com/MarcoTomasRodriguez/hget/pkg/httputil"
+	"github.com/stretchr/testify/assert"
+	"testing"
+)
+
+func TestDataNetwork_FetchResource(t *testing.T) {
+	tests := []struct {
+		url         string
+		filename    string
+		contentType string
+		content     string
+	}{
+		{url: "data:,hget", filename: "data.bin", contentType: "text/plain", content: "hget"},
+		{url: "data:text/plain;base64,aGdldA==", filename: "data.bin", contentType: "text/plain", content: "hget"},
+		{url: "data:text/plain;base64,aGdl dA", filename: "data.bin", contentType: "text/plain", content: "hget"},
+		{url: "data:;charset=utf-8,h%C3%A9get%2C%20", filename: "data.bin", contentType: "text/plain", content: "héget, "},
+		{url: "DATA:application/json;filename=data.json,%7B%7D", filename: "data.json", contentType: "application/json", content: "{}"},
+		{url: "data:application/octet-stream;name=\"file.bin\";base64,AAEC", filename: "file.bin", contentType: "application/octet-stream", content: "\x00\x01\x02"},
+		{url: "data:text/plain;name=\"../file.bin\",hget", filename: "data.bin", contentType: "text/plain", content: "hget"},
+	}
+
+	network := download.NewDataNetwork()
+	for _, test := range tests {
+		resource, err := network.FetchResource(test.url)
+		if !assert.NoError(t, err, test.url) {
+			continue
+		}
+
+		assert.Equal(t, test.filename, resource.Filename, test.url)
+		assert.Equal(t, test.contentType, resource.ContentType, test.url)
+		assert.Equal(t, int64(len(test.content)), resource.Size, test.url)
+
+		content, err := network.ReadResource(test.url)
+		assert.NoError(t, err, test.url)
+		assert.Equal(t, test.content, string(content), test.url)
+	}
+}
+
+func TestDataNetwork_FetchResource_ShouldFailIfInvalid(t *testing.T) {
+	network := download.NewDataNetwork()
+
+	for _, url := range []string{"data:text/plain", "data:;base64,a!b", "data:,%zz", "data:text/;x,hget"} {
+		_, err := network.FetchResource(url)
+		assert.ErrorIs(t, err, download.InvalidDataURLErr, url)
+		assert.ErrorIs(t, err, httputil.InvalidUrlErr, url)
+	}
+}
+
+func TestDataNetwork_DownloadResource(t *testing.T) {
+	network := download.NewDataNetwork()
+	resource, err := network.FetchResource("data:,0123456789")
+	assert.NoError(t, err)
+
+	buffer := new(bytes.Buffer)
+	assert.NoError(t, network.DownloadResource(resource, 2, 4, buffer, context.TODO()))
+	assert.Equal(t, "234", buffer.String())
+
+	// The last segment ends at the size of the resource.
+	buffer.Reset()
+	assert.NoError(t, network.DownloadResource(resource, 5, resource.Size, buffer, context.TODO()))
+	assert.Equal(t, "56789", buffer.String())
+
+	assert.ErrorIs(t, network.DownloadResource(resource, 5, 4, buffer, context.TODO()), download.SegmentOverflowErr)
+}
+
+func TestSchemeNetwork_ShouldRouteLocalURLs(t *testing.T) {
+	network, err := download.NewDefaultNetwork(download.NetworkConfig{DefaultScheme: "http"})
+	assert.NoError(t, err)
+
+	resource, err := network.FetchResource(" data:,hget")
+	assert.NoError(t, err)
+	assert.Equal(t, "data:,hget", resource.URL)
+
+	buffer := new(bytes.Buffer)
+	assert.NoError(t, network.DownloadResource(resource, 0, resource.Size, buffer, context.TODO()))
+	assert.Equal(t, "hget", buffer.String())
+
+	_, err = network.FetchResource("file:///nonexistent/hget/file.bin")
+	assert.Error(t, err)
+	assert.NotErrorIs(t, err, httputil.InvalidUrlErr)
+}
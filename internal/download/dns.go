@@ -0,0 +1,174 @@
+package download
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+)
+
+// defaultDNSPort is the port of the DNS servers given without one.
+const defaultDNSPort = "53"
+
+var InvalidDNSConfigErr = errors.New("invalid dns configuration")
+
+// DNSConfig configures how the http network resolves the hosts. It is saved with the downloads, so that they are
+// resumed from the same servers.
+type DNSConfig struct {
+	// Resolve overrides the addresses of some hosts and ports, as in curl: "host:port:address", several addresses
+	// separated by commas, e.g. "cdn.example.com:443:10.0.0.1,[2001:db8::1]". The addresses are tried in order.
+	Resolve []string `yaml:"resolve,omitempty"`
+	// Server is the DNS server resolving the other hosts instead of the system resolver, e.g. "1.1.1.1" or
+	// "[2606:4700::1111]:53".
+	Server string `yaml:"server,omitempty"`
+	// IPVersion restricts the connections to IPv4 if 4, or to IPv6 if 6.
+	IPVersion int `yaml:"ip_version,omitempty"`
+}
+
+// overrides parses the overridden addresses, by lowercase "host:port".
+func (c DNSConfig) overrides() (map[string][]string, error) {
+	overrides := map[string][]string{}
+	for _, entry := range c.Resolve {
+		host, rest, _ := strings.Cut(entry, ":")
+		port, addresses, ok := strings.Cut(rest, ":")
+		if !ok || host == "" {
+			return nil, fmt.Errorf("%w: expected host:port:address, got %q", InvalidDNSConfigErr, entry)
+		}
+
+		if number, err := strconv.Atoi(port); err != nil || number < 1 || number > 65535 {
+			return nil, fmt.Errorf("%w: invalid port in %q", InvalidDNSConfigErr, entry)
+		}
+
+		key := strings.ToLower(host) + ":" + port
+		for _, address := range strings.Split(addresses, ",") {
+			address = strings.TrimSuffix(strings.TrimPrefix(strings.TrimSpace(address), "["), "]")
+			if net.ParseIP(address) == nil {
+				return nil, fmt.Errorf("%w: invalid address in %q", InvalidDNSConfigErr, entry)
+			}
+
+			overrides[key] = append(overrides[key], address)
+		}
+	}
+
+	return overrides, nil
+}
+
+// serverAddress returns the address of the DNS server, with the default port if it has none.
+func (c DNSConfig) serverAddress() (string, error) {
+	if _, _, err := net.SplitHostPort(c.Server); err == nil {
+		return c.Server, nil
+	}
+
+	host := strings.TrimSuffix(strings.TrimPrefix(c.Server, "["), "]")
+	if net.ParseIP(host) == nil {
+		return "", fmt.Errorf("%w: invalid dns server %q", InvalidDNSConfigErr, c.Server)
+	}
+
+	return net.JoinHostPort(host, defaultDNSPort), nil
+}
+
+// network restricts a network to the IP version of the configuration, e.g. "tcp" to "tcp4".
+func (c DNSConfig) network(network string) string {
+	if c.IPVersion == 0 || strings.HasSuffix(network, "4") || strings.HasSuffix(network, "6") {
+		return network
+	}
+
+	return network + strconv.Itoa(c.IPVersion)
+}
+
+// newDialer returns the dialer of the connections, which connects to the overridden addresses of a host, if any, or
+// resolves it with the DNS server of the configuration, if any.
+func (c DNSConfig) newDialer(dialer *net.Dialer) (*dnsDialer, error) {
+	if c.IPVersion != 0 && c.IPVersion != 4 && c.IPVersion != 6 {
+		return nil, fmt.Errorf("%w: invalid ip version %d", InvalidDNSConfigErr, c.IPVersion)
+	}
+
+	overrides, err := c.overrides()
+	if err != nil {
+		return nil, err
+	}
+
+	if c.Server != "" {
+		server, err := c.serverAddress()
+		if err != nil {
+			return nil, err
+		}
+
+		serverDialer := &net.Dialer{Timeout: dialer.Timeout}
+		dialer.Resolver = &net.Resolver{
+			PreferGo: true,
+			Dial: func(ctx context.Context, network string, _ string) (net.Conn, error) {
+				return serverDialer.DialContext(ctx, network, server)
+			},
+		}
+	}
+
+	return &dnsDialer{config: c, dialer: dialer, overrides: overrides}, nil
+}
+
+// dnsDialer dials the connections of the http network, resolving the hosts with a DNS configuration.
+type dnsDialer struct {
+	config    DNSConfig
+	dialer    *net.Dialer
+	overrides map[string][]string
+}
+
+// DialContext connects to an address, given as "host:port".
+func (d *dnsDialer) DialContext(ctx context.Context, network string, address string) (net.Conn, error) {
+	network = d.config.network(network)
+
+	host, port, err := net.SplitHostPort(address)
+	if err != nil {
+		return d.dialer.DialContext(ctx, network, address)
+	}
+
+	addresses, ok := d.overrides[strings.ToLower(host)+":"+port]
+	if !ok {
+		return d.dialer.DialContext(ctx, network, address)
+	}
+
+	// Connect to the first overridden address accepting the connection.
+	for _, ip := range addresses {
+		var conn net.Conn
+		if conn, err = d.dialer.DialContext(ctx, network, net.JoinHostPort(ip, port)); err == nil {
+			return conn, nil
+		}
+	}
+
+	return nil, err
+}
+
+// lookup returns the IP addresses a host and port, given as "host:port", is connected to, in order.
+func (d *dnsDialer) lookup(ctx context.Context, address string) ([]string, error) {
+	host, port, err := net.SplitHostPort(address)
+	if err != nil {
+		return nil, err
+	}
+
+	if addresses, ok := d.overrides[strings.ToLower(host)+":"+port]; ok {
+		return addresses, nil
+	}
+
+	if ip := net.ParseIP(host); ip != nil {
+		return []string{ip.String()}, nil
+	}
+
+	resolver := d.dialer.Resolver
+	if resolver == nil {
+		resolver = net.DefaultResolver
+	}
+
+	ips, err := resolver.LookupIP(ctx, d.config.network("ip"), host)
+	if err != nil {
+		return nil, err
+	}
+
+	addresses := make([]string, len(ips))
+	for i, ip := range ips {
+		addresses[i] = ip.String()
+	}
+
+	return addresses, nil
+}
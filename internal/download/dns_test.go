@@ -0,0 +1,184 @@
+package download_test
+
+import (
+	"bytes"
+	"context"
+	"github.com/MarcoTomasRodriguez/hget/internal/download"
+	"github.com/MarcoTomasRodriguez/hget/pkg/codec"
+	"github.com/MarcoTomasRodriguez/hget/pkg/logger"
+	"github.com/MarcoTomasRodriguez/hget/pkg/progressbar"
+	"github.com/spf13/afero"
+	"github.com/stretchr/testify/suite"
+	"golang.org/x/net/dns/dnsmessage"
+	"math/rand"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"sync"
+	"testing"
+	"time"
+)
+
+type DNSSuite struct {
+	suite.Suite
+	content []byte
+	server  *httptest.Server
+	port    string
+	mu      sync.Mutex
+	// hosts are the Host headers of the requests.
+	hosts []string
+}
+
+func (s *DNSSuite) SetupTest() {
+	s.content = make([]byte, 5000)
+	rand.Read(s.content)
+	s.hosts = nil
+
+	s.server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		s.mu.Lock()
+		s.hosts = append(s.hosts, r.Host)
+		s.mu.Unlock()
+
+		http.ServeContent(w, r, "file.bin", time.Time{}, bytes.NewReader(s.content))
+	}))
+
+	serverURL, _ := url.Parse(s.server.URL)
+	s.port = serverURL.Port()
+}
+
+func (s *DNSSuite) TearDownTest() {
+	s.server.Close()
+}
+
+func (s *DNSSuite) network(config download.DNSConfig) download.Network {
+	network, err := download.NewHTTPNetwork(download.HTTPConfig{DNS: config})
+	s.Require().NoError(err)
+
+	return network
+}
+
+// serveDNS answers the A queries received by the connection with the loopback address, until it is closed.
+func (s *DNSSuite) serveDNS(conn net.PacketConn) {
+	buffer := make([]byte, 512)
+	for {
+		n, addr, err := conn.ReadFrom(buffer)
+		if err != nil {
+			return
+		}
+
+		var query dnsmessage.Message
+		if err := query.Unpack(buffer[:n]); err != nil || len(query.Questions) == 0 {
+			continue
+		}
+
+		response := dnsmessage.Message{
+			Header:    dnsmessage.Header{ID: query.ID, Response: true, Authoritative: true},
+			Questions: query.Questions,
+		}
+
+		if question := query.Questions[0]; question.Type == dnsmessage.TypeA {
+			response.Answers = append(response.Answers, dnsmessage.Resource{
+				Header: dnsmessage.ResourceHeader{Name: question.Name, Type: dnsmessage.TypeA, Class: dnsmessage.ClassINET, TTL: 60},
+				Body:   &dnsmessage.AResource{A: [4]byte{127, 0, 0, 1}},
+			})
+		}
+
+		packed, _ := response.Pack()
+		_, _ = conn.WriteTo(packed, addr)
+	}
+}
+
+func (s *DNSSuite) TestHTTPNetwork_ShouldResolveOverriddenHost() {
+	network := s.network(download.DNSConfig{Resolve: []string{"Staging.CDN.test:" + s.port + ":127.0.0.1"}})
+
+	resource, err := network.FetchResource("http://staging.cdn.test:" + s.port + "/file.bin")
+	s.Require().NoError(err)
+	s.Equal(int64(len(s.content)), resource.Size)
+
+	buffer := new(bytes.Buffer)
+	s.Require().NoError(network.DownloadResource(resource, 0, resource.Size, buffer, context.TODO()))
+	s.Equal(s.content, buffer.Bytes())
+
+	// The requests are sent to the host of the URL.
+	for _, host := range s.hosts {
+		s.Equal("staging.cdn.test:"+s.port, host)
+	}
+}
+
+func (s *DNSSuite) TestHTTPNetwork_ShouldTryOverriddenAddressesInOrder() {
+	// Nothing listens on the port of the server on 127.0.0.2.
+	network := s.network(download.DNSConfig{Resolve: []string{"staging.cdn.test:" + s.port + ":127.0.0.2,[::1],127.0.0.1"}})
+
+	_, err := network.FetchResource("http://staging.cdn.test:" + s.port + "/file.bin")
+	s.NoError(err)
+
+	// Other ports are not overridden.
+	_, err = network.FetchResource("http://staging.cdn.test:1/file.bin")
+	s.Error(err)
+}
+
+func (s *DNSSuite) TestHTTPNetwork_ShouldRestrictIPVersion() {
+	resolve := []string{"staging.cdn.test:" + s.port + ":127.0.0.1"}
+
+	_, err := s.network(download.DNSConfig{Resolve: resolve, IPVersion: 4}).FetchResource("http://staging.cdn.test:" + s.port + "/file.bin")
+	s.NoError(err)
+
+	_, err = s.network(download.DNSConfig{Resolve: resolve, IPVersion: 6}).FetchResource("http://staging.cdn.test:" + s.port + "/file.bin")
+	s.Error(err)
+	s.True(download.Retryable(err))
+
+	_, err = s.network(download.DNSConfig{IPVersion: 6}).FetchResource(s.server.URL + "/file.bin")
+	s.Error(err)
+}
+
+func (s *DNSSuite) TestHTTPNetwork_ShouldResolveWithDNSServer() {
+	conn, err := net.ListenPacket("udp4", "127.0.0.1:0")
+	s.Require().NoError(err)
+	defer conn.Close()
+
+	go s.serveDNS(conn)
+
+	network := s.network(download.DNSConfig{Server: conn.LocalAddr().String(), IPVersion: 4})
+
+	resource, err := network.FetchResource("http://mirror.hget.test:" + s.port + "/file.bin")
+	s.Require().NoError(err)
+	s.Equal(int64(len(s.content)), resource.Size)
+}
+
+func (s *DNSSuite) TestNewHTTPNetwork_ShouldFailIfInvalidDNSConfig() {
+	configs := []download.DNSConfig{
+		{Resolve: []string{"staging.cdn.test"}},
+		{Resolve: []string{"staging.cdn.test:443"}},
+		{Resolve: []string{":443:127.0.0.1"}},
+		{Resolve: []string{"staging.cdn.test:https:127.0.0.1"}},
+		{Resolve: []string{"staging.cdn.test:443:localhost"}},
+		{Server: "dns.example.com"},
+		{IPVersion: 5},
+	}
+
+	for _, config := range configs {
+		_, err := download.NewHTTPNetwork(download.HTTPConfig{DNS: config})
+		s.ErrorIs(err, download.InvalidDNSConfigErr, config)
+	}
+}
+
+func (s *DNSSuite) TestDownloader_ShouldSaveDNSConfig() {
+	config := download.DNSConfig{Resolve: []string{"staging.cdn.test:" + s.port + ":127.0.0.1"}, IPVersion: 4}
+	storage := download.NewStorage(afero.NewMemMapFs(), codec.NewYAMLCodec())
+	downloader := download.NewDownloader(s.network(config), storage, progressbar.NoopProgressBar{},
+		logger.NoopConsoleLogger{}, download.Config{})
+
+	spec, err := downloader.InitDownload("http://staging.cdn.test:"+s.port+"/file.bin", download.Options{Workers: 2, DNS: config})
+	s.Require().NoError(err)
+	s.Equal(config, spec.DNS)
+
+	s.Require().NoError(downloader.Download(spec, context.TODO()))
+	saved, err := storage.ReadDownloadSpec(spec.Id)
+	s.Require().NoError(err)
+	s.Equal(config, saved.DNS)
+}
+
+func TestDNSSuite(t *testing.T) {
+	suite.Run(t, new(DNSSuite))
+}
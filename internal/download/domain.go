@@ -4,15 +4,49 @@ import (
 	"fmt"
 	"github.com/MarcoTomasRodriguez/hget/pkg/fsutil"
 	"github.com/fatih/color"
+	"net/http"
 )
 
 // Download stores the information of a resource that can be downloaded.
 type Download struct {
-	Id       string    `yaml:"id"`
-	Name     string    `yaml:"name"`
-	URL      string    `yaml:"url"`
-	Size     int64     `yaml:"size"`
-	Segments []Segment `yaml:"segments"`
+	Id   string `yaml:"id"`
+	Name string `yaml:"name"`
+	URL  string `yaml:"url"`
+	// ResolvedURL is the URL the requests to URL were redirected to, if any, from which the segments are downloaded.
+	// It is resolved again from URL on resume, as redirects to CDNs often expire.
+	ResolvedURL  string   `yaml:"resolved_url,omitempty"`
+	Mirrors      []string `yaml:"mirrors,omitempty"`
+	Size         int64    `yaml:"size"`
+	ETag         string   `yaml:"etag,omitempty"`
+	LastModified string   `yaml:"last_modified,omitempty"`
+	// ContentEncoding is the content coding applied by the server to the resource, if any. The size, segments and
+	// digests of the download then refer to the encoded bytes.
+	ContentEncoding string `yaml:"content_encoding,omitempty"`
+	// Decode decodes the content coding of the resource into the output, instead of keeping the encoded bytes.
+	Decode bool `yaml:"decode,omitempty"`
+	// DecodedSize is the size of the decoded output, once known.
+	DecodedSize int64 `yaml:"decoded_size,omitempty"`
+	// Compressed allows the server to compress the transfer of a download with a single segment.
+	Compressed bool      `yaml:"compressed,omitempty"`
+	Segments   []Segment `yaml:"segments"`
+	Checksum   *Checksum `yaml:"checksum,omitempty"`
+	Pieces     *Pieces   `yaml:"pieces,omitempty"`
+	RateLimit  int64     `yaml:"rate_limit,omitempty"`
+	// Header holds the custom headers of the requests. The headers carrying credentials are saved apart from the
+	// specification.
+	Header http.Header `yaml:"header,omitempty"`
+	// CookieFile is the cookie file, in the Netscape format, loaded before sending the requests.
+	CookieFile string `yaml:"cookie_file,omitempty"`
+	// Credentials authenticate the requests to the origin of the URL. They are never saved.
+	Credentials *Credentials `yaml:"-"`
+	// CredentialSource records where the credentials were given, if any.
+	CredentialSource *CredentialSource `yaml:"credential_source,omitempty"`
+	// NetrcFile is the netrc file looked up for the credentials of each host, if any.
+	NetrcFile string `yaml:"netrc_file,omitempty"`
+	// DNS overrides the resolution of the hosts, so that the download is resumed from the same servers.
+	DNS DNSConfig `yaml:"dns,omitempty"`
+	// Address is the IP address of the server every segment is downloaded from, if the download is pinned to it.
+	Address string `yaml:"address,omitempty"`
 }
 
 // Segment stores the start and end points of a download's segment.
@@ -21,14 +55,51 @@ type Segment struct {
 	// Example: a1b2c3d4/segment.01
 	Id    string `yaml:"id"`
 	Start int64  `yaml:"start"`
-	End   int64  `yaml:"end"`
+	// End is -1 while the size of a streamed download is unknown.
+	End int64 `yaml:"end"`
+	// Retries is the number of times the segment was retried after a transient failure.
+	Retries int `yaml:"retries,omitempty"`
+	// LastError is the error of the last failed attempt, if any.
+	LastError string `yaml:"last_error,omitempty"`
+}
+
+// Resource returns the description of the remote resource as recorded when the download was initialized.
+func (d Download) Resource() Resource {
+	resource := Resource{
+		URL:          d.URL,
+		Filename:     d.Name,
+		Size:         d.Size,
+		AcceptRanges: len(d.Segments) > 1,
+		ETag:         d.ETag,
+		LastModified: d.LastModified,
+		// Only single stream downloads may be compressed.
+		ContentEncoding: d.ContentEncoding,
+		Compressed:      d.Compressed && len(d.Segments) == 1,
+		Address:         d.Address,
+	}
+
+	if d.ResolvedURL != "" {
+		resource.URL, resource.OriginalURL = d.ResolvedURL, d.URL
+	}
+
+	return resource
+}
+
+// Streaming reports whether the download is streamed, as the size of the resource is unknown until it is transferred.
+func (d Download) Streaming() bool {
+	return d.Size < 0
 }
 
 // String returns a colored formatted string with the download's Id, URL and Size.
 func (d Download) String() string {
+	size := "unknown"
+	if !d.Streaming() {
+		size = fsutil.ReadableMemorySize(d.Size)
+	}
+
 	return fmt.Sprintln(
 		" ⁕", color.HiCyanString(d.Id), "⇒",
 		color.HiCyanString("URL:"), d.URL,
-		color.HiCyanString("Size:"), fsutil.ReadableMemorySize(d.Size),
+		color.HiCyanString("Size:"), size,
 	)
 }
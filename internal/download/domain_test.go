@@ -11,7 +11,7 @@ var golangSample = download.Download{
 	Name:     "go1.19.1.src.tar.gz",
 	URL:      "https://go.dev/dl/go1.19.1.src.tar.gz",
 	Size:     1300,
-	Segments: []download.Segment{{"v5pra7bt/segment.00", 0, 1300}},
+	Segments: []download.Segment{{Id: "v5pra7bt/segment.00", Start: 0, End: 1300}},
 }
 
 var javaSample = download.Download{
@@ -20,10 +20,10 @@ var javaSample = download.Download{
 	URL:  "https://java.com/download/jre/jre-8u351-macosx-x64.dmg",
 	Size: 2583,
 	Segments: []download.Segment{
-		{"ita2qybt/segment.00", 0, 644},
-		{"ita2qybt/segment.01", 645, 1289},
-		{"ita2qybt/segment.02", 1290, 1934},
-		{"ita2qybt/segment.03", 1935, 2583},
+		{Id: "ita2qybt/segment.00", Start: 0, End: 644},
+		{Id: "ita2qybt/segment.01", Start: 645, End: 1289},
+		{Id: "ita2qybt/segment.02", Start: 1290, End: 1934},
+		{Id: "ita2qybt/segment.03", Start: 1935, End: 2583},
 	},
 }
 
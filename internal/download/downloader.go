@@ -4,11 +4,16 @@ import (
 	"context"
 	"errors"
 	"fmt"
+	"github.com/MarcoTomasRodriguez/hget/pkg/fsutil"
 	"github.com/MarcoTomasRodriguez/hget/pkg/logger"
 	"github.com/MarcoTomasRodriguez/hget/pkg/progressbar"
+	"github.com/MarcoTomasRodriguez/hget/pkg/ratelimit"
 	"github.com/fatih/color"
+	"hash"
 	"io"
 	"math/rand"
+	"net/http"
+	"sort"
 	"sync"
 )
 
@@ -16,113 +21,489 @@ var UserCancelledDownloadErr = errors.New("user cancelled download")
 
 type Downloader interface {
 	Download(download Download, ctx context.Context) error
-	InitDownload(url string, workers uint8) (Download, error)
+	InitDownload(url string, options Options) (Download, error)
+	InitMetalinkDownload(metalink Metalink, options Options) (Download, error)
+	ValidateDownload(download Download) (Download, error)
+	RestartDownload(download Download) (Download, error)
 	FindAllDownloads() ([]Download, error)
 	FindDownloadById(id string) (Download, error)
 	FindDownloadByUrl(url string) (Download, error)
 	DeleteDownloadById(id string) error
 }
 
+// Options configures the initialization of a download.
+type Options struct {
+	// Workers is the number of segments the download is split into, if the resource supports range downloads.
+	Workers uint8
+	// Checksum is the expected digest of the download, if known.
+	Checksum *Checksum
+	// ChecksumAuto enables the discovery of checksum sidecar files published next to the resource, if no Checksum
+	// was provided.
+	ChecksumAuto bool
+	// RateLimit is the maximum number of bytes per second of the download, shared by all its workers. If it is not
+	// positive, the download is not limited.
+	RateLimit int64
+	// Mirrors are other URLs serving the same resource. The segments are distributed across the URL and its mirrors.
+	Mirrors []string
+	// Header holds the custom headers of the requests, saved with the download.
+	Header http.Header
+	// CookieFile is the cookie file loaded before sending the requests, saved with the download.
+	CookieFile string
+	// Credentials authenticate the requests to the origin of the URL. They are not saved with the download.
+	Credentials *Credentials
+	// CredentialSource records where the credentials were given, saved with the download in their place.
+	CredentialSource *CredentialSource
+	// NetrcFile is the netrc file looked up for the credentials of each host, saved with the download.
+	NetrcFile string
+	// Compressed allows the server to compress the transfer of the downloads with a single segment.
+	Compressed bool
+	// Decode decodes the content coding applied by the server to the resource, if any, instead of keeping the encoded
+	// bytes.
+	Decode bool
+	// DNS overrides the resolution of the hosts, saved with the download.
+	DNS DNSConfig
+	// NoPin lets the segments of a segmented download connect to any address of the host. By default, they are pinned
+	// to the address of the server which answered its first request, so that they are not served by different servers
+	// of the host, e.g. the edges of a CDN.
+	NoPin bool
+}
+
+// Config configures the behaviour of a downloader across downloads.
+type Config struct {
+	// Retry configures how failed segments are retried.
+	Retry RetryPolicy
+	// MinSplitSize is the minimum size of the segments created when a free worker splits a running segment. If it
+	// is not positive, segments are never split.
+	MinSplitSize int64
+	// RateLimit is the maximum number of bytes per second shared by all the downloads. If it is not positive, the
+	// downloads are not limited.
+	RateLimit int64
+	// SlowMirrors configures how the mirrors much slower than the others are dropped.
+	SlowMirrors SlowMirrorPolicy
+}
+
 type downloader struct {
 	network     Network
 	storage     Storage
 	progressbar progressbar.ProgressBar
 	logger      logger.Logger
+	config      Config
+	limiter     *ratelimit.Limiter
 }
 
-// InitDownload extracts the download specification from a web resource.
-func (s downloader) InitDownload(url string, workers uint8) (Download, error) {
+// InitDownload extracts the download specification from a web resource. If the resource is a Metalink document, the
+// download is initialized from the file it describes.
+func (s downloader) InitDownload(url string, options Options) (Download, error) {
 	resource, err := s.network.FetchResource(url)
 	if err != nil {
 		return Download{}, err
 	}
 
+	if IsMetalink(resource) {
+		content, err := s.network.ReadResource(resource.URL)
+		if err != nil {
+			return Download{}, err
+		}
+
+		metalink, err := ParseMetalink(content)
+		if err != nil {
+			return Download{}, err
+		}
+
+		return s.InitMetalinkDownload(metalink, options)
+	}
+
+	return s.newDownload(resource, options, nil)
+}
+
+// InitMetalinkDownload extracts the download specification from the file described by a Metalink document. The first
+// URL serving the file with the expected size is downloaded, the other URLs become its mirrors, and the digests of the
+// document are used to verify the download, unless a checksum was provided.
+func (s downloader) InitMetalinkDownload(metalink Metalink, options Options) (Download, error) {
+	err := NoMetalinkSourceErr
+	for i, url := range metalink.URLs {
+		var resource Resource
+		if resource, err = s.network.FetchResource(url); err != nil {
+			s.logger.Warn("Skipping metalink source %s: %v", url, err)
+			continue
+		}
+
+		if metalink.Size > 0 && resource.Size != metalink.Size {
+			err = fmt.Errorf("%w: %s has size %d, expected %d", ResourceChangedErr, url, resource.Size, metalink.Size)
+			s.logger.Warn("Skipping metalink source %s: %v", url, err)
+			continue
+		}
+
+		resource.Filename = metalink.Name
+		options.Mirrors = append(append([]string(nil), metalink.URLs[i+1:]...), options.Mirrors...)
+		if options.Checksum == nil {
+			options.Checksum = metalink.Checksum
+		}
+
+		return s.newDownload(resource, options, metalink.Pieces)
+	}
+
+	return Download{}, err
+}
+
+// newDownload creates the download specification of a resource, verified by the given piece digests, if any. If the
+// resource is encoded by the server, it returns UnsupportedEncodingErr if the coding cannot be decoded as requested.
+func (s downloader) newDownload(resource Resource, options Options, pieces *Pieces) (Download, error) {
+	if resource.ContentEncoding != "" {
+		if !options.Decode {
+			s.logger.Warn("The server encodes the file with %s, the encoded bytes are kept. Use --decode to decode them.", resource.ContentEncoding)
+		} else if err := checkEncoding(resource.ContentEncoding); err != nil {
+			return Download{}, err
+		}
+	}
+
 	// Generate the download id.
 	id := make([]byte, 4)
 	rand.Read(id)
 
 	// In order for range downloads to work, they should be supported and the content length be provided.
-	var segments []Segment
+	segmentCount := int(options.Workers)
 	if resource.Size <= 0 || !resource.AcceptRanges {
-		segments = make([]Segment, 1)
-	} else {
-		segments = make([]Segment, workers)
+		segmentCount = 1
+	}
+
+	segments := newSegments(fmt.Sprintf("%x", id), resource.Size, segmentCount)
+
+	// Pin the segments to the server which answered, so that they are all served by the same one.
+	address := ""
+	if !options.NoPin && segmentCount > 1 {
+		address = resource.Address
+	}
+
+	// Look for a checksum sidecar file if no checksum was provided.
+	checksum := options.Checksum
+	if checksum == nil && options.ChecksumAuto {
+		checksum = s.discoverChecksum(resource)
 	}
 
-	// Initialize segments.
+	// Only keep the mirrors serving the same resource, including those advertised by the server.
+	var mirrors []string
+	if segmentCount > 1 {
+		urls := append(append([]string(nil), options.Mirrors...), resource.Duplicates...)
+		mirrors = s.checkMirrors(resource, urls, checksum != nil || pieces != nil)
+	}
+
+	return Download{
+		Id:               fmt.Sprintf("%x", id),
+		Name:             resource.Filename,
+		URL:              resource.RequestedURL(),
+		ResolvedURL:      resolvedURL(resource),
+		Mirrors:          mirrors,
+		Size:             resource.Size,
+		ETag:             resource.ETag,
+		LastModified:     resource.LastModified,
+		ContentEncoding:  resource.ContentEncoding,
+		Decode:           options.Decode,
+		Compressed:       options.Compressed,
+		Segments:         segments,
+		Checksum:         checksum,
+		Pieces:           pieces,
+		RateLimit:        options.RateLimit,
+		Header:           options.Header,
+		CookieFile:       options.CookieFile,
+		Credentials:      options.Credentials,
+		CredentialSource: options.CredentialSource,
+		NetrcFile:        options.NetrcFile,
+		DNS:              options.DNS,
+		Address:          address,
+	}, nil
+}
+
+// newSegments splits a resource of the given size into equally sized segments.
+func newSegments(downloadId string, size int64, count int) []Segment {
+	segments := make([]Segment, count)
+
 	for i := range segments {
 		// Compute the segment's starting point.
-		start := (resource.Size / int64(len(segments))) * int64(i)
+		start := (size / int64(len(segments))) * int64(i)
 
 		// Initialize the segment's end point. By default, it is the file size.
-		end := resource.Size
+		end := size
 
 		// If the segment is not the last, compute his end point.
 		if i < len(segments)-1 {
-			end = (resource.Size/int64(len(segments)))*(int64(i)+1) - 1
+			end = (size/int64(len(segments)))*(int64(i)+1) - 1
 		}
 
 		segments[i] = Segment{
-			Id:    fmt.Sprintf("%x/segment.%02d", id, i),
+			Id:    fmt.Sprintf("%s/segment.%02d", downloadId, i),
 			Start: start,
 			End:   end,
 		}
 	}
 
-	return Download{
-		Id:       fmt.Sprintf("%x", id),
-		Name:     resource.Filename,
-		URL:      resource.URL,
-		Size:     resource.Size,
-		Segments: segments,
-	}, nil
+	return segments
+}
+
+// resolvedURL returns the URL a resource was redirected to, if any.
+func resolvedURL(resource Resource) string {
+	if resource.OriginalURL == "" {
+		return ""
+	}
+
+	return resource.URL
+}
+
+// ValidateDownload checks that the remote resource did not change since the download was initialized, so that the
+// saved segments can be resumed. If it changed, it returns ResourceChangedErr. The download URL is resolved again, and
+// the download is returned with the URL it is now redirected to, if any.
+func (s downloader) ValidateDownload(download Download) (Download, error) {
+	resource, err := s.network.FetchResource(download.URL)
+	if err != nil {
+		return download, err
+	}
+
+	previous := download.Resource()
+	if resource.Changed(previous) {
+		return download, ResourceChangedErr
+	}
+
+	download.ResolvedURL = resolvedURL(resource)
+
+	// The pinned address belongs to the previous host if the resource moved to another one.
+	if download.Address != "" && !sameHost(previous.URL, resource.URL) {
+		download.Address = resource.Address
+	}
+
+	return download, nil
+}
+
+// RestartDownload discards the saved segments of a download and rebuilds its specification from the current remote
+// resource, keeping its id, number of segments and the mirrors still serving it.
+func (s downloader) RestartDownload(download Download) (Download, error) {
+	resource, err := s.network.FetchResource(download.URL)
+	if err != nil {
+		return Download{}, err
+	}
+
+	// Delete the saved segments, which may be missing if they were never started.
+	for _, segment := range download.Segments {
+		_ = s.storage.DeleteSegment(segment.Id)
+	}
+
+	segmentCount := len(download.Segments)
+	if resource.Size <= 0 || !resource.AcceptRanges {
+		segmentCount = 1
+	}
+
+	// Check the mirrors again, as they may not have been updated yet.
+	mirrors := download.Mirrors
+	download.Mirrors = nil
+	if segmentCount > 1 {
+		download.Mirrors = s.checkMirrors(resource, mirrors, download.Checksum != nil || download.Pieces != nil)
+	}
+
+	download.ResolvedURL = resolvedURL(resource)
+	download.Size = resource.Size
+	download.ContentEncoding = resource.ContentEncoding
+	download.ETag = resource.ETag
+	download.LastModified = resource.LastModified
+	download.Segments = newSegments(download.Id, resource.Size, segmentCount)
+
+	// Pin the download again to the server which answered, if it was pinned.
+	if download.Address != "" {
+		download.Address = ""
+		if segmentCount > 1 {
+			download.Address = resource.Address
+		}
+	}
+
+	return download, s.storage.WriteDownloadSpec(download)
+}
+
+// checkMirrors fetches the mirrors of a resource and returns those which agree with it on the size and validators,
+// and support range downloads. If the download is verified by digests, the validators of the mirrors, which usually
+// differ between servers, are not compared. The other mirrors are dropped with a warning.
+func (s downloader) checkMirrors(resource Resource, urls []string, verified bool) []string {
+	var mirrors []string
+	seen := map[string]bool{resource.URL: true, resource.RequestedURL(): true}
+	for _, url := range urls {
+		if seen[url] {
+			continue
+		}
+
+		seen[url] = true
+
+		mirror, err := s.network.FetchResource(url)
+		if err != nil {
+			s.logger.Warn("Dropping mirror %s: %v", url, err)
+			continue
+		}
+
+		if mirror.Size != resource.Size || mirror.ContentEncoding != resource.ContentEncoding ||
+			(!verified && mirror.Changed(resource)) || !mirror.AcceptRanges {
+			s.logger.Warn("Dropping mirror %s: it does not serve the same resource with range downloads", url)
+			continue
+		}
+
+		mirrors = append(mirrors, mirror.RequestedURL())
+	}
+
+	return mirrors
+}
+
+// discoverChecksum looks for the checksum sidecar files of a resource and returns the first checksum found, or nil if
+// none of them is available or lists the resource.
+func (s downloader) discoverChecksum(resource Resource) *Checksum {
+	sidecars, err := ChecksumSidecars(resource.RequestedURL())
+	if err != nil {
+		s.logger.Warn("Could not look for checksum files: %v", err)
+		return nil
+	}
+
+	for _, sidecar := range sidecars {
+		content, err := s.network.ReadResource(sidecar.URL)
+		if err != nil {
+			continue
+		}
+
+		if checksum, ok := ParseChecksumFile(sidecar.Algorithm, content, sidecar.Filename, resource.Filename); ok {
+			s.logger.Info("Found %s checksum in %s", checksum.Algorithm, sidecar.URL)
+			return &checksum
+		}
+	}
+
+	s.logger.Warn("No checksum file found, the download will not be verified.")
+	return nil
 }
 
 // Download takes a download specification and downloads it.
 func (s downloader) Download(download Download, ctx context.Context) error {
-	var wg sync.WaitGroup
+	// Copy the segments, which are updated while downloading, so that the caller's specification is left untouched.
+	download.Segments = append([]Segment(nil), download.Segments...)
 
 	if err := s.storage.WriteDownloadSpec(download); err != nil {
 		return err
 	}
 
+	err := s.downloadSegments(&download, ctx)
+
+	// If the server turned out not to support range downloads, start over with a single segment.
+	if errors.Is(err, RangeNotSupportedErr) && len(download.Segments) > 1 {
+		s.logger.Warn("The server does not support range downloads, falling back to a single stream...")
+
+		for _, segment := range download.Segments {
+			_ = s.storage.DeleteSegment(segment.Id)
+		}
+
+		download.Segments = newSegments(download.Id, download.Size, 1)
+		if err := s.storage.WriteDownloadSpec(download); err != nil {
+			return err
+		}
+
+		err = s.downloadSegments(&download, ctx)
+	}
+
+	if err != nil {
+		return err
+	}
+
+	// Record the size of a streamed download, now that it is known.
+	if download.Streaming() {
+		if err := s.recordStreamSize(&download); err != nil {
+			return err
+		}
+	}
+
+	return s.mergeSegments(download)
+}
+
+// recordStreamSize records the size of a streamed download, i.e. the number of bytes written to its single segment, in
+// its specification.
+func (s downloader) recordStreamSize(download *Download) error {
+	size, err := s.storage.GetSegmentSize(download.Segments[0].Id)
+	if err != nil {
+		return FilesystemError(err.Error())
+	}
+
+	download.Size = size
+	download.Segments[0].End = size
+	s.logger.Info("Downloaded %s", fsutil.ReadableMemorySize(size))
+
+	return s.storage.WriteDownloadSpec(*download)
+}
+
+// downloadSegments downloads the unfinished segments of a download in parallel, one worker per segment. When a worker
+// finishes its segment, it takes over half of the largest remaining one. If a worker fails, the others are cancelled.
+func (s downloader) downloadSegments(download *Download, ctx context.Context) error {
+	var wg sync.WaitGroup
+
+	// Create a context to cancel the remaining workers if one of them fails.
+	workerCtx, cancelWorkers := context.WithCancel(ctx)
+	defer cancelWorkers()
+
 	// Create a channel to listen to the workers' return error.
-	workerErrors := make(chan error)
+	workerErrors := make(chan error, len(download.Segments))
+
+	// Only split segments if the resource supports range downloads.
+	minSplitSize := s.config.MinSplitSize
+	if len(download.Segments) < 2 {
+		minSplitSize = 0
+	}
+
+	// Limit the rate of the workers by the downloader's and the download's limits.
+	limiters := []*ratelimit.Limiter{s.limiter}
+	if download.RateLimit > 0 {
+		limiters = append(limiters, ratelimit.NewLimiter(download.RateLimit))
+	}
+
+	// Copy the segments before starting the workers, which may split them.
+	resource := download.Resource()
+	segments := append([]Segment(nil), download.Segments...)
+	tracker := newSegmentTracker(download, s.storage, minSplitSize)
+	mirrors := newMirrorPool(download, s.config.SlowMirrors)
 
-	for i, segment := range download.Segments {
+	for i := range segments {
 		// Check if segment download already finished.
-		segmentSize, _ := s.storage.GetSegmentSize(segment.Id)
-		segmentOffset := segment.Start + segmentSize
-		if segmentOffset >= segment.End {
+		if tracker.complete(i) {
 			continue
 		}
 
-		// Add progress bar to pool.
-		total := segment.End - segmentOffset
+		// Add progress bar to pool. Streamed segments have no total, only the bytes written and the rate are shown.
+		total := tracker.left(i)
+
 		prefix := color.CyanString(fmt.Sprintf("Worker #%d", i))
-		progressWriter, err := s.progressbar.Add(total, progressbar.Bytes, prefix)
+		bar, err := s.progressbar.Add(total, progressbar.Bytes, prefix)
 		if err != nil {
 			return err
 		}
 
+		tracker.start(i, bar)
+
 		// Worker thread.
 		wg.Add(1)
-		go func(segment Segment, progressWriter io.Writer, segmentOffset int64) {
+		go func(i int, bar progressbar.Bar) {
 			defer wg.Done()
 
-			segmentWriter, err := s.storage.OpenSegment(segment.Id)
-			if err != nil {
-				workerErrors <- err
-				return
-			}
-
-			defer func() { _ = segmentWriter.Close() }()
-
-			writer := io.MultiWriter(segmentWriter, progressWriter)
-			if err := s.network.DownloadResource(download.URL, segmentOffset, segment.End, writer, ctx); err != nil {
-				workerErrors <- err
+			// Distribute the workers across the mirrors. A worker keeps its mirror when it takes over part of another
+			// segment, so that the bytes left to slow mirrors are handed to faster ones.
+			mirror := i
+			for {
+				var err error
+				if mirror, err = s.downloadSegment(resource, mirrors, mirror, tracker, i, bar, limiters, workerCtx); err != nil {
+					workerErrors <- err
+					return
+				}
+
+				// Take over part of another segment.
+				next, ok, err := tracker.split(i)
+				if err != nil {
+					workerErrors <- err
+					return
+				}
+
+				if !ok {
+					return
+				}
+
+				i = next
 			}
-		}(segment, progressWriter, segmentOffset)
+		}(i, bar)
 	}
 
 	_ = s.progressbar.Start()
@@ -134,18 +515,29 @@ func (s downloader) Download(download Download, ctx context.Context) error {
 		wg.Wait()
 	}()
 
-readChannels:
-	for {
+	// Wait for the workers to finish before returning, so that no segment is written afterwards.
+	select {
+	case err := <-workerErrors:
+		cancelWorkers()
+		<-waitGroupDone
+		return err
+	case <-ctx.Done():
+		<-waitGroupDone
+		return UserCancelledDownloadErr
+	case <-waitGroupDone:
 		select {
 		case err := <-workerErrors:
 			return err
-		case <-ctx.Done():
-			return UserCancelledDownloadErr
-		case <-waitGroupDone:
-			break readChannels
+		default:
+			return nil
 		}
 	}
+}
 
+// mergeSegments joins the downloaded segments into the output file, and verifies it against the expected checksum
+// and piece digests, if any. If the resource is encoded by the server and must be decoded, the digests are verified
+// against the encoded bytes, as served, and the output is decoded.
+func (s downloader) mergeSegments(download Download) error {
 	// Open output file in write-only mode with permissions: -rw-r--r--.
 	downloadWriter, err := s.storage.OpenDownloadOutput(download.Id)
 	if err != nil {
@@ -154,9 +546,44 @@ readChannels:
 
 	defer func() { _ = downloadWriter.Close() }()
 
-	// Join the segments into the output file.
+	// If the content coding must be decoded, decode the segments while merging.
+	var outputWriter io.Writer = downloadWriter
+	var decoder *decodingWriter
+	if download.Decode && download.ContentEncoding != "" {
+		if decoder, err = newDecodingWriter(download.ContentEncoding, downloadWriter); err != nil {
+			return err
+		}
+
+		defer func() { _ = decoder.Close() }()
+		outputWriter = decoder
+	}
+
+	// If an expected checksum was provided, hash the output while merging.
+	var outputHash hash.Hash
+	if download.Checksum != nil {
+		if outputHash, err = download.Checksum.Hash(); err != nil {
+			return err
+		}
+
+		outputWriter = io.MultiWriter(outputWriter, outputHash)
+	}
+
+	// If piece digests were provided, verify each piece while merging.
+	var pieceVerifier *PieceVerifier
+	if download.Pieces != nil {
+		if pieceVerifier, err = download.Pieces.Verifier(); err != nil {
+			return err
+		}
+
+		outputWriter = io.MultiWriter(outputWriter, pieceVerifier)
+	}
+
+	// Join the segments into the output file, in the order of their start point, as split segments are appended.
+	segments := append([]Segment(nil), download.Segments...)
+	sort.Slice(segments, func(i, j int) bool { return segments[i].Start < segments[j].Start })
+
 	s.logger.Info("Merging...")
-	for _, segment := range download.Segments {
+	for _, segment := range segments {
 		// Open segment file.
 		segmentReader, err := s.storage.OpenSegment(segment.Id)
 		if err != nil {
@@ -164,11 +591,48 @@ readChannels:
 		}
 
 		// Append worker file to output file.
-		if _, err = io.Copy(downloadWriter, segmentReader); err != nil {
+		_, err = io.Copy(outputWriter, segmentReader)
+		_ = segmentReader.Close()
+		if errors.Is(err, ChecksumMismatchErr) || errors.Is(err, DecodeErr) {
+			return err
+		}
+
+		if err != nil {
 			return BufferCopyErr
 		}
+	}
+
+	// Flush the decoded output.
+	if decoder != nil {
+		if err := decoder.Close(); err != nil {
+			return err
+		}
+
+		download.DecodedSize = decoder.decoded
+		s.logger.Info("Decoded %s content: %s", download.ContentEncoding, fsutil.ReadableMemorySize(download.DecodedSize))
+		if err := s.storage.WriteDownloadSpec(download); err != nil {
+			return err
+		}
+	}
+
+	// Verify the last piece of the merged output.
+	if pieceVerifier != nil {
+		s.logger.Info("Verifying %d %s pieces...", len(download.Pieces.Hashes), download.Pieces.Algorithm)
+		if err := pieceVerifier.Verify(); err != nil {
+			return err
+		}
+	}
+
+	// Verify the merged output against the expected checksum.
+	if download.Checksum != nil {
+		s.logger.Info("Verifying %s checksum...", download.Checksum.Algorithm)
+		if err := download.Checksum.Verify(outputHash); err != nil {
+			return err
+		}
+	}
 
-		// Remove segment file.
+	// Remove the segment files once the output is verified, so that a failed merge can be repeated.
+	for _, segment := range segments {
 		if err := s.storage.DeleteSegment(segment.Id); err != nil {
 			return FilesystemError(err.Error())
 		}
@@ -177,6 +641,132 @@ readChannels:
 	return nil
 }
 
+// downloadSegment downloads the remaining bytes of a segment into its file from a mirror, within the rate of the
+// limiters. If the mirror fails or is much slower than the others, it is dropped and the segment is resumed from
+// another mirror, if any remains; otherwise, transient errors are retried from the last written byte according to the
+// retry policy. Errors writing the segment file are not retried. It returns the mirror the segment was completed from.
+func (s downloader) downloadSegment(resource Resource, mirrors *mirrorPool, mirror int, tracker *segmentTracker, i int, bar progressbar.Bar, limiters []*ratelimit.Limiter, ctx context.Context) (int, error) {
+	segment := tracker.segment(i)
+
+	segmentWriter, err := s.storage.AppendSegment(segment.Id)
+	if err != nil {
+		return mirror, err
+	}
+
+	defer func() { _ = segmentWriter.Close() }()
+
+	file := &fileWriter{writer: segmentWriter}
+	writer := ratelimit.NewWriter(ctx, tracker.writer(i, io.MultiWriter(file, bar)), limiters...)
+
+	for attempt := 1; ; {
+		mirror = mirrors.pick(mirror)
+		resource := mirrors.resource(resource, mirror)
+
+		start, end := tracker.remaining(i)
+		transfer := mirrors.writer(mirror, writer)
+		err := s.network.DownloadResource(resource, start, end, transfer, ctx)
+
+		// The transfer is interrupted by the segment writer if the segment was split in the meantime.
+		if err == nil || tracker.complete(i) {
+			transfer.done()
+			return mirror, nil
+		}
+
+		if ctx.Err() != nil {
+			return mirror, err
+		}
+
+		// The segment file cannot be written, whichever the mirror.
+		if file.err != nil {
+			return mirror, FilesystemError(file.err.Error())
+		}
+
+		// Resume the segment from another mirror once its mirror is dropped for being slow, or by another worker.
+		if transfer.stopped {
+			if transfer.slow {
+				s.logger.Warn("Dropping mirror %s: it is much slower than the others.", mirrors.url(mirror))
+			}
+
+			continue
+		}
+
+		// Drop the failing mirror, and resume the segment from another one without waiting.
+		if mirrorFailed(err) && mirrors.drop(mirror) {
+			if err := tracker.recordRetry(i, err); err != nil {
+				return mirror, err
+			}
+
+			continue
+		}
+
+		// Pin the download to another address of the server if the pinned one is unreachable.
+		if errors.Is(err, PinnedAddressErr) {
+			if err := s.failover(resource, mirrors, tracker); err != nil && !Retryable(err) {
+				return mirror, err
+			}
+		}
+
+		if attempt >= s.config.Retry.MaxAttempts || !Retryable(err) {
+			return mirror, err
+		}
+
+		if err := s.config.Retry.checkRetryAfter(err); err != nil {
+			return mirror, err
+		}
+
+		// Record the failed attempt in the download specification.
+		if err := tracker.recordRetry(i, err); err != nil {
+			return mirror, err
+		}
+
+		if err := sleep(ctx, s.config.Retry.Backoff(attempt, err)); err != nil {
+			return mirror, err
+		}
+
+		attempt++
+	}
+}
+
+// fileWriter records the error writing a segment file, so that it is told apart from the errors of the mirrors.
+type fileWriter struct {
+	writer io.Writer
+	err    error
+}
+
+func (w *fileWriter) Write(p []byte) (int, error) {
+	n, err := w.writer.Write(p)
+	if err != nil {
+		w.err = err
+	}
+
+	return n, err
+}
+
+// failover pins the download to the address of the server which answers its URL once the pinned address is
+// unreachable, provided that it serves the same resource, so that the segments are never spliced from two versions of
+// the resource. Concurrent failures of the same address fail over once.
+func (s downloader) failover(resource Resource, mirrors *mirrorPool, tracker *segmentTracker) error {
+	address, repinned, err := mirrors.repin(resource.Address, func() (string, error) {
+		current, err := s.network.FetchResource(resource.URL)
+		if err != nil {
+			return "", err
+		}
+
+		if current.Changed(resource) {
+			return "", ResourceChangedErr
+		}
+
+		return current.Address, nil
+	})
+	if err != nil || !repinned {
+		return err
+	}
+
+	s.logger.Warn("%s is unreachable, downloading from %s.", resource.Address, address)
+
+	return tracker.recordAddress(address)
+}
+
 // FindAllDownloads finds valid download specifications.
 func (s downloader) FindAllDownloads() ([]Download, error) {
 	return s.storage.ListDownloads()
@@ -210,8 +800,13 @@ func (s downloader) DeleteDownloadById(id string) error {
 }
 
 // NewDownloader instantiates a new Downloader object.
-func NewDownloader(network Network, storage Storage, progressbar progressbar.ProgressBar, logger logger.Logger) Downloader {
-	return &downloader{network, storage, progressbar, logger}
+func NewDownloader(network Network, storage Storage, progressbar progressbar.ProgressBar, logger logger.Logger, config Config) Downloader {
+	var limiter *ratelimit.Limiter
+	if config.RateLimit > 0 {
+		limiter = ratelimit.NewLimiter(config.RateLimit)
+	}
+
+	return &downloader{network, storage, progressbar, logger, config, limiter}
 }
 
 var _ Downloader = (*downloader)(nil)
@@ -5,6 +5,9 @@ package download_test
 
 import (
 	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
 	"fmt"
 	"github.com/MarcoTomasRodriguez/hget/internal/download"
 	"github.com/MarcoTomasRodriguez/hget/mocks"
@@ -14,10 +17,15 @@ import (
 	"github.com/MarcoTomasRodriguez/hget/pkg/progressbar"
 	"github.com/jarcoal/httpmock"
 	"github.com/spf13/afero"
+	"github.com/stretchr/testify/mock"
 	"github.com/stretchr/testify/suite"
+	"io"
 	"math/rand"
 	"net/http"
+	"sort"
+	"strings"
 	"testing"
+	"time"
 )
 
 type DownloaderSuite struct {
@@ -26,6 +34,7 @@ type DownloaderSuite struct {
 	storage  *mocks.Storage
 	logger   logger.Logger
 	progress progressbar.ProgressBar
+	config   download.Config
 }
 
 func (s *DownloaderSuite) SetupTest() {
@@ -33,13 +42,14 @@ func (s *DownloaderSuite) SetupTest() {
 	s.storage = new(mocks.Storage)
 	s.logger = logger.NoopConsoleLogger{}
 	s.progress = progressbar.NoopProgressBar{}
+	s.config = download.Config{Retry: download.RetryPolicy{MaxAttempts: 3}}
 }
 
 func (s *DownloaderSuite) TestDownloader_InitDownload_ShouldLoadAllProperties() {
-	downloader := download.NewDownloader(s.network, s.storage, s.progress, s.logger)
+	downloader := download.NewDownloader(s.network, s.storage, s.progress, s.logger, s.config)
 	s.network.On("FetchResource", javaSample.URL).Return(javaResource, nil)
 
-	spec, err := downloader.InitDownload(javaSample.URL, 4)
+	spec, err := downloader.InitDownload(javaSample.URL, download.Options{Workers: 4})
 	s.NoError(err)
 	s.Len(spec.Id, 8)
 
@@ -57,28 +67,28 @@ func (s *DownloaderSuite) TestDownloader_InitDownload_ShouldLoadAllProperties()
 }
 
 func (s *DownloaderSuite) TestDownloader_InitDownload_ShouldFailIfInvalidURL() {
-	downloader := download.NewDownloader(s.network, s.storage, s.progress, s.logger)
+	downloader := download.NewDownloader(s.network, s.storage, s.progress, s.logger, s.config)
 	s.network.On("FetchResource", "ftp://go.dev/dl/go1.19.1.src.tar.gz").Return(download.Resource{}, httputil.InvalidUrlErr)
 
-	spec, err := downloader.InitDownload("ftp://go.dev/dl/go1.19.1.src.tar.gz", 8)
+	spec, err := downloader.InitDownload("ftp://go.dev/dl/go1.19.1.src.tar.gz", download.Options{Workers: 8})
 	s.Empty(spec)
 	s.ErrorIs(err, httputil.InvalidUrlErr)
 }
 
 func (s *DownloaderSuite) TestDownloader_InitDownload_ShouldFailIfNotFound() {
-	downloader := download.NewDownloader(s.network, s.storage, s.progress, s.logger)
-	s.network.On("FetchResource", "https://test.com/dl/filename.ext").Return(download.Resource{}, httputil.ServerNotAvailableErr)
+	downloader := download.NewDownloader(s.network, s.storage, s.progress, s.logger, s.config)
+	s.network.On("FetchResource", "https://test.com/dl/filename.ext").Return(download.Resource{}, download.NetworkError("server not available"))
 
-	spec, err := downloader.InitDownload("https://test.com/dl/filename.ext", 8)
+	spec, err := downloader.InitDownload("https://test.com/dl/filename.ext", download.Options{Workers: 8})
 	s.Empty(spec)
-	s.ErrorIs(err, httputil.ServerNotAvailableErr)
+	s.ErrorIs(err, download.NetworkError("server not available"))
 }
 
 func (s *DownloaderSuite) TestDownloader_InitDownload_ShouldFailIfInvalidFilename() {
-	downloader := download.NewDownloader(s.network, s.storage, s.progress, s.logger)
+	downloader := download.NewDownloader(s.network, s.storage, s.progress, s.logger, s.config)
 	s.network.On("FetchResource", "https://go.dev/dl/-invalid.filename").Return(download.Resource{}, download.InvalidFilenameErr)
 
-	spec, err := downloader.InitDownload("https://go.dev/dl/-invalid.filename", 8)
+	spec, err := downloader.InitDownload("https://go.dev/dl/-invalid.filename", download.Options{Workers: 8})
 	s.Empty(spec)
 	s.ErrorIs(err, download.InvalidFilenameErr)
 }
@@ -87,16 +97,98 @@ func (s *DownloaderSuite) TestDownloader_InitDownload_ShouldDisableRangeDownload
 	resource := golangResource
 	resource.AcceptRanges = false
 
-	downloader := download.NewDownloader(s.network, s.storage, s.progress, s.logger)
+	downloader := download.NewDownloader(s.network, s.storage, s.progress, s.logger, s.config)
 	s.network.On("FetchResource", golangSample.URL).Return(resource, nil)
 
-	spec, err := downloader.InitDownload(golangSample.URL, 8)
+	spec, err := downloader.InitDownload(golangSample.URL, download.Options{Workers: 8})
 	s.NoError(err)
 	s.Equal(golangSample.Name, spec.Name)
 	s.Equal(golangSample.Segments[0].Start, spec.Segments[0].Start)
 	s.Equal(golangSample.Segments[0].End, spec.Segments[0].End)
 }
 
+func (s *DownloaderSuite) TestDownloader_InitDownload_ShouldDiscoverChecksum() {
+	downloader := download.NewDownloader(s.network, s.storage, s.progress, s.logger, s.config)
+	s.network.On("FetchResource", golangSample.URL).Return(golangResource, nil)
+	s.network.On("ReadResource", golangSample.URL+".sha512").Return(nil, download.NetworkError("404 Not Found"))
+	s.network.On("ReadResource", golangSample.URL+".sha256").Return([]byte(helloSha256+"\n"), nil)
+
+	spec, err := downloader.InitDownload(golangSample.URL, download.Options{Workers: 8, ChecksumAuto: true})
+	s.NoError(err)
+	s.Equal(&download.Checksum{Algorithm: download.SHA256, Digest: helloSha256}, spec.Checksum)
+}
+
+func (s *DownloaderSuite) TestDownloader_InitDownload_ShouldPreferProvidedChecksum() {
+	checksum := &download.Checksum{Algorithm: download.MD5, Digest: "5d41402abc4b2a76b9719d911017c592"}
+
+	downloader := download.NewDownloader(s.network, s.storage, s.progress, s.logger, s.config)
+	s.network.On("FetchResource", golangSample.URL).Return(golangResource, nil)
+
+	spec, err := downloader.InitDownload(golangSample.URL, download.Options{Workers: 8, Checksum: checksum, ChecksumAuto: true})
+	s.NoError(err)
+	s.Equal(checksum, spec.Checksum)
+	s.network.AssertNotCalled(s.T(), "ReadResource", mock.Anything)
+}
+
+func (s *DownloaderSuite) TestDownloader_ValidateDownload() {
+	resource := javaResource
+	resource.ETag = `"v1"`
+
+	spec := javaSample
+	spec.ETag = `"v1"`
+
+	downloader := download.NewDownloader(s.network, s.storage, s.progress, s.logger, s.config)
+	s.network.On("FetchResource", javaSample.URL).Return(resource, nil).Once()
+	validated, err := downloader.ValidateDownload(spec)
+	s.NoError(err)
+	s.Equal(spec, validated)
+
+	resource.ETag = `"v2"`
+	s.network.On("FetchResource", javaSample.URL).Return(resource, nil).Once()
+	_, err = downloader.ValidateDownload(spec)
+	s.ErrorIs(err, download.ResourceChangedErr)
+}
+
+func (s *DownloaderSuite) TestDownloader_ValidateDownload_ShouldResolveURLAgain() {
+	spec := javaSample
+	spec.ResolvedURL = "https://cdn.example.com/expired/" + javaSample.Name
+
+	resource := javaResource
+	resource.URL, resource.OriginalURL = "https://cdn.example.com/fresh/"+javaSample.Name, javaSample.URL
+
+	downloader := download.NewDownloader(s.network, s.storage, s.progress, s.logger, s.config)
+	s.network.On("FetchResource", javaSample.URL).Return(resource, nil).Once()
+
+	validated, err := downloader.ValidateDownload(spec)
+	s.NoError(err)
+	s.Equal(javaSample.URL, validated.URL)
+	s.Equal(resource.URL, validated.ResolvedURL)
+	s.Equal(resource.URL, validated.Resource().URL)
+}
+
+func (s *DownloaderSuite) TestDownloader_RestartDownload() {
+	resource := javaResource
+	resource.Size = 4000
+	resource.ETag = `"v2"`
+
+	spec := javaSample
+	spec.ETag = `"v1"`
+
+	downloader := download.NewDownloader(s.network, s.storage, s.progress, s.logger, s.config)
+	s.network.On("FetchResource", javaSample.URL).Return(resource, nil)
+	s.storage.On("DeleteSegment", mock.Anything).Return(nil)
+	s.storage.On("WriteDownloadSpec", mock.Anything).Return(nil)
+
+	spec, err := downloader.RestartDownload(spec)
+	s.NoError(err)
+	s.Equal(javaSample.Id, spec.Id)
+	s.Equal(`"v2"`, spec.ETag)
+	s.Equal(int64(4000), spec.Size)
+	s.Len(spec.Segments, len(javaSample.Segments))
+	s.Equal(int64(4000), spec.Segments[len(spec.Segments)-1].End)
+	s.storage.AssertNumberOfCalls(s.T(), "DeleteSegment", len(javaSample.Segments))
+}
+
 func (s *DownloaderSuite) TestDownloader_Download() {
 	httpmock.Activate()
 	defer httpmock.DeactivateAndReset()
@@ -104,7 +196,7 @@ func (s *DownloaderSuite) TestDownloader_Download() {
 	fs := afero.NewMemMapFs()
 	afs := afero.Afero{Fs: fs}
 	yamlCodec := codec.NewYAMLCodec()
-	downloader := download.NewDownloader(download.NewNetwork(), download.NewStorage(fs, yamlCodec), s.progress, s.logger)
+	downloader := download.NewDownloader(download.NewNetwork(), download.NewStorage(fs, yamlCodec), s.progress, s.logger, s.config)
 
 	content := make([]byte, javaSample.Size)
 	rand.Read(content)
@@ -118,10 +210,520 @@ func (s *DownloaderSuite) TestDownloader_Download() {
 	s.Equal(content, fileContent)
 }
 
+func (s *DownloaderSuite) TestDownloader_Download_ShouldVerifyChecksum() {
+	httpmock.Activate()
+	defer httpmock.DeactivateAndReset()
+
+	fs := afero.NewMemMapFs()
+	downloader := download.NewDownloader(download.NewNetwork(), download.NewStorage(fs, codec.NewYAMLCodec()), s.progress, s.logger, s.config)
+
+	content := make([]byte, javaSample.Size)
+	rand.Read(content)
+	sum := sha256.Sum256(content)
+
+	httputil.RegisterResponder(javaSample.URL, content, http.Header{"Accept-Ranges": []string{"bytes"}})
+
+	spec := javaSample
+	spec.Checksum = &download.Checksum{Algorithm: download.SHA256, Digest: hex.EncodeToString(sum[:])}
+
+	err := downloader.Download(spec, context.TODO())
+	s.NoError(err)
+}
+
+func (s *DownloaderSuite) TestDownloader_Download_ShouldVerifyPieces() {
+	httpmock.Activate()
+	defer httpmock.DeactivateAndReset()
+
+	fs := afero.NewMemMapFs()
+	downloader := download.NewDownloader(download.NewNetwork(), download.NewStorage(fs, codec.NewYAMLCodec()), s.progress, s.logger, s.config)
+
+	content := make([]byte, javaSample.Size)
+	rand.Read(content)
+
+	pieces := &download.Pieces{Algorithm: download.SHA256, Length: 1000}
+	for offset := 0; offset < len(content); offset += 1000 {
+		end := offset + 1000
+		if end > len(content) {
+			end = len(content)
+		}
+
+		sum := sha256.Sum256(content[offset:end])
+		pieces.Hashes = append(pieces.Hashes, hex.EncodeToString(sum[:]))
+	}
+
+	// The second piece is corrupted.
+	pieces.Hashes[1] = helloSha256
+
+	httputil.RegisterResponder(javaSample.URL, content, http.Header{"Accept-Ranges": []string{"bytes"}})
+
+	spec := javaSample
+	spec.Pieces = pieces
+
+	err := downloader.Download(spec, context.TODO())
+	s.ErrorIs(err, download.ChecksumMismatchErr)
+	s.ErrorContains(err, "piece 1")
+}
+
+func (s *DownloaderSuite) TestDownloader_Download_ShouldFailIfChecksumMismatch() {
+	httpmock.Activate()
+	defer httpmock.DeactivateAndReset()
+
+	fs := afero.NewMemMapFs()
+	afs := afero.Afero{Fs: fs}
+	downloader := download.NewDownloader(download.NewNetwork(), download.NewStorage(fs, codec.NewYAMLCodec()), s.progress, s.logger, s.config)
+
+	content := make([]byte, javaSample.Size)
+	rand.Read(content)
+
+	httputil.RegisterResponder(javaSample.URL, content, http.Header{"Accept-Ranges": []string{"bytes"}})
+
+	spec := javaSample
+	spec.Checksum = &download.Checksum{Algorithm: download.SHA256, Digest: helloSha256}
+
+	err := downloader.Download(spec, context.TODO())
+	s.ErrorIs(err, download.ChecksumMismatchErr)
+
+	// The output should be kept in the download folder.
+	exists, _ := afs.Exists(fmt.Sprintf("%s/output", javaSample.Id))
+	s.True(exists)
+}
+
+func (s *DownloaderSuite) TestDownloader_Download_ShouldResumeAfterChecksumMismatch() {
+	httpmock.Activate()
+	defer httpmock.DeactivateAndReset()
+
+	fs := afero.NewMemMapFs()
+	afs := afero.Afero{Fs: fs}
+	downloader := download.NewDownloader(download.NewNetwork(), download.NewStorage(fs, codec.NewYAMLCodec()), s.progress, s.logger, s.config)
+
+	content := make([]byte, javaSample.Size)
+	rand.Read(content)
+	sum := sha256.Sum256(content)
+
+	// The server first sends a corrupted file.
+	corrupted := append([]byte(nil), content...)
+	corrupted[0] ^= 0xff
+	httputil.RegisterResponder(javaSample.URL, corrupted, http.Header{"Accept-Ranges": []string{"bytes"}})
+
+	spec := javaSample
+	spec.Checksum = &download.Checksum{Algorithm: download.SHA256, Digest: hex.EncodeToString(sum[:])}
+
+	s.ErrorIs(downloader.Download(spec, context.TODO()), download.ChecksumMismatchErr)
+
+	// The segments are kept, and merged again into the same output.
+	for _, segment := range spec.Segments {
+		exists, _ := afs.Exists(segment.Id)
+		s.True(exists)
+	}
+
+	s.ErrorIs(downloader.Download(spec, context.TODO()), download.ChecksumMismatchErr)
+
+	fileContent, _ := afs.ReadFile(fmt.Sprintf("%s/output", spec.Id))
+	s.Equal(corrupted, fileContent)
+
+	// Once restarted, the output only holds the file downloaded again.
+	httputil.RegisterResponder(javaSample.URL, content, http.Header{"Accept-Ranges": []string{"bytes"}})
+
+	spec, err := downloader.RestartDownload(spec)
+	s.Require().NoError(err)
+	s.NoError(downloader.Download(spec, context.TODO()))
+
+	fileContent, _ = afs.ReadFile(fmt.Sprintf("%s/output", spec.Id))
+	s.Equal(content, fileContent)
+
+	for _, segment := range spec.Segments {
+		exists, _ := afs.Exists(segment.Id)
+		s.False(exists)
+	}
+}
+
+func (s *DownloaderSuite) TestDownloader_Download_ShouldRetryFromWrittenOffset() {
+	fs := afero.NewMemMapFs()
+	afs := afero.Afero{Fs: fs}
+	downloader := download.NewDownloader(s.network, download.NewStorage(fs, codec.NewYAMLCodec()), s.progress, s.logger, s.config)
+
+	content := make([]byte, golangSample.Size)
+	rand.Read(content)
+
+	// The first attempt is interrupted after writing 100 bytes.
+	s.network.On("DownloadResource", golangSample.Resource(), int64(0), golangSample.Size, mock.Anything, mock.Anything).
+		Run(func(args mock.Arguments) { _, _ = args.Get(3).(io.Writer).Write(content[:100]) }).
+		Return(download.NetworkError("connection reset")).Once()
+
+	// The second attempt resumes after the written bytes.
+	s.network.On("DownloadResource", golangSample.Resource(), int64(100), golangSample.Size, mock.Anything, mock.Anything).
+		Run(func(args mock.Arguments) { _, _ = args.Get(3).(io.Writer).Write(content[100:]) }).
+		Return(nil).Once()
+
+	err := downloader.Download(golangSample, context.TODO())
+	s.NoError(err)
+
+	fileContent, _ := afs.ReadFile(fmt.Sprintf("%s/output", golangSample.Id))
+	s.Equal(content, fileContent)
+
+	spec, _ := download.NewStorage(fs, codec.NewYAMLCodec()).ReadDownloadSpec(golangSample.Id)
+	s.Equal(1, spec.Segments[0].Retries)
+	s.Equal("network error: connection reset", spec.Segments[0].LastError)
+}
+
+func (s *DownloaderSuite) TestDownloader_Download_ShouldNotRetryPermanentErrors() {
+	fs := afero.NewMemMapFs()
+	downloader := download.NewDownloader(s.network, download.NewStorage(fs, codec.NewYAMLCodec()), s.progress, s.logger, s.config)
+
+	notFound := download.StatusError{StatusCode: http.StatusNotFound, Status: "404 Not Found"}
+	s.network.On("DownloadResource", mock.Anything, mock.Anything, mock.Anything, mock.Anything, mock.Anything).Return(notFound)
+
+	err := downloader.Download(golangSample, context.TODO())
+	s.ErrorIs(err, notFound)
+	s.network.AssertNumberOfCalls(s.T(), "DownloadResource", 1)
+}
+
+func (s *DownloaderSuite) TestDownloader_Download_ShouldStopAfterMaxAttempts() {
+	fs := afero.NewMemMapFs()
+	downloader := download.NewDownloader(s.network, download.NewStorage(fs, codec.NewYAMLCodec()), s.progress, s.logger, s.config)
+
+	s.network.On("DownloadResource", mock.Anything, mock.Anything, mock.Anything, mock.Anything, mock.Anything).Return(download.BufferCopyErr)
+
+	err := downloader.Download(golangSample, context.TODO())
+	s.ErrorIs(err, download.BufferCopyErr)
+	s.network.AssertNumberOfCalls(s.T(), "DownloadResource", s.config.Retry.MaxAttempts)
+}
+
+func (s *DownloaderSuite) TestDownloader_Download_ShouldFailIfRetryAfterTooLong() {
+	config := s.config
+	config.Retry.MaxRetryAfter = time.Second
+	downloader := download.NewDownloader(s.network, download.NewStorage(afero.NewMemMapFs(), codec.NewYAMLCodec()), s.progress, s.logger, config)
+
+	// The server asks to retry the next day.
+	unavailable := download.StatusError{StatusCode: http.StatusServiceUnavailable, Status: "503 Service Unavailable", RetryAfter: 24 * time.Hour}
+	s.network.On("DownloadResource", mock.Anything, mock.Anything, mock.Anything, mock.Anything, mock.Anything).Return(unavailable)
+
+	done := make(chan error)
+	go func() { done <- downloader.Download(golangSample, context.TODO()) }()
+
+	select {
+	case err := <-done:
+		s.ErrorIs(err, unavailable)
+		s.ErrorContains(err, "24h0m0s")
+	case <-time.After(5 * time.Second):
+		s.FailNow("the download waited for the requested delay")
+	}
+
+	s.network.AssertNumberOfCalls(s.T(), "DownloadResource", len(golangSample.Segments))
+}
+
+func (s *DownloaderSuite) TestDownloader_Download_ShouldFallBackToSingleStream() {
+	httpmock.Activate()
+	defer httpmock.DeactivateAndReset()
+
+	fs := afero.NewMemMapFs()
+	afs := afero.Afero{Fs: fs}
+	storage := download.NewStorage(fs, codec.NewYAMLCodec())
+	downloader := download.NewDownloader(download.NewNetwork(), storage, s.progress, s.logger, s.config)
+
+	content := make([]byte, javaSample.Size)
+	rand.Read(content)
+
+	// The server ignores the Range header.
+	httpmock.RegisterResponder("GET", javaSample.URL, httpmock.NewBytesResponder(http.StatusOK, content))
+
+	err := downloader.Download(javaSample, context.TODO())
+	s.NoError(err)
+
+	fileContent, _ := afs.ReadFile(fmt.Sprintf("%s/output", javaSample.Id))
+	s.Equal(content, fileContent)
+
+	spec, _ := storage.ReadDownloadSpec(javaSample.Id)
+	s.Len(spec.Segments, 1)
+
+	// The server ignores the If-Range header as well, and sends the same resource.
+	validated := javaSample
+	validated.Id, validated.ETag, validated.LastModified = "v4lid4t3", `"v1"`, "Wed, 21 Oct 2015 07:28:00 GMT"
+	validated.Segments = append([]download.Segment(nil), javaSample.Segments...)
+	for i := range validated.Segments {
+		validated.Segments[i].Id = strings.Replace(validated.Segments[i].Id, javaSample.Id, validated.Id, 1)
+	}
+
+	httpmock.RegisterResponder("GET", javaSample.URL, func(request *http.Request) (*http.Response, error) {
+		response := httpmock.NewBytesResponse(http.StatusOK, content)
+		response.Header.Set("ETag", validated.ETag)
+		response.Header.Set("Last-Modified", validated.LastModified)
+		response.ContentLength = int64(len(content))
+		return response, nil
+	})
+
+	s.NoError(downloader.Download(validated, context.TODO()))
+
+	fileContent, _ = afs.ReadFile(fmt.Sprintf("%s/output", validated.Id))
+	s.Equal(content, fileContent)
+
+	spec, _ = storage.ReadDownloadSpec(validated.Id)
+	s.Len(spec.Segments, 1)
+}
+
+func (s *DownloaderSuite) TestDownloader_Download_ShouldFailIfIgnoredRangeChangedResource() {
+	httpmock.Activate()
+	defer httpmock.DeactivateAndReset()
+
+	downloader := download.NewDownloader(download.NewNetwork(), download.NewStorage(afero.NewMemMapFs(), codec.NewYAMLCodec()), s.progress, s.logger, s.config)
+
+	spec := javaSample
+	spec.ETag = `"v1"`
+
+	// The server ignores the Range header, and sends another version of the resource.
+	httpmock.RegisterResponder("GET", javaSample.URL, func(request *http.Request) (*http.Response, error) {
+		response := httpmock.NewBytesResponse(http.StatusOK, make([]byte, javaSample.Size))
+		response.Header.Set("ETag", `"v2"`)
+		return response, nil
+	})
+
+	s.ErrorIs(downloader.Download(spec, context.TODO()), download.ResourceChangedErr)
+}
+
+func (s *DownloaderSuite) TestDownloader_InitDownload_ShouldDropMismatchingMirrors() {
+	downloader := download.NewDownloader(s.network, s.storage, s.progress, s.logger, s.config)
+	mirror := javaResource
+	mirror.URL = "https://mirror.java.com/jre-8u351-macosx-x64.dmg"
+	outdated := javaResource
+	outdated.URL = "https://outdated.java.com/jre-8u351-macosx-x64.dmg"
+	outdated.Size = 1000
+
+	s.network.On("FetchResource", javaSample.URL).Return(javaResource, nil)
+	s.network.On("FetchResource", mirror.URL).Return(mirror, nil)
+	s.network.On("FetchResource", outdated.URL).Return(outdated, nil)
+
+	spec, err := downloader.InitDownload(javaSample.URL, download.Options{Workers: 4, Mirrors: []string{mirror.URL, outdated.URL}})
+	s.NoError(err)
+	s.Equal([]string{mirror.URL}, spec.Mirrors)
+}
+
+func (s *DownloaderSuite) TestDownloader_InitDownload_ShouldLoadMetalink() {
+	downloader := download.NewDownloader(s.network, s.storage, s.progress, s.logger, s.config)
+	metalinkURL := "https://test.com/hello.txt.meta4"
+	content := fmt.Sprintf(helloMetalink, sha1Hex("he"), sha1Hex("ll"), sha1Hex("o"))
+	hello := download.Resource{Filename: "hello.txt", Size: 5, AcceptRanges: true}
+
+	mirror1, mirror2 := hello, hello
+	mirror1.URL, mirror2.URL = "https://mirror1.test/hello.txt", "https://mirror2.test/hello.txt"
+	mirror1.ETag, mirror2.ETag = `"a"`, `"b"`
+
+	s.network.On("FetchResource", metalinkURL).Return(download.Resource{URL: metalinkURL, ContentType: download.MetalinkMediaType}, nil)
+	s.network.On("ReadResource", metalinkURL).Return([]byte(content), nil)
+	s.network.On("FetchResource", mirror1.URL).Return(mirror1, nil)
+	s.network.On("FetchResource", mirror2.URL).Return(mirror2, nil)
+	s.network.On("FetchResource", "https://mirror3.test/hello.txt").Return(download.Resource{}, download.NetworkError("unreachable"))
+
+	spec, err := downloader.InitDownload(metalinkURL, download.Options{Workers: 2})
+	s.NoError(err)
+	s.Equal("hello.txt", spec.Name)
+	s.Equal(mirror1.URL, spec.URL)
+	s.Equal([]string{mirror2.URL}, spec.Mirrors)
+	s.Equal(&download.Checksum{Algorithm: download.SHA256, Digest: helloSha256}, spec.Checksum)
+	s.Equal(download.SHA1, spec.Pieces.Algorithm)
+	s.Len(spec.Segments, 2)
+}
+
+func (s *DownloaderSuite) TestDownloader_Download_ShouldDropFailingMirror() {
+	httpmock.Activate()
+	defer httpmock.DeactivateAndReset()
+
+	fs := afero.NewMemMapFs()
+	afs := afero.Afero{Fs: fs}
+	storage := download.NewStorage(fs, codec.NewYAMLCodec())
+	downloader := download.NewDownloader(download.NewNetwork(), storage, s.progress, s.logger, s.config)
+
+	content := make([]byte, javaSample.Size)
+	rand.Read(content)
+
+	mirror := "https://mirror.java.com/jre-8u351-macosx-x64.dmg"
+	httputil.RegisterResponder(javaSample.URL, content, http.Header{"Accept-Ranges": []string{"bytes"}})
+	httpmock.RegisterResponder("GET", mirror, httpmock.NewStringResponder(http.StatusNotFound, ""))
+
+	spec := javaSample
+	spec.Mirrors = []string{mirror}
+
+	err := downloader.Download(spec, context.TODO())
+	s.NoError(err)
+
+	fileContent, _ := afs.ReadFile(fmt.Sprintf("%s/output", javaSample.Id))
+	s.Equal(content, fileContent)
+	s.Positive(httpmock.GetCallCountInfo()["GET "+mirror])
+}
+
+func (s *DownloaderSuite) TestDownloader_Download_ShouldNotDropMirrorOnFileError() {
+	downloader := download.NewDownloader(s.network, s.storage, s.progress, s.logger, s.config)
+
+	spec := javaSample
+	spec.Mirrors = []string{"https://mirror.java.com/jre-8u351-macosx-x64.dmg"}
+
+	// The segment files cannot be written.
+	s.storage.On("GetSegmentSize", mock.Anything).Return(int64(0), nil)
+	s.storage.On("WriteDownloadSpec", mock.Anything).Return(nil)
+	s.storage.On("AppendSegment", mock.Anything).Return(fullDiskWriter{}, nil)
+
+	serve := func(resource download.Resource, start int64, end int64, writer io.Writer, ctx context.Context) error {
+		if _, err := writer.Write([]byte("data")); err != nil {
+			return download.BufferCopyErr
+		}
+
+		return nil
+	}
+	s.network.On("DownloadResource", mock.Anything, mock.Anything, mock.Anything, mock.Anything, mock.Anything).Return(serve)
+
+	var filesystemErr download.FilesystemError
+	s.ErrorAs(downloader.Download(spec, context.TODO()), &filesystemErr)
+
+	// Each worker failed once on its own mirror, without moving to the other one or retrying.
+	requested := map[string]int{}
+	for _, call := range s.network.Calls {
+		requested[call.Arguments.Get(0).(download.Resource).URL]++
+	}
+
+	s.Equal(map[string]int{spec.URL: len(spec.Segments) / 2, spec.Mirrors[0]: len(spec.Segments) / 2}, requested)
+}
+
+func (s *DownloaderSuite) TestDownloader_Download_ShouldSplitLargestSegment() {
+	fs := afero.NewMemMapFs()
+	afs := afero.Afero{Fs: fs}
+	storage := download.NewStorage(fs, codec.NewYAMLCodec())
+	config := s.config
+	config.MinSplitSize = 100
+	downloader := download.NewDownloader(s.network, storage, s.progress, s.logger, config)
+
+	spec := download.Download{
+		Id:   "s9lit7ed",
+		Name: "file.bin",
+		URL:  "https://test.com/file.bin",
+		Size: 4000,
+		Segments: []download.Segment{
+			{Id: "s9lit7ed/segment.00", Start: 0, End: 99},
+			{Id: "s9lit7ed/segment.01", Start: 100, End: 4000},
+		},
+	}
+
+	content := make([]byte, spec.Size)
+	rand.Read(content)
+
+	// Serve the requested range in small chunks, slowly for the large segment, until the writer stops accepting bytes.
+	serve := func(resource download.Resource, start int64, end int64, writer io.Writer, ctx context.Context) error {
+		if end >= spec.Size {
+			end = spec.Size - 1
+		}
+
+		for offset := start; offset <= end; offset += 50 {
+			chunkEnd := offset + 50
+			if chunkEnd > end+1 {
+				chunkEnd = end + 1
+			}
+
+			if _, err := writer.Write(content[offset:chunkEnd]); err != nil {
+				return download.BufferCopyErr
+			}
+
+			if start == 100 {
+				time.Sleep(time.Millisecond)
+			}
+		}
+
+		return nil
+	}
+	s.network.On("DownloadResource", mock.Anything, mock.Anything, mock.Anything, mock.Anything, mock.Anything).Return(serve)
+
+	err := downloader.Download(spec, context.TODO())
+	s.NoError(err)
+
+	fileContent, _ := afs.ReadFile(fmt.Sprintf("%s/output", spec.Id))
+	s.Equal(content, fileContent)
+
+	// The new segments were persisted, and cover the resource contiguously.
+	saved, _ := storage.ReadDownloadSpec(spec.Id)
+	s.Greater(len(saved.Segments), 2)
+
+	sort.Slice(saved.Segments, func(i, j int) bool { return saved.Segments[i].Start < saved.Segments[j].Start })
+	for i := 1; i < len(saved.Segments); i++ {
+		s.Equal(saved.Segments[i-1].End+1, saved.Segments[i].Start)
+	}
+}
+
+func (s *DownloaderSuite) TestDownloader_Download_ShouldResumeSplitSegments() {
+	httpmock.Activate()
+	defer httpmock.DeactivateAndReset()
+
+	fs := afero.NewMemMapFs()
+	afs := afero.Afero{Fs: fs}
+	downloader := download.NewDownloader(download.NewNetwork(), download.NewStorage(fs, codec.NewYAMLCodec()), s.progress, s.logger, s.config)
+
+	content := make([]byte, javaSample.Size)
+	rand.Read(content)
+	httputil.RegisterResponder(javaSample.URL, content, http.Header{"Accept-Ranges": []string{"bytes"}})
+
+	// The last segment was split, and its second half appended to the segments.
+	spec := javaSample
+	spec.Segments = []download.Segment{
+		{Id: "ita2qybt/segment.00", Start: 0, End: 1289},
+		{Id: "ita2qybt/segment.01", Start: 1290, End: 1999},
+		{Id: "ita2qybt/segment.02", Start: 2000, End: 2583},
+	}
+	_ = afs.WriteFile("ita2qybt/segment.00", content[0:1290], 0644)
+	_ = afs.WriteFile("ita2qybt/segment.01", content[1290:1500], 0644)
+
+	err := downloader.Download(spec, context.TODO())
+	s.NoError(err)
+
+	fileContent, _ := afs.ReadFile(fmt.Sprintf("%s/output", spec.Id))
+	s.Equal(content, fileContent)
+}
+
+func (s *DownloaderSuite) TestDownloader_Download_ShouldResumeSegmentMissingLastByte() {
+	httpmock.Activate()
+	defer httpmock.DeactivateAndReset()
+
+	fs := afero.NewMemMapFs()
+	afs := afero.Afero{Fs: fs}
+	downloader := download.NewDownloader(download.NewNetwork(), download.NewStorage(fs, codec.NewYAMLCodec()), s.progress, s.logger, s.config)
+
+	content := make([]byte, javaSample.Size)
+	rand.Read(content)
+	httputil.RegisterResponder(javaSample.URL, content, http.Header{"Accept-Ranges": []string{"bytes"}})
+
+	// The end points of the segments are inclusive: the first segment is missing its last byte.
+	spec := javaSample
+	spec.Segments = []download.Segment{
+		{Id: "ita2qybt/segment.00", Start: 0, End: 1289},
+		{Id: "ita2qybt/segment.01", Start: 1290, End: 2583},
+	}
+	_ = afs.WriteFile("ita2qybt/segment.00", content[0:1289], 0644)
+
+	err := downloader.Download(spec, context.TODO())
+	s.NoError(err)
+
+	fileContent, _ := afs.ReadFile(fmt.Sprintf("%s/output", spec.Id))
+	s.Equal(content, fileContent)
+}
+
+func (s *DownloaderSuite) TestDownloader_Download_ShouldLimitRate() {
+	httpmock.Activate()
+	defer httpmock.DeactivateAndReset()
+
+	fs := afero.NewMemMapFs()
+	downloader := download.NewDownloader(download.NewNetwork(), download.NewStorage(fs, codec.NewYAMLCodec()), s.progress, s.logger, s.config)
+
+	httputil.RegisterResponder(javaSample.URL, make([]byte, javaSample.Size), http.Header{"Accept-Ranges": []string{"bytes"}})
+
+	// The limit is shared by the 4 workers: the first 1000 bytes are allowed at once, the rest takes ~150ms.
+	spec := javaSample
+	spec.RateLimit = 10_000
+
+	start := time.Now()
+	err := downloader.Download(spec, context.TODO())
+	s.NoError(err)
+	s.GreaterOrEqual(time.Since(start), 120*time.Millisecond)
+}
+
 func (s *DownloaderSuite) TestDownloader_GetDownloadByUrl() {
 	s.storage.On("ListDownloads").Return([]download.Download{golangSample, javaSample}, nil)
 
-	downloader := download.NewDownloader(s.network, s.storage, s.progress, s.logger)
+	downloader := download.NewDownloader(s.network, s.storage, s.progress, s.logger, s.config)
 	spec, err := downloader.FindDownloadByUrl(javaSample.URL)
 
 	s.NoError(err)
@@ -131,3 +733,14 @@ func (s *DownloaderSuite) TestDownloader_GetDownloadByUrl() {
 func TestDownloaderSuite(t *testing.T) {
 	suite.Run(t, new(DownloaderSuite))
 }
+
+// fullDiskWriter fails to write, as a file on a full disk.
+type fullDiskWriter struct{}
+
+func (fullDiskWriter) Write([]byte) (int, error) {
+	return 0, errors.New("no space left on device")
+}
+
+func (fullDiskWriter) Close() error {
+	return nil
+}
@@ -0,0 +1,104 @@
+package download
+
+import (
+	"compress/gzip"
+	"compress/zlib"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"sync"
+)
+
+var (
+	UnsupportedEncodingErr = errors.New("unsupported content encoding")
+	UnexpectedEncodingErr  = errors.New("unexpected content encoding")
+	DecodeErr              = errors.New("could not decode content")
+)
+
+// decoders create the readers decoding the supported content codings.
+var decoders = map[string]func(io.Reader) (io.Reader, error){
+	"gzip":    func(reader io.Reader) (io.Reader, error) { return gzip.NewReader(reader) },
+	"x-gzip":  func(reader io.Reader) (io.Reader, error) { return gzip.NewReader(reader) },
+	"deflate": func(reader io.Reader) (io.Reader, error) { return zlib.NewReader(reader) },
+}
+
+// contentEncoding returns the content coding applied by the server to a response, or an empty string if none.
+func contentEncoding(response *http.Response) string {
+	encoding := strings.ToLower(strings.TrimSpace(response.Header.Get("Content-Encoding")))
+	if encoding == "identity" {
+		return ""
+	}
+
+	return encoding
+}
+
+// checkEncoding returns UnsupportedEncodingErr if the content coding cannot be decoded.
+func checkEncoding(encoding string) error {
+	if _, ok := decoders[encoding]; !ok {
+		return fmt.Errorf("%w: %s", UnsupportedEncodingErr, encoding)
+	}
+
+	return nil
+}
+
+// decodingWriter decodes the content written to it into another writer, as it is written.
+type decodingWriter struct {
+	pipe      *io.PipeWriter
+	done      chan error
+	closeOnce sync.Once
+	closeErr  error
+	// decoded is the number of decoded bytes written, set once the writer is closed.
+	decoded int64
+}
+
+// newDecodingWriter creates a writer decoding the content coding into the writer. It must be closed to flush the
+// decoded content.
+func newDecodingWriter(encoding string, writer io.Writer) (*decodingWriter, error) {
+	if err := checkEncoding(encoding); err != nil {
+		return nil, err
+	}
+
+	pipeReader, pipeWriter := io.Pipe()
+	w := &decodingWriter{pipe: pipeWriter, done: make(chan error, 1)}
+
+	go func() {
+		decoder, err := decoders[encoding](pipeReader)
+		if err == nil {
+			w.decoded, err = io.Copy(writer, decoder)
+		}
+
+		// Unblock the writes if the decoding stopped before the end of the content.
+		_ = pipeReader.CloseWithError(err)
+		w.done <- err
+	}()
+
+	return w, nil
+}
+
+func (w *decodingWriter) Write(p []byte) (int, error) {
+	n, err := w.pipe.Write(p)
+	if errors.Is(err, io.ErrClosedPipe) {
+		return n, DecodeErr
+	}
+
+	if err != nil {
+		return n, fmt.Errorf("%w: %v", DecodeErr, err)
+	}
+
+	return n, nil
+}
+
+// Close flushes the decoded content, and returns the error of the decoding, if any. It may be called several times.
+func (w *decodingWriter) Close() error {
+	w.closeOnce.Do(func() {
+		_ = w.pipe.Close()
+
+		if err := <-w.done; err != nil {
+			w.closeErr = fmt.Errorf("%w: %v", DecodeErr, err)
+		}
+	})
+
+	return w.closeErr
+}
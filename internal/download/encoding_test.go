@@ -0,0 +1,182 @@
+package download_test
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"github.com/MarcoTomasRodriguez/hget/internal/download"
+	"github.com/MarcoTomasRodriguez/hget/pkg/codec"
+	"github.com/MarcoTomasRodriguez/hget/pkg/logger"
+	"github.com/MarcoTomasRodriguez/hget/pkg/progressbar"
+	"github.com/spf13/afero"
+	"github.com/stretchr/testify/suite"
+	"math/rand"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+type EncodingSuite struct {
+	suite.Suite
+	content []byte
+	// encoded is the content compressed with gzip.
+	encoded []byte
+	server  *httptest.Server
+	mu      sync.Mutex
+	// acceptEncodings are the Accept-Encoding headers of the GET requests.
+	acceptEncodings []string
+}
+
+func (s *EncodingSuite) SetupTest() {
+	// Compressible content, so that the encoded size differs from the decoded one.
+	s.content = bytes.Repeat([]byte("hget "), 2000)
+	rand.Read(s.content[:100])
+
+	buffer := new(bytes.Buffer)
+	writer := gzip.NewWriter(buffer)
+	_, _ = writer.Write(s.content)
+	_ = writer.Close()
+	s.encoded = buffer.Bytes()
+	s.acceptEncodings = nil
+
+	mux := http.NewServeMux()
+
+	// The plain file is compressed for the clients accepting gzip, unless a range is requested.
+	mux.HandleFunc("/plain.bin", func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == "GET" {
+			s.mu.Lock()
+			s.acceptEncodings = append(s.acceptEncodings, r.Header.Get("Accept-Encoding"))
+			s.mu.Unlock()
+		}
+
+		if strings.Contains(r.Header.Get("Accept-Encoding"), "gzip") && r.Header.Get("Range") == "" {
+			w.Header().Set("Content-Encoding", "gzip")
+			_, _ = w.Write(s.encoded)
+			return
+		}
+
+		http.ServeContent(w, r, "plain.bin", time.Time{}, bytes.NewReader(s.content))
+	})
+
+	// The encoded file is always served with its content coding, as a .gz file by a misconfigured server.
+	mux.HandleFunc("/encoded.bin", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Encoding", "gzip")
+		http.ServeContent(w, r, "encoded.bin", time.Time{}, bytes.NewReader(s.encoded))
+	})
+
+	mux.HandleFunc("/brotli.bin", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Encoding", "br")
+		http.ServeContent(w, r, "brotli.bin", time.Time{}, bytes.NewReader(s.encoded))
+	})
+
+	s.server = httptest.NewServer(mux)
+}
+
+func (s *EncodingSuite) TearDownTest() {
+	s.server.Close()
+}
+
+// download initializes and downloads the URL, and returns the saved specification and the output.
+func (s *EncodingSuite) download(url string, options download.Options) (download.Download, []byte) {
+	network, err := download.NewHTTPNetwork(download.HTTPConfig{})
+	s.Require().NoError(err)
+
+	fs := afero.NewMemMapFs()
+	storage := download.NewStorage(fs, codec.NewYAMLCodec())
+	downloader := download.NewDownloader(network, storage, progressbar.NoopProgressBar{}, logger.NoopConsoleLogger{},
+		download.Config{Retry: download.RetryPolicy{MaxAttempts: 1}})
+
+	spec, err := downloader.InitDownload(url, options)
+	s.Require().NoError(err)
+	s.Require().NoError(downloader.Download(spec, context.TODO()))
+
+	spec, err = storage.ReadDownloadSpec(spec.Id)
+	s.Require().NoError(err)
+
+	output, err := afero.ReadFile(fs, spec.Id+"/output")
+	s.Require().NoError(err)
+
+	return spec, output
+}
+
+func (s *EncodingSuite) TestHTTPNetwork_ShouldRequestSegmentsWithoutEncoding() {
+	spec, output := s.download(s.server.URL+"/plain.bin", download.Options{Workers: 4, Compressed: true})
+
+	s.Equal(s.content, output)
+	s.Equal(int64(len(s.content)), spec.Size)
+	s.Empty(spec.ContentEncoding)
+	s.Len(spec.Segments, 4)
+
+	for _, acceptEncoding := range s.acceptEncodings {
+		s.Equal("identity", acceptEncoding)
+	}
+}
+
+func (s *EncodingSuite) TestHTTPNetwork_ShouldCompressSingleStream() {
+	spec, output := s.download(s.server.URL+"/plain.bin", download.Options{Workers: 1, Compressed: true})
+
+	s.Equal(s.content, output)
+	s.Equal(int64(len(s.content)), spec.Size)
+	s.Contains(s.acceptEncodings[len(s.acceptEncodings)-1], "gzip")
+}
+
+func (s *EncodingSuite) TestHTTPNetwork_FetchResource_ShouldReportContentEncoding() {
+	network, err := download.NewHTTPNetwork(download.HTTPConfig{})
+	s.Require().NoError(err)
+
+	resource, err := network.FetchResource(s.server.URL + "/encoded.bin")
+	s.Require().NoError(err)
+	s.Equal("gzip", resource.ContentEncoding)
+	s.Equal(int64(len(s.encoded)), resource.Size)
+
+	resource, err = network.FetchResource(s.server.URL + "/plain.bin")
+	s.Require().NoError(err)
+	s.Empty(resource.ContentEncoding)
+}
+
+func (s *EncodingSuite) TestDownloader_ShouldKeepEncodedBytes() {
+	spec, output := s.download(s.server.URL+"/encoded.bin", download.Options{Workers: 4})
+
+	s.Equal(s.encoded, output)
+	s.Equal("gzip", spec.ContentEncoding)
+	s.Zero(spec.DecodedSize)
+}
+
+func (s *EncodingSuite) TestDownloader_ShouldDecodeEncodedBytes() {
+	spec, output := s.download(s.server.URL+"/encoded.bin", download.Options{Workers: 4, Decode: true})
+
+	s.Equal(s.content, output)
+	s.Equal(int64(len(s.encoded)), spec.Size)
+	s.Equal(int64(len(s.content)), spec.DecodedSize)
+}
+
+func (s *EncodingSuite) TestDownloader_ShouldFailIfEncodingUnsupported() {
+	network, err := download.NewHTTPNetwork(download.HTTPConfig{})
+	s.Require().NoError(err)
+
+	storage := download.NewStorage(afero.NewMemMapFs(), codec.NewYAMLCodec())
+	downloader := download.NewDownloader(network, storage, progressbar.NoopProgressBar{}, logger.NoopConsoleLogger{}, download.Config{})
+
+	_, err = downloader.InitDownload(s.server.URL+"/brotli.bin", download.Options{Workers: 4, Decode: true})
+	s.ErrorIs(err, download.UnsupportedEncodingErr)
+
+	spec, err := downloader.InitDownload(s.server.URL+"/brotli.bin", download.Options{Workers: 4})
+	s.NoError(err)
+	s.Equal("br", spec.ContentEncoding)
+}
+
+func (s *EncodingSuite) TestHTTPNetwork_DownloadResource_ShouldRefuseUnexpectedEncoding() {
+	network, err := download.NewHTTPNetwork(download.HTTPConfig{})
+	s.Require().NoError(err)
+
+	resource := download.Resource{URL: s.server.URL + "/encoded.bin", Size: int64(len(s.encoded)), AcceptRanges: true}
+	err = network.DownloadResource(resource, 10, 20, new(bytes.Buffer), context.TODO())
+	s.ErrorIs(err, download.UnexpectedEncodingErr)
+}
+
+func TestEncodingSuite(t *testing.T) {
+	suite.Run(t, new(EncodingSuite))
+}
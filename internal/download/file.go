@@ -0,0 +1,181 @@
+package download
+
+import (
+	"context"
+	"fmt"
+	"github.com/MarcoTomasRodriguez/hget/pkg/fsutil"
+	"github.com/spf13/afero"
+	"io"
+	"net/http"
+	"net/url"
+	"path/filepath"
+	"strings"
+)
+
+// fileNetwork implements the Network interface for the file scheme, reading local or mounted files from a filesystem.
+// Segments are read in parallel from their offsets, which makes a resumable copy of large files on network mounts.
+type fileNetwork struct {
+	fs afero.Fs
+}
+
+// parseFileURL parses a file URL, e.g. "file:///mnt/nfs/big.iso", and returns the path of the file. Only local files,
+// without host or with the localhost host, are supported.
+func parseFileURL(rawURL string) (string, error) {
+	u, err := url.Parse(rawURL)
+	if err != nil || u.Scheme != "file" || (u.Host != "" && u.Host != "localhost") || u.Opaque != "" {
+		return "", fmt.Errorf("%w: %s", UnsupportedSchemeErr, rawURL)
+	}
+
+	if u.Path == "" || strings.HasSuffix(u.Path, "/") {
+		return "", InvalidFilenameErr
+	}
+
+	return filepath.FromSlash(u.Path), nil
+}
+
+// fileError converts an error of the filesystem, which is permanent.
+func fileError(err error) error {
+	return fmt.Errorf("file: %w", err)
+}
+
+// FetchResource gets the size and modification time of a file.
+func (n fileNetwork) FetchResource(URL string) (Resource, error) {
+	filePath, err := parseFileURL(URL)
+	if err != nil {
+		return Resource{}, err
+	}
+
+	info, err := n.fs.Stat(filePath)
+	if err != nil {
+		return Resource{}, fileError(err)
+	}
+
+	if info.IsDir() {
+		return Resource{}, InvalidFilenameErr
+	}
+
+	// Extract the download filename from the path, and validate it.
+	filename := filepath.Base(filePath)
+	if !fsutil.ValidateFilename(filename) {
+		return Resource{}, InvalidFilenameErr
+	}
+
+	return Resource{
+		URL:          URL,
+		Filename:     filename,
+		Size:         info.Size(),
+		AcceptRanges: true,
+		LastModified: info.ModTime().UTC().Format(http.TimeFormat),
+	}, nil
+}
+
+// ReadResource reads a small file in memory.
+func (n fileNetwork) ReadResource(URL string) ([]byte, error) {
+	filePath, err := parseFileURL(URL)
+	if err != nil {
+		return nil, err
+	}
+
+	file, err := n.fs.Open(filePath)
+	if err != nil {
+		return nil, fileError(err)
+	}
+
+	defer file.Close()
+
+	// Read one byte more than the limit to detect larger resources.
+	content, err := io.ReadAll(io.LimitReader(file, maxReadResourceSize+1))
+	if err != nil {
+		return nil, BufferCopyErr
+	}
+
+	if len(content) > maxReadResourceSize {
+		return nil, ResourceTooLargeErr
+	}
+
+	return content, nil
+}
+
+// DownloadResource copies a range of a file, from its start offset to its end point. It fails if the file changed since
+// it was fetched.
+func (n fileNetwork) DownloadResource(resource Resource, start int64, end int64, writer io.Writer, ctx context.Context) error {
+	// Check if the segment has an overflow.
+	if start < 0 || start > end {
+		return SegmentOverflowErr
+	}
+
+	// Check if download already finished. The end point is inclusive, except for the last segment, whose end point is
+	// the size of the resource.
+	if start == end && end >= resource.Size {
+		return nil
+	}
+
+	filePath, err := parseFileURL(resource.URL)
+	if err != nil {
+		return err
+	}
+
+	file, err := n.fs.Open(filePath)
+	if err != nil {
+		return fileError(err)
+	}
+
+	defer file.Close()
+
+	// Check that the file did not change, comparing its size and modification time.
+	info, err := file.Stat()
+	if err != nil {
+		return fileError(err)
+	}
+
+	if (resource.Size > 0 && info.Size() != resource.Size) ||
+		(resource.LastModified != "" && info.ModTime().UTC().Format(http.TimeFormat) != resource.LastModified) {
+		return ResourceChangedErr
+	}
+
+	if _, err := file.Seek(start, io.SeekStart); err != nil {
+		return fileError(err)
+	}
+
+	// The end point is inclusive, except for the last segment, whose end point is the size of the resource.
+	length := end - start + 1
+	if resource.Size > 0 && end >= resource.Size {
+		length = resource.Size - start
+	}
+
+	written, err := io.Copy(writer, io.LimitReader(contextReader{ctx: ctx, reader: file}, length))
+	if ctx.Err() != nil {
+		return ctx.Err()
+	}
+
+	if err != nil {
+		return BufferCopyErr
+	}
+
+	if written < length {
+		return fileError(io.ErrUnexpectedEOF)
+	}
+
+	return nil
+}
+
+// contextReader stops reading once the context is cancelled.
+type contextReader struct {
+	ctx    context.Context
+	reader io.Reader
+}
+
+func (r contextReader) Read(p []byte) (int, error) {
+	if err := r.ctx.Err(); err != nil {
+		return 0, err
+	}
+
+	return r.reader.Read(p)
+}
+
+// NewFileNetwork creates a network for the file scheme, reading the files of the filesystem.
+func NewFileNetwork(fs afero.Fs) Network {
+	return &fileNetwork{fs: fs}
+}
+
+var _ Network = (*fileNetwork)(nil)
@@ -0,0 +1,120 @@
+package download_test
+
+import (
+	"bytes"
+	"context"
+	"github.com/MarcoTomasRodriguez/hget/internal/download"
+	"github.com/MarcoTomasRodriguez/hget/pkg/codec"
+	"github.com/MarcoTomasRodriguez/hget/pkg/logger"
+	"github.com/MarcoTomasRodriguez/hget/pkg/progressbar"
+	"github.com/spf13/afero"
+	"github.com/stretchr/testify/suite"
+	"math/rand"
+	"net/http"
+	"testing"
+	"time"
+)
+
+type FileSuite struct {
+	suite.Suite
+	fs      afero.Fs
+	network download.Network
+	content []byte
+	modTime time.Time
+}
+
+func (s *FileSuite) SetupTest() {
+	s.fs = afero.NewMemMapFs()
+	s.network = download.NewFileNetwork(s.fs)
+	s.content = make([]byte, 4813)
+	rand.Read(s.content)
+	s.modTime = time.Date(2024, 3, 1, 12, 0, 0, 0, time.UTC)
+
+	s.Require().NoError(afero.WriteFile(s.fs, "/mnt/nfs/big file.iso", s.content, 0644))
+	s.Require().NoError(s.fs.Chtimes("/mnt/nfs/big file.iso", s.modTime, s.modTime))
+}
+
+func (s *FileSuite) TestFileNetwork_FetchResource() {
+	resource, err := s.network.FetchResource("file:///mnt/nfs/big%20file.iso")
+	s.Require().NoError(err)
+
+	s.Equal("file:///mnt/nfs/big%20file.iso", resource.URL)
+	s.Equal("big file.iso", resource.Filename)
+	s.Equal(int64(len(s.content)), resource.Size)
+	s.True(resource.AcceptRanges)
+	s.Equal(s.modTime.Format(http.TimeFormat), resource.LastModified)
+
+	resource, err = s.network.FetchResource("file://localhost/mnt/nfs/big%20file.iso")
+	s.NoError(err)
+	s.Equal("big file.iso", resource.Filename)
+}
+
+func (s *FileSuite) TestFileNetwork_FetchResource_ShouldFailIfInvalid() {
+	_, err := s.network.FetchResource("file:///mnt/nfs/missing.iso")
+	s.Error(err)
+	s.False(download.Retryable(err))
+
+	_, err = s.network.FetchResource("file:///mnt/nfs")
+	s.ErrorIs(err, download.InvalidFilenameErr)
+
+	_, err = s.network.FetchResource("file:///mnt/nfs/")
+	s.ErrorIs(err, download.InvalidFilenameErr)
+
+	_, err = s.network.FetchResource("file://server/mnt/nfs/big%20file.iso")
+	s.ErrorIs(err, download.UnsupportedSchemeErr)
+}
+
+func (s *FileSuite) TestFileNetwork_DownloadResource() {
+	resource, err := s.network.FetchResource("file:///mnt/nfs/big%20file.iso")
+	s.Require().NoError(err)
+
+	buffer := new(bytes.Buffer)
+	s.NoError(s.network.DownloadResource(resource, 100, 199, buffer, context.TODO()))
+	s.Equal(s.content[100:200], buffer.Bytes())
+
+	// The last segment ends at the size of the resource.
+	buffer.Reset()
+	s.NoError(s.network.DownloadResource(resource, 4000, resource.Size, buffer, context.TODO()))
+	s.Equal(s.content[4000:], buffer.Bytes())
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	s.ErrorIs(s.network.DownloadResource(resource, 0, resource.Size, new(bytes.Buffer), ctx), context.Canceled)
+}
+
+func (s *FileSuite) TestFileNetwork_DownloadResource_ShouldFailIfChanged() {
+	resource, err := s.network.FetchResource("file:///mnt/nfs/big%20file.iso")
+	s.Require().NoError(err)
+
+	s.Require().NoError(afero.WriteFile(s.fs, "/mnt/nfs/big file.iso", s.content[:1000], 0644))
+	s.ErrorIs(s.network.DownloadResource(resource, 0, 99, new(bytes.Buffer), context.TODO()), download.ResourceChangedErr)
+}
+
+func (s *FileSuite) TestFileNetwork_ReadResource() {
+	s.Require().NoError(afero.WriteFile(s.fs, "/mnt/nfs/big file.iso.sha256", []byte("digest"), 0644))
+
+	content, err := s.network.ReadResource("file:///mnt/nfs/big%20file.iso.sha256")
+	s.NoError(err)
+	s.Equal([]byte("digest"), content)
+}
+
+func (s *FileSuite) TestDownloader_ShouldCopyFileInSegments() {
+	network := download.NewSchemeNetwork(map[string]download.Network{"file": s.network}, "")
+	storageFs := afero.NewMemMapFs()
+	downloader := download.NewDownloader(network, download.NewStorage(storageFs, codec.NewYAMLCodec()),
+		progressbar.NoopProgressBar{}, logger.NoopConsoleLogger{}, download.Config{Retry: download.RetryPolicy{MaxAttempts: 1}})
+
+	spec, err := downloader.InitDownload("file:///mnt/nfs/big%20file.iso", download.Options{Workers: 4})
+	s.Require().NoError(err)
+	s.Len(spec.Segments, 4)
+
+	s.Require().NoError(downloader.Download(spec, context.TODO()))
+
+	output, err := afero.ReadFile(storageFs, spec.Id+"/output")
+	s.Require().NoError(err)
+	s.Equal(s.content, output)
+}
+
+func TestFileSuite(t *testing.T) {
+	suite.Run(t, new(FileSuite))
+}
@@ -0,0 +1,315 @@
+package download
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"github.com/MarcoTomasRodriguez/hget/pkg/fsutil"
+	"github.com/jlaffaye/ftp"
+	"io"
+	"net"
+	"net/http"
+	"net/textproto"
+	"net/url"
+	"path"
+	"strings"
+	"sync"
+)
+
+// Default ports of the FTP schemes. The ftps scheme uses implicit TLS.
+const (
+	ftpPort  = "21"
+	ftpsPort = "990"
+)
+
+// FTPConfig configures the authentication of the ftp network.
+type FTPConfig struct {
+	// Auth holds the credentials of the URL given without them, and the netrc file.
+	Auth AuthConfig
+}
+
+// ftpNetwork implements the Network interface for the ftp and ftps schemes. Each call uses its own connection, so that
+// segments are downloaded in parallel, each starting at its offset with the REST command.
+type ftpNetwork struct {
+	config FTPConfig
+}
+
+// ftpConnections dials the control and data connections of an FTP session, and closes all of them at once, which
+// interrupts a blocked transfer when the context is cancelled.
+type ftpConnections struct {
+	mu     sync.Mutex
+	conns  []net.Conn
+	closed bool
+}
+
+// dialer returns a function dialing connections with the context, over TLS if a configuration is given.
+func (c *ftpConnections) dialer(ctx context.Context, tlsConfig *tls.Config) func(network, address string) (net.Conn, error) {
+	return func(network, address string) (net.Conn, error) {
+		conn, err := new(net.Dialer).DialContext(ctx, network, address)
+		if err != nil {
+			return nil, err
+		}
+
+		if tlsConfig != nil {
+			conn = tls.Client(conn, tlsConfig)
+		}
+
+		c.mu.Lock()
+		defer c.mu.Unlock()
+
+		if c.closed {
+			_ = conn.Close()
+			return nil, net.ErrClosed
+		}
+
+		c.conns = append(c.conns, conn)
+		return conn, nil
+	}
+}
+
+// close closes every connection of the session.
+func (c *ftpConnections) close() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.closed = true
+	for _, conn := range c.conns {
+		_ = conn.Close()
+	}
+}
+
+// parseFTPURL parses an ftp or ftps URL, and returns the path of the file, relative to the login directory.
+func parseFTPURL(rawURL string) (*url.URL, string, error) {
+	u, err := url.Parse(rawURL)
+	if err != nil || (u.Scheme != "ftp" && u.Scheme != "ftps") || u.Host == "" {
+		return nil, "", fmt.Errorf("%w: %s", UnsupportedSchemeErr, rawURL)
+	}
+
+	filePath := strings.TrimPrefix(u.Path, "/")
+	if filePath == "" || strings.HasSuffix(filePath, "/") {
+		return nil, "", InvalidFilenameErr
+	}
+
+	return u, filePath, nil
+}
+
+// connect opens a session with the server of the URL, and logs in with the credentials of the URL, those of the
+// configuration, or anonymously.
+func (n ftpNetwork) connect(u *url.URL, ctx context.Context) (*ftp.ServerConn, *ftpConnections, error) {
+	var tlsConfig *tls.Config
+	port := ftpPort
+	if u.Scheme == "ftps" {
+		// Data connections resume the TLS session of the control connection, as most servers require.
+		tlsConfig = &tls.Config{ServerName: u.Hostname(), ClientSessionCache: tls.NewLRUClientSessionCache(1)}
+		port = ftpsPort
+	}
+
+	if u.Port() != "" {
+		port = u.Port()
+	}
+
+	conns := &ftpConnections{}
+	options := []ftp.DialOption{ftp.DialWithContext(ctx), ftp.DialWithDialFunc(conns.dialer(ctx, tlsConfig))}
+	if tlsConfig != nil {
+		options = append(options, ftp.DialWithTLS(tlsConfig))
+	}
+
+	conn, err := ftp.Dial(net.JoinHostPort(u.Hostname(), port), options...)
+	if err != nil {
+		conns.close()
+		return nil, nil, ftpError(err)
+	}
+
+	user, password := "anonymous", "anonymous"
+	if u.User != nil {
+		user = u.User.Username()
+		password, _ = u.User.Password()
+	} else if credentials := n.config.Auth.credentialsOf(u); credentials != nil && credentials.Username != "" {
+		user, password = credentials.Username, credentials.Password
+	}
+
+	if err := conn.Login(user, password); err != nil {
+		conns.close()
+		return nil, nil, ftpError(err)
+	}
+
+	return conn, conns, nil
+}
+
+// ftpError converts an FTP error: transient replies (4xx) and connection errors are network errors, which can be
+// retried, whereas permanent replies (5xx) are returned as is.
+func ftpError(err error) error {
+	var replyErr *textproto.Error
+	if errors.As(err, &replyErr) && (replyErr.Code < 400 || replyErr.Code > 499) {
+		return fmt.Errorf("ftp: %w", err)
+	}
+
+	return NetworkError(err.Error())
+}
+
+// FetchResource gets the size and modification time of a remote file with the SIZE and MDTM commands.
+func (n ftpNetwork) FetchResource(URL string) (Resource, error) {
+	u, filePath, err := parseFTPURL(URL)
+	if err != nil {
+		return Resource{}, err
+	}
+
+	conn, conns, err := n.connect(u, context.Background())
+	if err != nil {
+		return Resource{}, err
+	}
+
+	defer conns.close()
+	defer func() { _ = conn.Quit() }()
+
+	size, err := conn.FileSize(filePath)
+	if err != nil {
+		return Resource{}, ftpError(err)
+	}
+
+	// The modification time is the only validator of FTP resources.
+	var lastModified string
+	if conn.IsGetTimeSupported() {
+		if modTime, err := conn.GetTime(filePath); err == nil {
+			lastModified = modTime.UTC().Format(http.TimeFormat)
+		}
+	}
+
+	// Extract the download filename from the URL, and validate it.
+	filename := path.Base(filePath)
+	if !fsutil.ValidateFilename(filename) {
+		return Resource{}, InvalidFilenameErr
+	}
+
+	return Resource{
+		URL:          u.String(),
+		Filename:     filename,
+		Size:         size,
+		AcceptRanges: true,
+		LastModified: lastModified,
+	}, nil
+}
+
+// ReadResource reads a small remote file in memory.
+func (n ftpNetwork) ReadResource(URL string) ([]byte, error) {
+	u, filePath, err := parseFTPURL(URL)
+	if err != nil {
+		return nil, err
+	}
+
+	conn, conns, err := n.connect(u, context.Background())
+	if err != nil {
+		return nil, err
+	}
+
+	defer conns.close()
+
+	response, err := conn.Retr(filePath)
+	if err != nil {
+		return nil, ftpError(err)
+	}
+
+	// Read one byte more than the limit to detect larger resources.
+	content, err := io.ReadAll(io.LimitReader(response, maxReadResourceSize+1))
+	if err != nil {
+		return nil, BufferCopyErr
+	}
+
+	if len(content) > maxReadResourceSize {
+		return nil, ResourceTooLargeErr
+	}
+
+	_ = response.Close()
+	_ = conn.Quit()
+
+	return content, nil
+}
+
+// DownloadResource downloads a range of a remote file, starting at the given offset with the REST command, and stopping
+// the transfer at the end of the range. It fails if the file changed since it was fetched.
+func (n ftpNetwork) DownloadResource(resource Resource, start int64, end int64, writer io.Writer, ctx context.Context) error {
+	// Check if the segment has an overflow.
+	if start < 0 || start > end {
+		return SegmentOverflowErr
+	}
+
+	// Check if download already finished. The end point is inclusive, except for the last segment, whose end point is
+	// the size of the resource.
+	if start == end && end >= resource.Size {
+		return nil
+	}
+
+	u, filePath, err := parseFTPURL(resource.URL)
+	if err != nil {
+		return err
+	}
+
+	conn, conns, err := n.connect(u, ctx)
+	if err != nil {
+		return err
+	}
+
+	// Close the connections if the context is cancelled, which interrupts the transfer.
+	done := make(chan struct{})
+	defer close(done)
+	defer conns.close()
+
+	go func() {
+		select {
+		case <-ctx.Done():
+			conns.close()
+		case <-done:
+		}
+	}()
+
+	// Check that the file did not change, as there is no conditional transfer.
+	if size, err := conn.FileSize(filePath); err != nil {
+		return ftpError(err)
+	} else if resource.Size > 0 && size != resource.Size {
+		return ResourceChangedErr
+	}
+
+	if resource.LastModified != "" && conn.IsGetTimeSupported() {
+		if modTime, err := conn.GetTime(filePath); err == nil && modTime.UTC().Format(http.TimeFormat) != resource.LastModified {
+			return ResourceChangedErr
+		}
+	}
+
+	response, err := conn.RetrFrom(filePath, uint64(start))
+	if err != nil {
+		// A server which does not implement REST replies with a syntax error or command not implemented.
+		var replyErr *textproto.Error
+		if start > 0 && errors.As(err, &replyErr) && (replyErr.Code == 500 || replyErr.Code == 502 || replyErr.Code == 504) {
+			return RangeNotSupportedErr
+		}
+
+		return ftpError(err)
+	}
+
+	// The end point is inclusive, except for the last segment, whose end point is the size of the resource.
+	length := end - start + 1
+	if resource.Size > 0 && end >= resource.Size {
+		length = resource.Size - start
+	}
+
+	written, err := io.Copy(writer, io.LimitReader(response, length))
+	if err != nil {
+		if ctx.Err() != nil {
+			return NetworkError(ctx.Err().Error())
+		}
+
+		return BufferCopyErr
+	}
+
+	if written < length {
+		return NetworkError(io.ErrUnexpectedEOF.Error())
+	}
+
+	return nil
+}
+
+// NewFTPNetwork creates a network for the ftp and ftps schemes.
+func NewFTPNetwork(config FTPConfig) Network {
+	return &ftpNetwork{config: config}
+}
@@ -0,0 +1,304 @@
+package download_test
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"fmt"
+	"github.com/MarcoTomasRodriguez/hget/internal/download"
+	"github.com/MarcoTomasRodriguez/hget/pkg/codec"
+	"github.com/MarcoTomasRodriguez/hget/pkg/logger"
+	"github.com/MarcoTomasRodriguez/hget/pkg/progressbar"
+	"github.com/spf13/afero"
+	"github.com/stretchr/testify/suite"
+	"math/rand"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// ftpServer is a minimal in-process FTP server, serving files from memory over passive data connections.
+type ftpServer struct {
+	listener net.Listener
+	files    map[string][]byte
+	modTime  time.Time
+	// noRest disables the REST command.
+	noRest bool
+	mu     sync.Mutex
+	// logins are the users and passwords of the sessions, separated by a colon.
+	logins []string
+}
+
+func newFTPServer(files map[string][]byte) *ftpServer {
+	listener, _ := net.Listen("tcp", "127.0.0.1:0")
+	server := &ftpServer{listener: listener, files: files, modTime: time.Date(2015, 10, 21, 7, 28, 0, 0, time.UTC)}
+
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+
+			go server.serve(conn)
+		}
+	}()
+
+	return server
+}
+
+func (s *ftpServer) URL(path string) string {
+	return fmt.Sprintf("ftp://%s/%s", s.listener.Addr(), path)
+}
+
+func (s *ftpServer) Close() {
+	_ = s.listener.Close()
+}
+
+func (s *ftpServer) serve(conn net.Conn) {
+	defer conn.Close()
+
+	reader := bufio.NewReader(conn)
+	reply := func(format string, a ...interface{}) { _, _ = fmt.Fprintf(conn, format+"\r\n", a...) }
+
+	var data net.Listener
+	var offset int64
+	var user string
+	reply("220 ready")
+
+	for {
+		line, err := reader.ReadString('\n')
+		if err != nil {
+			return
+		}
+
+		command, arg, _ := strings.Cut(strings.TrimSpace(line), " ")
+		switch strings.ToUpper(command) {
+		case "USER":
+			user = arg
+			reply("331 password required")
+		case "PASS":
+			s.mu.Lock()
+			s.logins = append(s.logins, user+":"+arg)
+			s.mu.Unlock()
+			reply("230 logged in")
+		case "FEAT":
+			reply("211-Features:\r\n MDTM\r\n SIZE\r\n REST STREAM\r\n211 End")
+		case "TYPE":
+			reply("200 type set")
+		case "SIZE", "MDTM":
+			content, ok := s.files[arg]
+			switch {
+			case !ok:
+				reply("550 file not found")
+			case command == "SIZE":
+				reply("213 %d", len(content))
+			default:
+				reply("213 %s", s.modTime.Format("20060102150405"))
+			}
+		case "EPSV":
+			data, _ = net.Listen("tcp", "127.0.0.1:0")
+			reply("229 Entering Extended Passive Mode (|||%d|)", data.Addr().(*net.TCPAddr).Port)
+		case "REST":
+			if s.noRest {
+				reply("502 command not implemented")
+				continue
+			}
+
+			offset, _ = strconv.ParseInt(arg, 10, 64)
+			reply("350 restarting at %d", offset)
+		case "RETR":
+			content, ok := s.files[arg]
+			if !ok || data == nil {
+				reply("550 file not found")
+				continue
+			}
+
+			dataConn, err := data.Accept()
+			_ = data.Close()
+			if err != nil {
+				return
+			}
+
+			reply("150 opening data connection")
+			_, err = dataConn.Write(content[offset:])
+			_ = dataConn.Close()
+			if err != nil {
+				reply("426 transfer aborted")
+			} else {
+				reply("226 transfer complete")
+			}
+
+			data, offset = nil, 0
+		case "QUIT":
+			reply("221 bye")
+			return
+		default:
+			reply("502 command not implemented")
+		}
+	}
+}
+
+type FTPNetworkSuite struct {
+	suite.Suite
+	server  *ftpServer
+	content []byte
+}
+
+func (s *FTPNetworkSuite) SetupTest() {
+	s.content = make([]byte, 2583)
+	rand.Read(s.content)
+	s.server = newFTPServer(map[string][]byte{"pub/file.bin": s.content})
+}
+
+func (s *FTPNetworkSuite) TearDownTest() {
+	s.server.Close()
+}
+
+func (s *FTPNetworkSuite) TestFTPNetwork_FetchResource() {
+	resource, err := download.NewFTPNetwork(download.FTPConfig{}).FetchResource(s.server.URL("pub/file.bin"))
+	s.NoError(err)
+	s.Equal(download.Resource{
+		URL:          s.server.URL("pub/file.bin"),
+		Filename:     "file.bin",
+		Size:         int64(len(s.content)),
+		AcceptRanges: true,
+		LastModified: "Wed, 21 Oct 2015 07:28:00 GMT",
+	}, resource)
+}
+
+func (s *FTPNetworkSuite) TestFTPNetwork_FetchResource_ShouldFailIfNotFound() {
+	_, err := download.NewFTPNetwork(download.FTPConfig{}).FetchResource(s.server.URL("pub/missing.bin"))
+	s.ErrorContains(err, "550")
+	s.False(download.Retryable(err))
+}
+
+func (s *FTPNetworkSuite) TestFTPNetwork_DownloadResource() {
+	network := download.NewFTPNetwork(download.FTPConfig{})
+	resource, _ := network.FetchResource(s.server.URL("pub/file.bin"))
+
+	buffer := new(bytes.Buffer)
+	err := network.DownloadResource(resource, 1000, 1999, buffer, context.TODO())
+	s.NoError(err)
+	s.Equal(s.content[1000:2000], buffer.Bytes())
+
+	// The last segment ends at the size of the resource.
+	buffer.Reset()
+	err = network.DownloadResource(resource, 2000, resource.Size, buffer, context.TODO())
+	s.NoError(err)
+	s.Equal(s.content[2000:], buffer.Bytes())
+}
+
+func (s *FTPNetworkSuite) TestFTPNetwork_DownloadResource_ShouldFailIfResourceChanged() {
+	network := download.NewFTPNetwork(download.FTPConfig{})
+	resource, _ := network.FetchResource(s.server.URL("pub/file.bin"))
+	resource.LastModified = "Thu, 01 Jan 2015 00:00:00 GMT"
+
+	err := network.DownloadResource(resource, 0, 999, new(bytes.Buffer), context.TODO())
+	s.ErrorIs(err, download.ResourceChangedErr)
+}
+
+func (s *FTPNetworkSuite) TestFTPNetwork_DownloadResource_ShouldFailIfRestNotSupported() {
+	s.server.noRest = true
+	network := download.NewFTPNetwork(download.FTPConfig{})
+	resource, _ := network.FetchResource(s.server.URL("pub/file.bin"))
+
+	err := network.DownloadResource(resource, 1000, 1999, new(bytes.Buffer), context.TODO())
+	s.ErrorIs(err, download.RangeNotSupportedErr)
+}
+
+func (s *FTPNetworkSuite) TestFTPNetwork_DownloadResource_ShouldReportCancellation() {
+	network := download.NewFTPNetwork(download.FTPConfig{})
+	resource, _ := network.FetchResource(s.server.URL("pub/file.bin"))
+
+	// The download is cancelled during the transfer.
+	ctx, cancel := context.WithCancel(context.Background())
+	writer := writerFunc(func(p []byte) (int, error) {
+		cancel()
+		return 0, ctx.Err()
+	})
+
+	err := network.DownloadResource(resource, 0, resource.Size, writer, ctx)
+	s.ErrorIs(err, download.NetworkError(context.Canceled.Error()))
+	s.NotErrorIs(err, download.BufferCopyErr)
+}
+
+func (s *FTPNetworkSuite) TestFTPNetwork_ShouldLogIn() {
+	resource, err := download.NewFTPNetwork(download.FTPConfig{}).FetchResource(s.server.URL("pub/file.bin"))
+	s.Require().NoError(err)
+
+	// The credentials of the configuration are used for their origin only.
+	origin := "ftp://" + s.server.listener.Addr().String()
+	auth := download.AuthConfig{Origin: origin, Credentials: &download.Credentials{Username: "user", Password: "pass"}}
+	network := download.NewFTPNetwork(download.FTPConfig{Auth: auth})
+	s.Require().NoError(network.DownloadResource(resource, 0, 999, new(bytes.Buffer), context.TODO()))
+
+	auth.Origin = "ftp://other.test"
+	network = download.NewFTPNetwork(download.FTPConfig{Auth: auth})
+	s.Require().NoError(network.DownloadResource(resource, 0, 999, new(bytes.Buffer), context.TODO()))
+
+	// The credentials of the URL take precedence.
+	resource.URL = strings.Replace(resource.URL, "ftp://", "ftp://other:secret@", 1)
+	network = download.NewFTPNetwork(download.FTPConfig{Auth: download.AuthConfig{Origin: origin, Credentials: auth.Credentials}})
+	s.Require().NoError(network.DownloadResource(resource, 0, 999, new(bytes.Buffer), context.TODO()))
+
+	s.server.mu.Lock()
+	defer s.server.mu.Unlock()
+	s.Equal([]string{"anonymous:anonymous", "user:pass", "anonymous:anonymous", "other:secret"}, s.server.logins)
+}
+
+func (s *FTPNetworkSuite) TestFTPNetwork_ReadResource() {
+	content, err := download.NewFTPNetwork(download.FTPConfig{}).ReadResource(s.server.URL("pub/file.bin"))
+	s.NoError(err)
+	s.Equal(s.content, content)
+}
+
+func (s *FTPNetworkSuite) TestDownloader_Download_ShouldDownloadSegmentsOverFTP() {
+	fs := afero.NewMemMapFs()
+	afs := afero.Afero{Fs: fs}
+	config := download.Config{Retry: download.RetryPolicy{MaxAttempts: 1}}
+	network, _ := download.NewDefaultNetwork(download.NetworkConfig{})
+	downloader := download.NewDownloader(network, download.NewStorage(fs, codec.NewYAMLCodec()), progressbar.NoopProgressBar{}, logger.NoopConsoleLogger{}, config)
+
+	spec, err := downloader.InitDownload(s.server.URL("pub/file.bin"), download.Options{Workers: 4})
+	s.NoError(err)
+	s.Len(spec.Segments, 4)
+
+	s.NoError(downloader.Download(spec, context.TODO()))
+
+	fileContent, _ := afs.ReadFile(fmt.Sprintf("%s/output", spec.Id))
+	s.Equal(s.content, fileContent)
+}
+
+func (s *FTPNetworkSuite) TestSchemeNetwork_ShouldSelectNetworkByScheme() {
+	network, _ := download.NewDefaultNetwork(download.NetworkConfig{})
+
+	resource, err := network.FetchResource(s.server.URL("pub/file.bin"))
+	s.NoError(err)
+	s.Equal(int64(len(s.content)), resource.Size)
+
+	_, err = network.FetchResource("gopher://test.com/file.bin")
+	s.ErrorIs(err, download.UnsupportedSchemeErr)
+}
+
+func (s *FTPNetworkSuite) TestSchemeNetwork_ShouldUseDefaultScheme() {
+	network, _ := download.NewDefaultNetwork(download.NetworkConfig{DefaultScheme: "ftp"})
+
+	resource, err := network.FetchResource(strings.TrimPrefix(s.server.URL("pub/file.bin"), "ftp://"))
+	s.NoError(err)
+	s.Equal(s.server.URL("pub/file.bin"), resource.URL)
+}
+
+func TestFTPNetworkSuite(t *testing.T) {
+	suite.Run(t, new(FTPNetworkSuite))
+}
+
+// writerFunc is a writer calling a function.
+type writerFunc func(p []byte) (int, error)
+
+func (f writerFunc) Write(p []byte) (int, error) {
+	return f(p)
+}
@@ -0,0 +1,74 @@
+package download
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"net/textproto"
+	"strings"
+)
+
+var InvalidHeaderErr = errors.New("invalid header")
+
+// secretHeaders lists the headers carrying credentials, which are not saved in the download specification.
+var secretHeaders = map[string]bool{"Authorization": true, "Proxy-Authorization": true, "Cookie": true}
+
+// secretHeaderWords are the words revealing a custom header carrying credentials, e.g. X-Api-Key or X-Auth-Token.
+var secretHeaderWords = []string{"auth", "token", "secret", "key", "password", "session", "cookie"}
+
+// IsSecretHeader reports whether a header carries credentials.
+func IsSecretHeader(name string) bool {
+	name = textproto.CanonicalMIMEHeaderKey(name)
+	if secretHeaders[name] {
+		return true
+	}
+
+	for _, word := range secretHeaderWords {
+		if strings.Contains(strings.ToLower(name), word) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// splitSecretHeaders splits the headers into those which can be saved as is, and those carrying credentials.
+func splitSecretHeaders(header http.Header) (public http.Header, secret http.Header) {
+	for name, values := range header {
+		target := &public
+		if IsSecretHeader(name) {
+			target = &secret
+		}
+
+		if *target == nil {
+			*target = http.Header{}
+		}
+
+		(*target)[name] = values
+	}
+
+	return public, secret
+}
+
+// ParseHeader parses a header given as "Name: value", as curl does.
+func ParseHeader(line string) (string, string, error) {
+	name, value, ok := strings.Cut(line, ":")
+	name = strings.TrimSpace(name)
+	if !ok || name == "" || strings.ContainsAny(name, " \t\r\n") || strings.ContainsAny(value, "\r\n") {
+		return "", "", fmt.Errorf("%w: %q", InvalidHeaderErr, line)
+	}
+
+	return textproto.CanonicalMIMEHeaderKey(name), strings.TrimSpace(value), nil
+}
+
+// setHeader sets the custom headers of a request. The Host header overrides the host of the request.
+func setHeader(request *http.Request, header http.Header) {
+	for name, values := range header {
+		if name == "Host" {
+			request.Host = values[0]
+			continue
+		}
+
+		request.Header[name] = values
+	}
+}
@@ -0,0 +1,50 @@
+package download_test
+
+import (
+	"github.com/MarcoTomasRodriguez/hget/internal/download"
+	"github.com/stretchr/testify/suite"
+	"testing"
+)
+
+type HeadersSuite struct {
+	suite.Suite
+}
+
+func (s *HeadersSuite) TestParseHeader() {
+	testCases := []struct {
+		line, name, value string
+		err               error
+	}{
+		{"Accept: */*", "Accept", "*/*", nil},
+		{"x-api-key:  secret ", "X-Api-Key", "secret", nil},
+		{"Referer: https://portal.test/a:b", "Referer", "https://portal.test/a:b", nil},
+		{"X-Empty:", "X-Empty", "", nil},
+		{"Accept */*", "", "", download.InvalidHeaderErr},
+		{": value", "", "", download.InvalidHeaderErr},
+		{"Bad Name: value", "", "", download.InvalidHeaderErr},
+		{"X-Injected: a\r\nHost: evil", "", "", download.InvalidHeaderErr},
+	}
+
+	for _, tc := range testCases {
+		s.Run(tc.line, func() {
+			name, value, err := download.ParseHeader(tc.line)
+			s.ErrorIs(err, tc.err)
+			s.Equal(tc.name, name)
+			s.Equal(tc.value, value)
+		})
+	}
+}
+
+func (s *HeadersSuite) TestIsSecretHeader() {
+	for _, name := range []string{"Authorization", "proxy-authorization", "Cookie", "X-Api-Key", "X-Auth-Token", "Private-Token"} {
+		s.True(download.IsSecretHeader(name), name)
+	}
+
+	for _, name := range []string{"User-Agent", "Referer", "Accept", "Keep-Alive"} {
+		s.False(download.IsSecretHeader(name), name)
+	}
+}
+
+func TestHeadersSuite(t *testing.T) {
+	suite.Run(t, new(HeadersSuite))
+}
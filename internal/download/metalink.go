@@ -0,0 +1,241 @@
+package download
+
+import (
+	"bytes"
+	"encoding/hex"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"hash"
+	"path"
+	"sort"
+	"strings"
+)
+
+// MetalinkMediaType is the media type of Metalink documents (RFC 5854).
+const MetalinkMediaType = "application/metalink4+xml"
+
+// metalinkExtensions lists the file extensions of Metalink documents.
+var metalinkExtensions = []string{".meta4", ".metalink"}
+
+var (
+	InvalidMetalinkErr  = errors.New("invalid metalink")
+	NoMetalinkSourceErr = errors.New("no metalink source available")
+)
+
+// checksumPreference lists the checksum algorithms in order of preference, when several digests are available.
+var checksumPreference = []string{SHA512, SHA256, SHA1, MD5}
+
+// Metalink describes a file published by a Metalink document: its name, size, the URLs serving it, and its digests.
+type Metalink struct {
+	Name string
+	// Size is the size of the file, or 0 if it is not provided.
+	Size int64
+	// URLs are the URLs serving the file, in order of priority.
+	URLs []string
+	// Checksum is the digest of the whole file, computed with the strongest supported algorithm, if any.
+	Checksum *Checksum
+	// Pieces are the digests of the consecutive pieces of the file, if any.
+	Pieces *Pieces
+}
+
+// Pieces stores the expected digests of the consecutive pieces of a download, all of the same length except the last
+// one.
+type Pieces struct {
+	Algorithm string   `yaml:"algorithm"`
+	Length    int64    `yaml:"length"`
+	Hashes    []string `yaml:"hashes"`
+}
+
+// metalinkDocument is the XML representation of a Metalink document.
+type metalinkDocument struct {
+	XMLName xml.Name `xml:"urn:ietf:params:xml:ns:metalink metalink"`
+	Files   []struct {
+		Name   string `xml:"name,attr"`
+		Size   int64  `xml:"size"`
+		Hashes []struct {
+			Type  string `xml:"type,attr"`
+			Value string `xml:",chardata"`
+		} `xml:"hash"`
+		Pieces []struct {
+			Type   string   `xml:"type,attr"`
+			Length int64    `xml:"length,attr"`
+			Hashes []string `xml:"hash"`
+		} `xml:"pieces"`
+		URLs []struct {
+			Priority int    `xml:"priority,attr"`
+			Value    string `xml:",chardata"`
+		} `xml:"url"`
+	} `xml:"file"`
+}
+
+// IsMetalink reports whether a resource is a Metalink document, either by its media type or by its file extension.
+func IsMetalink(resource Resource) bool {
+	if resource.ContentType == MetalinkMediaType {
+		return true
+	}
+
+	for _, extension := range metalinkExtensions {
+		if strings.HasSuffix(strings.ToLower(resource.Filename), extension) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// ParseMetalink parses a Metalink document, and returns the description of its first file. Only one file is
+// downloaded at a time.
+func ParseMetalink(content []byte) (Metalink, error) {
+	var document metalinkDocument
+	if err := xml.NewDecoder(bytes.NewReader(content)).Decode(&document); err != nil {
+		return Metalink{}, fmt.Errorf("%w: %v", InvalidMetalinkErr, err)
+	}
+
+	if len(document.Files) == 0 {
+		return Metalink{}, fmt.Errorf("%w: no file", InvalidMetalinkErr)
+	}
+
+	file := document.Files[0]
+
+	// The name may contain a directory, which is ignored, but it must not escape it.
+	name := path.Base(strings.TrimSpace(file.Name))
+	if name == "." || name == ".." || name == "/" {
+		return Metalink{}, fmt.Errorf("%w: invalid file name %q", InvalidMetalinkErr, file.Name)
+	}
+
+	metalink := Metalink{Name: name, Size: file.Size}
+
+	// Sort the URLs by priority, from 1 (the highest) to 999999. URLs without priority come last.
+	urls := file.URLs
+	sort.SliceStable(urls, func(i, j int) bool {
+		return urls[i].Priority != 0 && (urls[j].Priority == 0 || urls[i].Priority < urls[j].Priority)
+	})
+
+	for _, url := range urls {
+		metalink.URLs = append(metalink.URLs, strings.TrimSpace(url.Value))
+	}
+
+	// Keep the whole-file digest of the strongest supported algorithm.
+	digests := map[string]string{}
+	for _, h := range file.Hashes {
+		digests[metalinkAlgorithm(h.Type)] = h.Value
+	}
+
+	for _, algorithm := range checksumPreference {
+		if digest, ok := digests[algorithm]; ok {
+			checksum, err := NewChecksum(algorithm, digest)
+			if err != nil {
+				return Metalink{}, fmt.Errorf("%w: %v", InvalidMetalinkErr, err)
+			}
+
+			metalink.Checksum = &checksum
+			break
+		}
+	}
+
+	// Keep the first piece digests with a supported algorithm.
+	for _, pieces := range file.Pieces {
+		algorithm := metalinkAlgorithm(pieces.Type)
+		if _, ok := hashes[algorithm]; !ok {
+			continue
+		}
+
+		if pieces.Length <= 0 || len(pieces.Hashes) == 0 {
+			return Metalink{}, fmt.Errorf("%w: invalid pieces", InvalidMetalinkErr)
+		}
+
+		metalink.Pieces = &Pieces{Algorithm: algorithm, Length: pieces.Length}
+		for _, digest := range pieces.Hashes {
+			checksum, err := NewChecksum(algorithm, digest)
+			if err != nil {
+				return Metalink{}, fmt.Errorf("%w: %v", InvalidMetalinkErr, err)
+			}
+
+			metalink.Pieces.Hashes = append(metalink.Pieces.Hashes, checksum.Digest)
+		}
+
+		break
+	}
+
+	return metalink, nil
+}
+
+// metalinkAlgorithm converts a hash function name from the IANA registry, e.g. "sha-256", to a checksum algorithm.
+func metalinkAlgorithm(name string) string {
+	return strings.ReplaceAll(strings.ToLower(strings.TrimSpace(name)), "-", "")
+}
+
+// Verifier returns a writer which hashes the bytes written to it piece by piece, and fails as soon as a piece does not
+// match its expected digest.
+func (p Pieces) Verifier() (*PieceVerifier, error) {
+	newHash, ok := hashes[p.Algorithm]
+	if !ok {
+		return nil, UnsupportedChecksumErr
+	}
+
+	return &PieceVerifier{pieces: p, hash: newHash()}, nil
+}
+
+// PieceVerifier verifies the bytes written to it against the expected digests of the pieces of a download.
+type PieceVerifier struct {
+	pieces  Pieces
+	hash    hash.Hash
+	piece   int
+	written int64
+}
+
+func (v *PieceVerifier) Write(p []byte) (int, error) {
+	n := 0
+	for len(p) > 0 {
+		chunk := p
+		if remaining := v.pieces.Length - v.written; int64(len(chunk)) > remaining {
+			chunk = chunk[:remaining]
+		}
+
+		v.hash.Write(chunk)
+		v.written += int64(len(chunk))
+		n += len(chunk)
+		p = p[len(chunk):]
+
+		if v.written == v.pieces.Length {
+			if err := v.verifyPiece(); err != nil {
+				return n, err
+			}
+		}
+	}
+
+	return n, nil
+}
+
+// Verify verifies the last piece, which may be shorter than the others, and that no piece is missing.
+func (v *PieceVerifier) Verify() error {
+	if v.written > 0 {
+		if err := v.verifyPiece(); err != nil {
+			return err
+		}
+	}
+
+	if v.piece != len(v.pieces.Hashes) {
+		return fmt.Errorf("%w: expected %d pieces, got %d", ChecksumMismatchErr, len(v.pieces.Hashes), v.piece)
+	}
+
+	return nil
+}
+
+// verifyPiece compares the digest of the current piece with the expected one, and starts the next piece.
+func (v *PieceVerifier) verifyPiece() error {
+	if v.piece >= len(v.pieces.Hashes) {
+		return fmt.Errorf("%w: unexpected piece %d", ChecksumMismatchErr, v.piece)
+	}
+
+	if digest := hex.EncodeToString(v.hash.Sum(nil)); digest != v.pieces.Hashes[v.piece] {
+		return fmt.Errorf("%w: piece %d at offset %d", ChecksumMismatchErr, v.piece, int64(v.piece)*v.pieces.Length)
+	}
+
+	v.piece++
+	v.written = 0
+	v.hash.Reset()
+
+	return nil
+}
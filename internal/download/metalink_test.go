@@ -0,0 +1,99 @@
+package download_test
+
+import (
+	"crypto/sha1"
+	"encoding/hex"
+	"fmt"
+	"github.com/MarcoTomasRodriguez/hget/internal/download"
+	"github.com/stretchr/testify/assert"
+	"testing"
+)
+
+const helloMetalink = `<?xml version="1.0" encoding="UTF-8"?>
+<metalink xmlns="urn:ietf:params:xml:ns:metalink">
+  <file name="dir/hello.txt">
+    <size>5</size>
+    <hash type="md5">5d41402abc4b2a76b9719d911017c592</hash>
+    <hash type="sha-256">` + helloSha256 + `</hash>
+    <pieces length="2" type="sha-1">
+      <hash>%s</hash>
+      <hash>%s</hash>
+      <hash>%s</hash>
+    </pieces>
+    <url>https://mirror3.test/hello.txt</url>
+    <url priority="2">https://mirror2.test/hello.txt</url>
+    <url priority="1">https://mirror1.test/hello.txt</url>
+  </file>
+</metalink>`
+
+func sha1Hex(content string) string {
+	digest := sha1.Sum([]byte(content))
+	return hex.EncodeToString(digest[:])
+}
+
+func TestParseMetalink(t *testing.T) {
+	content := []byte(fmt.Sprintf(helloMetalink, sha1Hex("he"), sha1Hex("ll"), sha1Hex("o")))
+
+	metalink, err := download.ParseMetalink(content)
+	assert.NoError(t, err)
+	assert.Equal(t, "hello.txt", metalink.Name)
+	assert.Equal(t, int64(5), metalink.Size)
+	assert.Equal(t, []string{
+		"https://mirror1.test/hello.txt",
+		"https://mirror2.test/hello.txt",
+		"https://mirror3.test/hello.txt",
+	}, metalink.URLs)
+	assert.Equal(t, &download.Checksum{Algorithm: download.SHA256, Digest: helloSha256}, metalink.Checksum)
+	assert.Equal(t, &download.Pieces{
+		Algorithm: download.SHA1,
+		Length:    2,
+		Hashes:    []string{sha1Hex("he"), sha1Hex("ll"), sha1Hex("o")},
+	}, metalink.Pieces)
+}
+
+func TestParseMetalink_ShouldFailIfInvalid(t *testing.T) {
+	testCases := []struct {
+		name    string
+		content string
+	}{
+		{"not xml", "hello"},
+		{"wrong namespace", `<metalink xmlns="urn:other"><file name="a"/></metalink>`},
+		{"no file", `<metalink xmlns="urn:ietf:params:xml:ns:metalink"></metalink>`},
+		{"escaping name", `<metalink xmlns="urn:ietf:params:xml:ns:metalink"><file name="../"/></metalink>`},
+		{"invalid digest", `<metalink xmlns="urn:ietf:params:xml:ns:metalink"><file name="a"><hash type="sha-1">zz</hash></file></metalink>`},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			_, err := download.ParseMetalink([]byte(tc.content))
+			assert.ErrorIs(t, err, download.InvalidMetalinkErr)
+		})
+	}
+}
+
+func TestIsMetalink(t *testing.T) {
+	assert.True(t, download.IsMetalink(download.Resource{Filename: "file.iso.meta4"}))
+	assert.True(t, download.IsMetalink(download.Resource{Filename: "file", ContentType: download.MetalinkMediaType}))
+	assert.False(t, download.IsMetalink(download.Resource{Filename: "file.iso", ContentType: "application/octet-stream"}))
+}
+
+func TestPieces_Verifier(t *testing.T) {
+	pieces := download.Pieces{Algorithm: download.SHA1, Length: 2, Hashes: []string{sha1Hex("he"), sha1Hex("ll"), sha1Hex("o")}}
+
+	verifier, err := pieces.Verifier()
+	assert.NoError(t, err)
+	_, err = verifier.Write([]byte("hel"))
+	assert.NoError(t, err)
+	_, err = verifier.Write([]byte("lo"))
+	assert.NoError(t, err)
+	assert.NoError(t, verifier.Verify())
+
+	verifier, _ = pieces.Verifier()
+	_, err = verifier.Write([]byte("heLlo"))
+	assert.ErrorIs(t, err, download.ChecksumMismatchErr)
+	assert.ErrorContains(t, err, "piece 1")
+
+	verifier, _ = pieces.Verifier()
+	_, _ = verifier.Write([]byte("hell"))
+	assert.ErrorIs(t, verifier.Verify(), download.ChecksumMismatchErr)
+}
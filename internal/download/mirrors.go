@@ -0,0 +1,228 @@
+package download
+
+import (
+	"errors"
+	"io"
+	"sync"
+	"time"
+)
+
+// slowMirrorErr is returned by a mirror writer once its mirror is slower than the others, or was dropped.
+var slowMirrorErr = errors.New("slow mirror")
+
+// SlowMirrorPolicy configures how the mirrors much slower than the others are detected, so that they are dropped and
+// their segments downloaded from the faster ones.
+type SlowMirrorPolicy struct {
+	// Ratio is the fraction of the speed of the fastest mirror below which a mirror is slow.
+	Ratio float64
+	// Window is the duration a transfer is measured before its speed is compared.
+	Window time.Duration
+}
+
+// DefaultSlowMirrorPolicy is the slow mirror policy used for the fields which are not configured.
+var DefaultSlowMirrorPolicy = SlowMirrorPolicy{Ratio: 0.2, Window: 5 * time.Second}
+
+// mirrorPool distributes the segments of a download across the URLs serving the same resource, and drops the mirrors
+// that fail or are much slower than the others, as long as another one remains.
+type mirrorPool struct {
+	mu      sync.Mutex
+	urls    []string
+	dropped []bool
+	// speeds are the last measured speeds of the transfers from each mirror, in bytes per second.
+	speeds []float64
+	policy SlowMirrorPolicy
+	// verified reports whether the download is verified by digests, in which case the mirrors are not required to
+	// share the validators of the download URL.
+	verified bool
+	// address is the address the requests to the download URL are pinned to, if any.
+	address string
+	// repinMu serializes the replacements of the pinned address.
+	repinMu sync.Mutex
+}
+
+// newMirrorPool creates a pool with the URL the download is resolved to, followed by its mirrors.
+func newMirrorPool(download *Download, policy SlowMirrorPolicy) *mirrorPool {
+	if policy.Ratio <= 0 {
+		policy.Ratio = DefaultSlowMirrorPolicy.Ratio
+	}
+
+	if policy.Window <= 0 {
+		policy.Window = DefaultSlowMirrorPolicy.Window
+	}
+
+	urls := append([]string{download.Resource().URL}, download.Mirrors...)
+	verified := download.Checksum != nil || download.Pieces != nil
+	return &mirrorPool{
+		urls:     urls,
+		dropped:  make([]bool, len(urls)),
+		speeds:   make([]float64, len(urls)),
+		policy:   policy,
+		verified: verified,
+		address:  download.Address,
+	}
+}
+
+// resource returns the resource served by a mirror. The validators of the download URL are only sent to the mirrors
+// if the download is not verified by digests. Only the download URL is pinned to an address.
+func (p *mirrorPool) resource(resource Resource, mirror int) Resource {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	resource.URL, resource.Address = p.urls[mirror], p.address
+	if mirror > 0 {
+		resource.Address = ""
+	}
+
+	if mirror > 0 && p.verified {
+		resource.ETag, resource.LastModified = "", ""
+	}
+
+	return resource
+}
+
+// repin replaces the failed address of the download URL by the address returned by resolve. If another worker already
+// replaced it, resolve is not called. It returns the current address, and whether it was replaced by this call.
+func (p *mirrorPool) repin(failed string, resolve func() (string, error)) (string, bool, error) {
+	p.repinMu.Lock()
+	defer p.repinMu.Unlock()
+
+	p.mu.Lock()
+	address := p.address
+	p.mu.Unlock()
+
+	if address != failed {
+		return address, false, nil
+	}
+
+	address, err := resolve()
+	if err != nil {
+		return failed, false, err
+	}
+
+	p.mu.Lock()
+	p.address = address
+	p.mu.Unlock()
+
+	return address, true, nil
+}
+
+// pick returns the preferred mirror if it was not dropped, or the next mirror that was not dropped.
+func (p *mirrorPool) pick(preferred int) int {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	for i := 0; i < len(p.urls); i++ {
+		mirror := (preferred + i) % len(p.urls)
+		if !p.dropped[mirror] {
+			return mirror
+		}
+	}
+
+	return preferred % len(p.urls)
+}
+
+// drop removes a mirror from the pool, unless it is the last one. It reports whether the mirror is no longer in the
+// pool, including if another worker dropped it first.
+func (p *mirrorPool) drop(mirror int) bool {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if p.dropped[mirror] {
+		return true
+	}
+
+	remaining := 0
+	for _, dropped := range p.dropped {
+		if !dropped {
+			remaining++
+		}
+	}
+
+	if remaining < 2 {
+		return false
+	}
+
+	p.dropped[mirror] = true
+	return true
+}
+
+// url returns the URL of a mirror.
+func (p *mirrorPool) url(mirror int) string {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	return p.urls[mirror]
+}
+
+// measure records the speed of a transfer from a mirror, once measured during the window or when the transfer is done.
+// If the mirror is slower than the ratio of the speed of another mirror, it is dropped. It reports whether the mirror
+// is no longer in the pool, and whether this call dropped it.
+func (p *mirrorPool) measure(mirror int, written int64, elapsed time.Duration, done bool) (bool, bool) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if p.dropped[mirror] {
+		return true, false
+	}
+
+	if elapsed <= 0 || (!done && elapsed < p.policy.Window) {
+		return false, false
+	}
+
+	speed := float64(written) / elapsed.Seconds()
+	p.speeds[mirror] = speed
+
+	for other, otherSpeed := range p.speeds {
+		if other != mirror && !p.dropped[other] && speed < otherSpeed*p.policy.Ratio {
+			p.dropped[mirror] = true
+			return true, true
+		}
+	}
+
+	return false, false
+}
+
+// writer returns a writer measuring a transfer from a mirror, which stops it once the mirror is slow.
+func (p *mirrorPool) writer(mirror int, writer io.Writer) *mirrorWriter {
+	return &mirrorWriter{pool: p, mirror: mirror, writer: writer, start: time.Now()}
+}
+
+// mirrorWriter measures the speed of a transfer from a mirror, and stops the transfer with slowMirrorErr once the
+// mirror is slower than the others, or was dropped, so that the segment is resumed from another mirror.
+type mirrorWriter struct {
+	pool    *mirrorPool
+	mirror  int
+	writer  io.Writer
+	start   time.Time
+	written int64
+	// stopped reports whether the transfer was stopped, and slow whether it found its mirror slow and dropped it.
+	stopped bool
+	slow    bool
+}
+
+func (w *mirrorWriter) Write(p []byte) (int, error) {
+	n, err := w.writer.Write(p)
+	w.written += int64(n)
+	if err != nil {
+		return n, err
+	}
+
+	if w.stopped, w.slow = w.pool.measure(w.mirror, w.written, time.Since(w.start), false); w.stopped {
+		return n, slowMirrorErr
+	}
+
+	return n, nil
+}
+
+// done records the speed of the finished transfer.
+func (w *mirrorWriter) done() {
+	_, _ = w.pool.measure(w.mirror, w.written, time.Since(w.start), true)
+}
+
+// mirrorFailed reports whether a transfer failed because of its mirror: the server failed, refused or no longer serves
+// the transfer, or the connection to it failed. Local errors and the cancellation of the transfer are not its failure.
+func mirrorFailed(err error) bool {
+	var statusErr StatusError
+	return Retryable(err) || errors.As(err, &statusErr) || errors.Is(err, ResourceChangedErr) ||
+		errors.Is(err, RangeNotSupportedErr)
+}
@@ -0,0 +1,139 @@
+package download_test
+
+import (
+	"bytes"
+	"context"
+	"github.com/MarcoTomasRodriguez/hget/internal/download"
+	"github.com/MarcoTomasRodriguez/hget/pkg/codec"
+	"github.com/MarcoTomasRodriguez/hget/pkg/logger"
+	"github.com/MarcoTomasRodriguez/hget/pkg/progressbar"
+	"github.com/spf13/afero"
+	"github.com/stretchr/testify/suite"
+	"math/rand"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// throttledWriter writes the response in small chunks, pausing between them, until the request is cancelled.
+type throttledWriter struct {
+	http.ResponseWriter
+	request *http.Request
+	written *atomic.Int64
+}
+
+func (w throttledWriter) Write(p []byte) (int, error) {
+	written := 0
+	for len(p) > 0 {
+		chunk := p
+		if len(chunk) > 1024 {
+			chunk = chunk[:1024]
+		}
+
+		n, err := w.ResponseWriter.Write(chunk)
+		written += n
+		w.written.Add(int64(n))
+		if err != nil {
+			return written, err
+		}
+
+		w.ResponseWriter.(http.Flusher).Flush()
+		p = p[n:]
+
+		select {
+		case <-w.request.Context().Done():
+			return written, w.request.Context().Err()
+		case <-time.After(20 * time.Millisecond):
+		}
+	}
+
+	return written, nil
+}
+
+type MirrorsSuite struct {
+	suite.Suite
+	content []byte
+	fast    *httptest.Server
+	slow    *httptest.Server
+	// slowWritten is the number of bytes served by the slow mirror.
+	slowWritten atomic.Int64
+}
+
+func (s *MirrorsSuite) SetupTest() {
+	s.content = make([]byte, 200_000)
+	rand.Read(s.content)
+	s.slowWritten.Store(0)
+
+	s.fast = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.ServeContent(w, r, "file.bin", time.Time{}, bytes.NewReader(s.content))
+	}))
+
+	s.slow = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		writer := throttledWriter{ResponseWriter: w, request: r, written: &s.slowWritten}
+		http.ServeContent(writer, r, "file.bin", time.Time{}, bytes.NewReader(s.content))
+	}))
+}
+
+func (s *MirrorsSuite) TearDownTest() {
+	s.fast.Close()
+	s.slow.Close()
+}
+
+func (s *MirrorsSuite) TestDownloader_ShouldDropSlowMirror() {
+	fs := afero.NewMemMapFs()
+	network, err := download.NewHTTPNetwork(download.HTTPConfig{})
+	s.Require().NoError(err)
+
+	config := download.Config{
+		Retry:       download.RetryPolicy{MaxAttempts: 1},
+		SlowMirrors: download.SlowMirrorPolicy{Window: 100 * time.Millisecond},
+	}
+	downloader := download.NewDownloader(network, download.NewStorage(fs, codec.NewYAMLCodec()),
+		progressbar.NoopProgressBar{}, logger.NoopConsoleLogger{}, config)
+
+	spec, err := downloader.InitDownload(s.fast.URL+"/file.bin", download.Options{Workers: 2, Mirrors: []string{s.slow.URL + "/file.bin"}})
+	s.Require().NoError(err)
+	s.Require().Equal([]string{s.slow.URL + "/file.bin"}, spec.Mirrors)
+
+	s.Require().NoError(downloader.Download(spec, context.TODO()))
+
+	output, err := afero.ReadFile(fs, spec.Id+"/output")
+	s.Require().NoError(err)
+	s.Equal(s.content, output)
+
+	// The segment of the slow mirror, which would take 2 seconds, was mostly downloaded from the fast mirror.
+	s.Less(s.slowWritten.Load(), int64(len(s.content)/4))
+}
+
+func (s *MirrorsSuite) TestDownloader_ShouldKeepMirrorsOfSimilarSpeed() {
+	fs := afero.NewMemMapFs()
+	network, err := download.NewHTTPNetwork(download.HTTPConfig{})
+	s.Require().NoError(err)
+
+	config := download.Config{
+		Retry:       download.RetryPolicy{MaxAttempts: 1},
+		SlowMirrors: download.SlowMirrorPolicy{Window: 100 * time.Millisecond},
+	}
+	downloader := download.NewDownloader(network, download.NewStorage(fs, codec.NewYAMLCodec()),
+		progressbar.NoopProgressBar{}, logger.NoopConsoleLogger{}, config)
+
+	// Both mirrors are slow, so neither is dropped, and each serves its segment.
+	s.content = s.content[:20_000]
+	spec, err := downloader.InitDownload(s.slow.URL+"/file.bin", download.Options{Workers: 2, Mirrors: []string{s.slow.URL + "/file.bin?copy"}})
+	s.Require().NoError(err)
+	s.Require().Len(spec.Mirrors, 1)
+	s.slowWritten.Store(0)
+
+	s.Require().NoError(downloader.Download(spec, context.TODO()))
+
+	output, err := afero.ReadFile(fs, spec.Id+"/output")
+	s.Require().NoError(err)
+	s.Equal(s.content, output)
+	s.Equal(int64(len(s.content)), s.slowWritten.Load())
+}
+
+func TestMirrorsSuite(t *testing.T) {
+	suite.Run(t, new(MirrorsSuite))
+}
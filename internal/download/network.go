@@ -7,28 +7,62 @@ import (
 	"github.com/MarcoTomasRodriguez/hget/pkg/fsutil"
 	"github.com/MarcoTomasRodriguez/hget/pkg/httputil"
 	"io"
+	"math"
 	"mime"
+	"net"
 	"net/http"
 	"net/url"
 	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
 )
 
+// maxReadResourceSize is the maximum size of the resources read in memory, such as checksum files.
+const maxReadResourceSize = 1 << 20
+
 var (
-	InvalidFilenameErr = errors.New("invalid filename")
-	SegmentOverflowErr = errors.New("segment overflow")
-	BufferCopyErr      = errors.New("could not copy buffer")
+	InvalidFilenameErr   = errors.New("invalid filename")
+	SegmentOverflowErr   = errors.New("segment overflow")
+	BufferCopyErr        = errors.New("could not copy buffer")
+	ResourceTooLargeErr  = errors.New("resource too large")
+	ResourceChangedErr   = errors.New("remote resource changed since the download started")
+	RangeNotSupportedErr = errors.New("server does not support range downloads")
 )
 
 type Resource struct {
-	URL          string
+	// URL is the URL the resource is downloaded from: the URL of the last response, if the request was redirected.
+	URL string
+	// OriginalURL is the requested URL, if it is not URL.
+	OriginalURL string
+	// Redirects are the URLs the request was redirected through, in order, the last being URL.
+	Redirects    []string
 	Filename     string
 	Size         int64
 	AcceptRanges bool
+	ETag         string
+	LastModified string
+	// ContentType is the media type of the resource, without parameters.
+	ContentType string
+	// ContentEncoding is the content coding the server applies to the resource itself, such as gzip, even when no
+	// coding is accepted. The size, ranges and digests of the resource then refer to the encoded bytes.
+	ContentEncoding string
+	// Compressed allows the server to compress the transfer of the whole resource, which is decoded as it is received.
+	// Partial transfers are never compressed, so that their ranges refer to the decoded bytes.
+	Compressed bool
+	// Duplicates are the URLs advertised by the server as serving the same resource, by Link headers with the
+	// duplicate relation (RFC 6249), in order of priority.
+	Duplicates []string
+	// Address is the IP address of the server which answered, if known. If set, the segment requests to the host of
+	// URL are sent to this address, so that every segment is served by the same server.
+	Address string
 }
 
 type Network interface {
 	FetchResource(url string) (Resource, error)
-	DownloadResource(url string, start int64, end int64, writer io.Writer, ctx context.Context) error
+	ReadResource(url string) ([]byte, error)
+	DownloadResource(resource Resource, start int64, end int64, writer io.Writer, ctx context.Context) error
 }
 
 type NetworkError string
@@ -37,34 +71,211 @@ func (e NetworkError) Error() string {
 	return fmt.Sprintf("network error: %s", string(e))
 }
 
-type network struct{}
+// StatusError is returned when a server answers with an unexpected HTTP status.
+type StatusError struct {
+	StatusCode int
+	Status     string
+	// RetryAfter is the delay requested by the server through the Retry-After header, if any.
+	RetryAfter time.Duration
+}
 
-// FetchResource fetches an HTTP resource and retrieves a description of the resource.
-func (n network) FetchResource(URL string) (Resource, error) {
-	// Resolve URL.
-	URL, err := httputil.ResolveURL(URL)
+func (e StatusError) Error() string {
+	return fmt.Sprintf("network error: unexpected status: %s", e.Status)
+}
+
+// newStatusError builds a StatusError from a response, parsing its Retry-After header, which can be expressed in
+// seconds or as an HTTP date.
+func newStatusError(response *http.Response) StatusError {
+	err := StatusError{StatusCode: response.StatusCode, Status: response.Status}
+
+	retryAfter := response.Header.Get("Retry-After")
+	if seconds, parseErr := strconv.Atoi(retryAfter); parseErr == nil && seconds > 0 {
+		err.RetryAfter = time.Duration(seconds) * time.Second
+	} else if date, parseErr := http.ParseTime(retryAfter); parseErr == nil {
+		err.RetryAfter = time.Until(date)
+	}
+
+	return err
+}
+
+// RangeError is returned when a server answers a range request with a range other than the requested one.
+type RangeError struct {
+	Start        int64
+	End          int64
+	StatusCode   int
+	ContentRange string
+}
+
+func (e RangeError) Error() string {
+	return fmt.Sprintf("network error: invalid range response: requested bytes %d-%d, got status %d with content range %q",
+		e.Start, e.End, e.StatusCode, e.ContentRange)
+}
+
+// HTTPConfig configures the requests of the http network.
+type HTTPConfig struct {
+	Proxy     ProxyConfig
+	Auth      AuthConfig
+	Redirect  RedirectConfig
+	Transport TransportConfig
+	DNS       DNSConfig
+	TLS       TLSConfig
+	// HostTLS holds the TLS configuration of some hosts, overriding TLS.
+	HostTLS  map[string]TLSConfig
+	Timeouts TimeoutConfig
+	// HostTimeouts holds the timeouts of some hosts, overriding Timeouts.
+	HostTimeouts map[string]TimeoutConfig
+	// Header holds the custom headers of every request, e.g. User-Agent or Referer. The headers carrying credentials
+	// are only sent to the origin of the authentication configuration, as the credentials.
+	Header http.Header
+	// CookieJar holds the cookies sent with the requests, and those set by the responses. If nil, an empty jar is used,
+	// so that the cookies set by the first response are sent with every segment request.
+	CookieJar http.CookieJar
+}
+
+type network struct {
+	client *http.Client
+	// hosts is the transport of the client, if it is not the default one.
+	hosts  *hostTransport
+	header http.Header
+	// secretHeader holds the custom headers carrying credentials, only sent to the origin.
+	secretHeader http.Header
+	origin       string
+	redirect     RedirectConfig
+	timeouts     TimeoutConfig
+	hostTimeouts map[string]TimeoutConfig
+}
+
+// timeoutsOf returns the timeouts of the host of the URL.
+func (n network) timeoutsOf(URL string) TimeoutConfig {
+	u, err := url.Parse(URL)
 	if err != nil {
-		return Resource{}, err
+		return n.timeouts
 	}
 
-	// Extract the download filename from the headers or from the URL.
-	path, err := url.PathUnescape(URL)
+	return n.timeouts.merge(n.hostTimeouts[strings.ToLower(u.Hostname())])
+}
+
+// do sends a request with the custom headers of the network, followed by the given headers. The custom headers
+// carrying credentials are only sent to the origin of the network.
+func (n network) do(ctx context.Context, method string, URL string, header http.Header) (*http.Response, error) {
+	request, err := http.NewRequestWithContext(ctx, method, URL, http.NoBody)
 	if err != nil {
-		return Resource{}, InvalidFilenameErr
+		return nil, NetworkError(err.Error())
+	}
+
+	if err := n.redirect.checkURL(request.URL); err != nil {
+		return nil, err
 	}
 
-	// Download http request.
-	response, err := http.Get(URL)
+	setHeader(request, n.header)
+	if httputil.Origin(request.URL) == n.origin {
+		setHeader(request, n.secretHeader)
+	}
+
+	setHeader(request, header)
+
+	response, err := n.client.Do(request)
 	if err != nil {
-		return Resource{}, NetworkError(err.Error())
+		if redirectError(err) {
+			return nil, err
+		}
+
+		if errors.Is(err, PinnedAddressErr) {
+			return nil, err
+		}
+
+		if certificateError(err) {
+			return nil, fmt.Errorf("%w: %v", CertificateErr, err)
+		}
+
+		return nil, NetworkError(err.Error())
+	}
+
+	// Some transports, such as mocks, do not record the request of the response, which locates the resource.
+	if response.Request == nil {
+		response.Request = request
+	}
+
+	return response, nil
+}
+
+// FetchResource fetches the headers of an HTTP resource with a HEAD request and retrieves a description of the
+// resource. Range support is confirmed by requesting the first byte of the resource, which also reveals its size
+// through the Content-Range header. Servers rejecting HEAD requests are described by the response to this request.
+// If the request is redirected, the resource is described by the last URL, including its filename. The first byte is
+// requested from the server which answered, whose address is recorded unless the requests are proxied. The URL is
+// expected to be resolved already, with the default scheme of the scheme network.
+func (n network) FetchResource(URL string) (Resource, error) {
+	// Fetch the headers of the resource, without content coding, so that its size is the number of bytes transferred.
+	// The host is resolved once, and the request is pinned to the first address accepting the connection. The
+	// address of the server which answered, after redirects, is recorded.
+	addresses := []string{""}
+	if n.hosts != nil {
+		if resolved, err := n.hosts.addresses(context.Background(), URL); err == nil && len(resolved) > 0 {
+			addresses = resolved
+		}
+	}
+
+	address := ""
+	var response *http.Response
+	var err error
+	for _, pinned := range addresses {
+		ctx := withPinnedAddress(traceAddress(context.Background(), &address), URL, pinned)
+		if response, err = n.do(ctx, "HEAD", URL, identityHeader()); !errors.Is(err, PinnedAddressErr) {
+			break
+		}
+	}
+
+	if err != nil {
+		return Resource{}, err
+	}
+
+	closeResponse(response)
+
+	if n.hosts != nil && proxied(n.hosts.transport(response.Request), response.Request) {
+		address = ""
+	}
+
+	// Request the first byte of the resource, from the URL the request was redirected to.
+	redirects := redirectChain(response)
+	probe, err := n.probeRange(response.Request.URL.String(), address)
+	if err != nil && !isSuccess(response) {
+		return Resource{}, err
 	}
 
-	_ = response.Body.Close()
+	// Describe the resource by the response to the first byte request if the server rejected the HEAD request.
+	size := response.ContentLength
+	if !isSuccess(response) {
+		if !isSuccess(probe) && probe.StatusCode != http.StatusRequestedRangeNotSatisfiable {
+			return Resource{}, newStatusError(probe)
+		}
+
+		redirects = append(redirects, redirectChain(probe)...)
+		response, size = probe, -1
+	}
 
-	// Extract Accept-Ranges from headers.
-	acceptRanges := response.Header.Get("Accept-Ranges")
+	// The size of the resource is confirmed by the partial response.
+	acceptRanges := false
+	if probe != nil {
+		switch probe.StatusCode {
+		case http.StatusPartialContent:
+			if first, _, length, err := httputil.ParseContentRange(probe.Header.Get("Content-Range")); err == nil && first == 0 && length >= 0 {
+				acceptRanges, size = true, length
+			}
+		case http.StatusOK:
+			if size < 0 {
+				size = probe.ContentLength
+			}
+		case http.StatusRequestedRangeNotSatisfiable:
+			// An empty resource has no first byte.
+			if probe.Header.Get("Content-Range") == "bytes */0" {
+				size = 0
+			}
+		}
+	}
 
-	filename := filepath.Base(path)
+	// Extract the download filename from the headers or from the path of the last URL, without its query.
+	filename := filepath.Base(response.Request.URL.Path)
 	_, contentDispositionParams, err := mime.ParseMediaType(response.Header.Get("Content-Disposition"))
 	if name, ok := contentDispositionParams["filename"]; ok {
 		filename = name
@@ -75,40 +286,227 @@ func (n network) FetchResource(URL string) (Resource, error) {
 		return Resource{}, InvalidFilenameErr
 	}
 
+	contentType, _, _ := mime.ParseMediaType(response.Header.Get("Content-Type"))
+
+	finalURL := response.Request.URL.String()
+	originalURL := ""
+	if finalURL != URL {
+		originalURL = URL
+	}
+
 	return Resource{
-		URL:          URL,
-		Filename:     filename,
-		Size:         response.ContentLength,
-		AcceptRanges: acceptRanges == "bytes",
+		URL:             finalURL,
+		OriginalURL:     originalURL,
+		Redirects:       redirects,
+		Filename:        filename,
+		Size:            size,
+		AcceptRanges:    acceptRanges,
+		ETag:            response.Header.Get("ETag"),
+		LastModified:    response.Header.Get("Last-Modified"),
+		ContentType:     contentType,
+		ContentEncoding: contentEncoding(response),
+		Duplicates:      duplicateLinks(finalURL, response),
+		Address:         address,
 	}, nil
 }
 
-// DownloadResource downloads a file using range-downloads and outputs the contents on the writer.
-func (n network) DownloadResource(url string, start int64, end int64, writer io.Writer, ctx context.Context) error {
+// identityHeader returns the headers of the requests refusing content codings, whose ranges and lengths refer to the
+// bytes of the resource as stored by the server. Setting the header also disables the transparent decompression of the
+// transport.
+func identityHeader() http.Header {
+	return http.Header{"Accept-Encoding": {"identity"}}
+}
+
+// probeRange requests the first byte of a resource, and closes the response without reading more than a small body,
+// so that the resource is not transferred if the server ignores the range. The request is pinned to the address, if any.
+func (n network) probeRange(URL string, address string) (*http.Response, error) {
+	header := identityHeader()
+	header.Set("Range", "bytes=0-0")
+
+	response, err := n.do(withPinnedAddress(context.Background(), URL, address), "GET", URL, header)
+	if err != nil {
+		return nil, err
+	}
+
+	closeResponse(response)
+
+	return response, nil
+}
+
+// isSuccess reports whether a response has a 2xx status.
+func isSuccess(response *http.Response) bool {
+	return response != nil && response.StatusCode >= 200 && response.StatusCode <= 299
+}
+
+// duplicateLinks returns the absolute URLs of the Link headers of a response with the duplicate relation, sorted by
+// their priority, from 1 (the highest) to 999999. Links without priority come last. Relative links are resolved against
+// the URL of the final request, or the requested URL.
+func duplicateLinks(requestURL string, response *http.Response) []string {
+	base, err := url.Parse(requestURL)
+	if err != nil {
+		return nil
+	}
+
+	if response.Request != nil {
+		base = response.Request.URL
+	}
+
+	type duplicate struct {
+		url      string
+		priority int
+	}
+
+	var duplicates []duplicate
+	for _, link := range httputil.ParseLinkHeader(response.Header.Values("Link")) {
+		if !strings.EqualFold(link.Params["rel"], "duplicate") {
+			continue
+		}
+
+		linkURL, err := url.Parse(link.URL)
+		if err != nil {
+			continue
+		}
+
+		linkURL = base.ResolveReference(linkURL)
+
+		priority, err := strconv.Atoi(link.Params["pri"])
+		if err != nil {
+			priority = math.MaxInt32
+		}
+
+		duplicates = append(duplicates, duplicate{url: linkURL.String(), priority: priority})
+	}
+
+	sort.SliceStable(duplicates, func(i, j int) bool { return duplicates[i].priority < duplicates[j].priority })
+
+	var urls []string
+	for _, duplicate := range duplicates {
+		urls = append(urls, duplicate.url)
+	}
+
+	return urls
+}
+
+// ReadResource reads a small HTTP resource, such as a checksum file, in memory.
+func (n network) ReadResource(url string) ([]byte, error) {
+	response, err := n.do(context.Background(), "GET", url, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	defer closeResponse(response)
+
+	if response.StatusCode < 200 || response.StatusCode > 299 {
+		return nil, NetworkError(response.Status)
+	}
+
+	// Read one byte more than the limit to detect larger resources.
+	content, err := io.ReadAll(io.LimitReader(response.Body, maxReadResourceSize+1))
+	if err != nil {
+		return nil, BufferCopyErr
+	}
+
+	if len(content) > maxReadResourceSize {
+		return nil, ResourceTooLargeErr
+	}
+
+	return content, nil
+}
+
+// DownloadResource downloads a file using range-downloads and outputs the contents on the writer. If the resource has
+// validators, the request is conditioned on them, so that bytes from a changed resource are never written. Range
+// requests refuse content codings, unless the resource is encoded by the server itself. If the end point is negative,
+// the resource is streamed until the end of the response, as its size is unknown. Resources without range support are
+// resumed by skipping the bytes before the start point. Stalled transfers are cancelled, so that they can be retried.
+// If the resource has an address, the requests to its host are sent to this address.
+func (n network) DownloadResource(resource Resource, start int64, end int64, writer io.Writer, ctx context.Context) error {
+	ctx = withPinnedAddress(ctx, resource.URL, resource.Address)
+	ctx, watchdog := n.timeoutsOf(resource.URL).watch(ctx)
+	err := n.downloadResource(resource, start, end, writer, watchdog, ctx)
+
+	// The cancellation of a stalled transfer takes precedence over the error it caused.
+	if stallErr := watchdog.stop(); stallErr != nil {
+		return stallErr
+	}
+
+	return err
+}
+
+// downloadResource downloads a range of a resource, reporting the bytes received to the watchdog.
+func (n network) downloadResource(resource Resource, start int64, end int64, writer io.Writer, watchdog *watchdog, ctx context.Context) error {
 	// Check if the segment has an overflow.
-	if start < 0 || start > end {
+	if start < 0 || (end >= 0 && start > end) {
 		return SegmentOverflowErr
 	}
 
-	// Check if download already finished.
-	if start == end {
+	// Check if download already finished. The end point is inclusive, except for the last segment, whose end point is
+	// the size of the resource.
+	if start == end && end >= resource.Size {
 		return nil
 	}
 
+	// Transfer the whole resource compressed if allowed, decoded on the fly by the transport.
+	if resource.Compressed && resource.ContentEncoding == "" && start == 0 && (end < 0 || resource.Size <= 0 || end >= resource.Size-1) {
+		return n.downloadCompressed(resource, writer, watchdog, ctx)
+	}
+
+	// Start range download, only if the resource did not change.
+	header := identityHeader()
+	header.Set("Range", fmt.Sprintf("bytes=%d-%d", start, end))
+	if end < 0 {
+		header.Set("Range", fmt.Sprintf("bytes=%d-", start))
+	}
+
+	if validator := resource.Validator(); validator != "" {
+		header.Set("If-Range", validator)
+	}
+
 	// Send HTTP GET request.
-	request, err := http.NewRequestWithContext(ctx, "GET", url, http.NoBody)
+	response, err := n.do(ctx, "GET", resource.URL, header)
 	if err != nil {
-		return NetworkError(err.Error())
+		return err
+	}
+
+	defer closeResponse(response)
+	response.Body = watchdog.body(response.Body)
+
+	// Servers without range support send the whole resource: skip the bytes before the start point, unless the
+	// resource changed. Otherwise, check that the response contains exactly the requested range before writing anything.
+	if response.StatusCode == http.StatusOK && start > 0 && !resource.AcceptRanges {
+		if err := skipResponse(resource, start, response); err != nil {
+			return err
+		}
+	} else if err := checkRangeResponse(resource, start, end, response, header.Get("If-Range") != ""); err != nil {
+		return err
 	}
 
-	// Start range download.
-	request.Header.Add("Range", fmt.Sprintf("bytes=%d-%d", start, end))
-	response, err := http.DefaultClient.Do(request)
+	_, err = io.Copy(writer, response.Body)
 	if err != nil {
-		return NetworkError(err.Error())
+		return BufferCopyErr
 	}
 
-	defer response.Body.Close()
+	return nil
+}
+
+// downloadCompressed downloads a whole resource with the content codings supported by the transport, which decodes the
+// response, so that the bytes written are those of the resource without coding.
+func (n network) downloadCompressed(resource Resource, writer io.Writer, watchdog *watchdog, ctx context.Context) error {
+	response, err := n.do(ctx, "GET", resource.URL, nil)
+	if err != nil {
+		return err
+	}
+
+	defer closeResponse(response)
+	response.Body = watchdog.body(response.Body)
+
+	if !isSuccess(response) {
+		return newStatusError(response)
+	}
+
+	// Content codings not decoded by the transport would be written as is.
+	if encoding := contentEncoding(response); encoding != "" {
+		return fmt.Errorf("%w: %s", UnexpectedEncodingErr, encoding)
+	}
 
 	_, err = io.Copy(writer, response.Body)
 	if err != nil {
@@ -118,9 +516,187 @@ func (n network) DownloadResource(url string, start int64, end int64, writer io.
 	return nil
 }
 
-// NewNetwork instantiates a new Network object.
+// fullResponseResource describes the resource of a full response by its size, validators and content coding.
+func fullResponseResource(response *http.Response) Resource {
+	return Resource{
+		Size:            response.ContentLength,
+		ETag:            response.Header.Get("ETag"),
+		LastModified:    response.Header.Get("Last-Modified"),
+		ContentEncoding: contentEncoding(response),
+	}
+}
+
+// skipResponse discards the first bytes of a full response, up to the start point, if it describes the resource.
+func skipResponse(resource Resource, start int64, response *http.Response) error {
+	if fullResponseResource(response).Changed(resource) {
+		return ResourceChangedErr
+	}
+
+	// A response shorter than the bytes already written comes from another version of the resource.
+	if _, err := io.CopyN(io.Discard, response.Body, start); errors.Is(err, io.EOF) {
+		return ResourceChangedErr
+	} else if err != nil {
+		return NetworkError(err.Error())
+	}
+
+	return nil
+}
+
+// checkRangeResponse checks that a response to a range request contains the requested range: a partial response whose
+// Content-Range matches the start and end points, clamped to the resource length. A negative end point matches any
+// last byte. A full response is only accepted if the range spans the whole resource.
+func checkRangeResponse(resource Resource, start int64, end int64, response *http.Response, conditional bool) error {
+	// The ranges of an encoded response refer to the encoded bytes, which only match those of a resource encoded alike.
+	if isSuccess(response) && contentEncoding(response) != resource.ContentEncoding {
+		return fmt.Errorf("%w: %q, expected %q", UnexpectedEncodingErr, contentEncoding(response), resource.ContentEncoding)
+	}
+
+	switch response.StatusCode {
+	case http.StatusPartialContent:
+		contentRange := response.Header.Get("Content-Range")
+		first, last, length, err := httputil.ParseContentRange(contentRange)
+		if err != nil {
+			return RangeError{Start: start, End: end, StatusCode: response.StatusCode, ContentRange: contentRange}
+		}
+
+		// The last segment may request bytes past the end of the resource.
+		expectedLast := end
+		if length >= 0 && (end < 0 || end >= length) {
+			expectedLast = length - 1
+		}
+
+		if first != start || (expectedLast >= 0 && last != expectedLast) {
+			return RangeError{Start: start, End: end, StatusCode: response.StatusCode, ContentRange: contentRange}
+		}
+
+		return nil
+	case http.StatusOK:
+		if start == 0 && (end < 0 || resource.Size <= 0 || end >= resource.Size-1) {
+			return nil
+		}
+
+		// A full response to a conditional range request means that the validator no longer matches, unless the server
+		// ignores the conditional range as it ignores ranges, and sends the same resource.
+		if conditional && fullResponseResource(response).Changed(resource) {
+			return ResourceChangedErr
+		}
+
+		return RangeNotSupportedErr
+	case http.StatusRequestedRangeNotSatisfiable:
+		return RangeError{Start: start, End: end, StatusCode: response.StatusCode, ContentRange: response.Header.Get("Content-Range")}
+	default:
+		return newStatusError(response)
+	}
+}
+
+// RequestedURL returns the URL requested to fetch the resource, before any redirect.
+func (r Resource) RequestedURL() string {
+	if r.OriginalURL != "" {
+		return r.OriginalURL
+	}
+
+	return r.URL
+}
+
+// Validator returns the value of the If-Range header for the resource: its strong ETag if available, otherwise its
+// Last-Modified date. Weak ETags cannot be used in range requests.
+func (r Resource) Validator() string {
+	if r.ETag != "" && !strings.HasPrefix(r.ETag, "W/") {
+		return r.ETag
+	}
+
+	return r.LastModified
+}
+
+// Changed reports whether the resource differs from a previously fetched one, comparing the validators and the size
+// recorded by both, and the content coding.
+func (r Resource) Changed(previous Resource) bool {
+	if r.ETag != "" && previous.ETag != "" && r.ETag != previous.ETag {
+		return true
+	}
+
+	if r.LastModified != "" && previous.LastModified != "" && r.LastModified != previous.LastModified {
+		return true
+	}
+
+	if r.ContentEncoding != previous.ContentEncoding {
+		return true
+	}
+
+	return r.Size > 0 && previous.Size > 0 && r.Size != previous.Size
+}
+
+// NewNetwork instantiates a new Network object, sending its requests with the default client.
 func NewNetwork() Network {
-	return &network{}
+	return &network{client: http.DefaultClient}
+}
+
+// NewHTTPNetwork creates a network for the http and https schemes, sending every request, from probing to segment
+// downloads, through its own transports and proxy. Hosts with their own TLS configuration or timeouts get their own
+// transport.
+func NewHTTPNetwork(config HTTPConfig) (Network, error) {
+	transport, err := newTransport(config, config.TLS, config.Timeouts)
+	if err != nil {
+		return nil, err
+	}
+
+	hostNames, hostTLS, hostTimeouts := map[string]bool{}, map[string]TLSConfig{}, map[string]TimeoutConfig{}
+	for host, tlsConfig := range config.HostTLS {
+		hostNames[strings.ToLower(host)], hostTLS[strings.ToLower(host)] = true, tlsConfig
+	}
+
+	for host, timeouts := range config.HostTimeouts {
+		hostNames[strings.ToLower(host)], hostTimeouts[strings.ToLower(host)] = true, timeouts
+	}
+
+	resolver, err := config.DNS.newDialer(&net.Dialer{Timeout: config.Timeouts.connectTimeout()})
+	if err != nil {
+		return nil, err
+	}
+
+	hosts := &hostTransport{fallback: transport, hosts: map[string]*http.Transport{}, resolver: resolver}
+	for host := range hostNames {
+		hosts.hosts[host], err = newTransport(config, config.TLS.merge(hostTLS[host]), config.Timeouts.merge(hostTimeouts[host]))
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", host, err)
+		}
+	}
+
+	jar := config.CookieJar
+	if jar == nil {
+		jar = httputil.NewCookieJar()
+	}
+
+	// The redirects keep the headers of the request, which must not carry credentials to other origins.
+	header, secretHeader := splitSecretHeaders(config.Header)
+	checkRedirect := func(request *http.Request, via []*http.Request) error {
+		if err := config.Redirect.checkRedirect(request, via); err != nil {
+			return err
+		}
+
+		if httputil.Origin(request.URL) != config.Auth.Origin {
+			for name := range secretHeader {
+				request.Header.Del(name)
+			}
+		}
+
+		return nil
+	}
+
+	return &network{
+		client: &http.Client{
+			Transport:     authTransport{config: config.Auth, next: hosts},
+			CheckRedirect: checkRedirect,
+			Jar:           jar,
+		},
+		hosts:        hosts,
+		header:       header,
+		secretHeader: secretHeader,
+		origin:       config.Auth.Origin,
+		redirect:     config.Redirect,
+		timeouts:     config.Timeouts,
+		hostTimeouts: hostTimeouts,
+	}, nil
 }
 
 var _ Network = (*network)(nil)
@@ -3,13 +3,18 @@ package download_test
 import (
 	"bytes"
 	"context"
+	"fmt"
 	"github.com/MarcoTomasRodriguez/hget/internal/download"
 	"github.com/MarcoTomasRodriguez/hget/pkg/httputil"
 	"github.com/jarcoal/httpmock"
 	"github.com/stretchr/testify/suite"
 	"math/rand"
 	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
 	"testing"
+	"time"
 )
 
 var golangResource = download.Resource{
@@ -52,7 +57,7 @@ func (s *NetworkSuite) TestNetwork_DownloadResource() {
 	buffer := new(bytes.Buffer)
 	segment := javaSample.Segments[1]
 
-	err := network.DownloadResource(javaSample.URL, segment.Start, segment.End, buffer, context.TODO())
+	err := network.DownloadResource(javaResource, segment.Start, segment.End, buffer, context.TODO())
 	s.NoError(err)
 	s.Equal(body[segment.Start:segment.End+1], buffer.Bytes())
 }
@@ -64,12 +69,20 @@ func (s *NetworkSuite) TestNetwork_DownloadResource_ShouldDoNothingIfAlreadyFini
 	rand.Read(body)
 	httputil.RegisterResponder(javaSample.URL, body, http.Header{"Accept-Ranges": []string{"bytes"}})
 
+	// Only the end point of the last segment, the resource size, is exclusive.
 	buffer := new(bytes.Buffer)
-	segment := javaSample.Segments[1]
+	segment := javaSample.Segments[len(javaSample.Segments)-1]
 
-	err := network.DownloadResource(javaSample.URL, segment.End, segment.End, buffer, context.TODO())
+	err := network.DownloadResource(javaResource, segment.End, segment.End, buffer, context.TODO())
 	s.NoError(err)
 	s.Equal(0, buffer.Len())
+
+	// The last byte of the other segments is still downloaded.
+	segment = javaSample.Segments[1]
+
+	err = network.DownloadResource(javaResource, segment.End, segment.End, buffer, context.TODO())
+	s.NoError(err)
+	s.Equal(body[segment.End:segment.End+1], buffer.Bytes())
 }
 
 func (s *NetworkSuite) TestNetwork_DownloadResource_ShouldFailIfPositionExceedsRange() {
@@ -82,7 +95,7 @@ func (s *NetworkSuite) TestNetwork_DownloadResource_ShouldFailIfPositionExceedsR
 	buffer := new(bytes.Buffer)
 	segment := javaSample.Segments[1]
 
-	err := network.DownloadResource(javaSample.URL, segment.End+1, segment.End, buffer, context.TODO())
+	err := network.DownloadResource(javaResource, segment.End+1, segment.End, buffer, context.TODO())
 	s.ErrorIs(err, download.SegmentOverflowErr)
 	s.Equal(0, buffer.Len())
 }
@@ -93,11 +106,353 @@ func (s *NetworkSuite) TestNetwork_DownloadResource_ShouldFailIfServerNotAvailab
 	buffer := new(bytes.Buffer)
 	segment := javaSample.Segments[1]
 
-	err := network.DownloadResource("th1ss1t3sh0uldn0tex1st.test/path/to/file.txt", segment.Start, segment.End, buffer, context.TODO())
+	err := network.DownloadResource(download.Resource{URL: "th1ss1t3sh0uldn0tex1st.test/path/to/file.txt"}, segment.Start, segment.End, buffer, context.TODO())
 	s.Error(err)
 	s.Equal(0, buffer.Len())
 }
 
+func (s *NetworkSuite) TestNetwork_FetchResource_ShouldRecordValidators() {
+	network := download.NewNetwork()
+
+	httputil.RegisterResponder(javaResource.URL, make([]byte, javaResource.Size), http.Header{
+		"Accept-Ranges": []string{"bytes"},
+		"Etag":          []string{`"v1"`},
+		"Last-Modified": []string{"Wed, 21 Oct 2015 07:28:00 GMT"},
+	})
+
+	resource, err := network.FetchResource(javaResource.URL)
+	s.NoError(err)
+	s.Equal(`"v1"`, resource.ETag)
+	s.Equal("Wed, 21 Oct 2015 07:28:00 GMT", resource.LastModified)
+}
+
+// headResponder responds to HEAD requests with the headers of a resource of the given size.
+func headResponder(size int64, header http.Header) httpmock.Responder {
+	return func(request *http.Request) (*http.Response, error) {
+		response := httpmock.NewBytesResponse(http.StatusOK, nil)
+		response.ContentLength = size
+		response.Header = header
+		return response, nil
+	}
+}
+
+func (s *NetworkSuite) TestNetwork_FetchResource_ShouldOnlyRequestFirstByte() {
+	network := download.NewNetwork()
+
+	var ranges []string
+	httpmock.RegisterResponder("HEAD", javaResource.URL, headResponder(javaResource.Size, http.Header{}))
+	httpmock.RegisterResponder("GET", javaResource.URL, func(request *http.Request) (*http.Response, error) {
+		ranges = append(ranges, request.Header.Get("Range"))
+		response := httpmock.NewBytesResponse(http.StatusPartialContent, []byte{0})
+		response.Header.Set("Content-Range", fmt.Sprintf("bytes 0-0/%d", javaResource.Size))
+		return response, nil
+	})
+
+	resource, err := network.FetchResource(javaResource.URL)
+	s.NoError(err)
+	s.Equal([]string{"bytes=0-0"}, ranges)
+	s.True(resource.AcceptRanges)
+	s.Equal(javaResource.Size, resource.Size)
+}
+
+func (s *NetworkSuite) TestNetwork_FetchResource_ShouldConfirmRangeSupport() {
+	network := download.NewNetwork()
+
+	// The server advertises range support, but ignores the Range header.
+	httpmock.RegisterResponder("HEAD", javaResource.URL, headResponder(javaResource.Size, http.Header{"Accept-Ranges": []string{"bytes"}}))
+	httpmock.RegisterResponder("GET", javaResource.URL, httpmock.NewBytesResponder(http.StatusOK, make([]byte, javaResource.Size)))
+
+	resource, err := network.FetchResource(javaResource.URL)
+	s.NoError(err)
+	s.False(resource.AcceptRanges)
+	s.Equal(javaResource.Size, resource.Size)
+}
+
+func (s *NetworkSuite) TestNetwork_FetchResource_ShouldFallBackToRangeRequest() {
+	network := download.NewNetwork()
+
+	// The server rejects HEAD requests, but supports ranges.
+	httputil.RegisterResponder(javaResource.URL, make([]byte, javaResource.Size), http.Header{"Etag": []string{`"v1"`}})
+	httpmock.RegisterResponder("HEAD", javaResource.URL, httpmock.NewStringResponder(http.StatusMethodNotAllowed, ""))
+
+	resource, err := network.FetchResource(javaResource.URL)
+	s.NoError(err)
+	s.True(resource.AcceptRanges)
+	s.Equal(javaResource.Size, resource.Size)
+	s.Equal(`"v1"`, resource.ETag)
+}
+
+func (s *NetworkSuite) TestNetwork_FetchResource_ShouldFailIfNotFound() {
+	network := download.NewNetwork()
+	httpmock.RegisterResponder("HEAD", javaResource.URL, httpmock.NewStringResponder(http.StatusNotFound, ""))
+	httpmock.RegisterResponder("GET", javaResource.URL, httpmock.NewStringResponder(http.StatusNotFound, ""))
+
+	_, err := network.FetchResource(javaResource.URL)
+	var statusErr download.StatusError
+	s.ErrorAs(err, &statusErr)
+	s.Equal(http.StatusNotFound, statusErr.StatusCode)
+}
+
+func (s *NetworkSuite) TestNetwork_FetchResource_ShouldRecordDuplicates() {
+	network := download.NewNetwork()
+
+	httputil.RegisterResponder(javaResource.URL, make([]byte, javaResource.Size), http.Header{
+		"Accept-Ranges": []string{"bytes"},
+		"Link": []string{
+			`<https://mirror2.java.com/jre.dmg>; rel=duplicate; pri=2`,
+			`</mirror/jre.dmg>; rel=duplicate, <https://mirror1.java.com/jre.dmg>; rel=duplicate; pri=1`,
+			`<https://java.com/jre.dmg.meta4>; rel=describedby`,
+		},
+	})
+
+	resource, err := network.FetchResource(javaResource.URL)
+	s.NoError(err)
+	s.Equal([]string{
+		"https://mirror1.java.com/jre.dmg",
+		"https://mirror2.java.com/jre.dmg",
+		"https://java.com/mirror/jre.dmg",
+	}, resource.Duplicates)
+}
+
+func (s *NetworkSuite) TestNetwork_DownloadResource_ShouldSendIfRange() {
+	network := download.NewNetwork()
+
+	resource := javaResource
+	resource.ETag = `"v1"`
+
+	httpmock.RegisterResponder("GET", resource.URL, func(request *http.Request) (*http.Response, error) {
+		s.Equal(`"v1"`, request.Header.Get("If-Range"))
+		response := httpmock.NewBytesResponse(http.StatusPartialContent, []byte("abc"))
+		response.Header.Set("Content-Range", fmt.Sprintf("bytes 10-12/%d", resource.Size))
+		return response, nil
+	})
+
+	buffer := new(bytes.Buffer)
+	err := network.DownloadResource(resource, 10, 12, buffer, context.TODO())
+	s.NoError(err)
+	s.Equal("abc", buffer.String())
+}
+
+func (s *NetworkSuite) TestNetwork_DownloadResource_ShouldFailIfResourceChanged() {
+	network := download.NewNetwork()
+
+	resource := javaResource
+	resource.ETag = `"v1"`
+
+	// The server ignores the range, as the If-Range validator no longer matches the new version of the resource.
+	httpmock.RegisterResponder("GET", resource.URL, func(request *http.Request) (*http.Response, error) {
+		response := httpmock.NewBytesResponse(http.StatusOK, make([]byte, resource.Size))
+		response.Header.Set("ETag", `"v2"`)
+		return response, nil
+	})
+
+	buffer := new(bytes.Buffer)
+	err := network.DownloadResource(resource, 10, 20, buffer, context.TODO())
+	s.ErrorIs(err, download.ResourceChangedErr)
+	s.Equal(0, buffer.Len())
+}
+
+func (s *NetworkSuite) TestNetwork_DownloadResource_ShouldFailIfConditionalRangeIgnored() {
+	network := download.NewNetwork()
+
+	resource := javaResource
+	resource.ETag = `"v1"`
+
+	// The server ignores the range and the If-Range header, and sends the same version of the resource.
+	httpmock.RegisterResponder("GET", resource.URL, func(request *http.Request) (*http.Response, error) {
+		response := httpmock.NewBytesResponse(http.StatusOK, make([]byte, resource.Size))
+		response.Header.Set("ETag", `"v1"`)
+		return response, nil
+	})
+
+	err := network.DownloadResource(resource, 10, 20, new(bytes.Buffer), context.TODO())
+	s.ErrorIs(err, download.RangeNotSupportedErr)
+}
+
+func (s *NetworkSuite) TestNetwork_DownloadResource_ShouldFailIfUnexpectedStatus() {
+	network := download.NewNetwork()
+
+	httpmock.RegisterResponder("GET", javaResource.URL, func(request *http.Request) (*http.Response, error) {
+		response := httpmock.NewStringResponse(http.StatusServiceUnavailable, "Service Unavailable")
+		response.Header.Set("Retry-After", "120")
+		return response, nil
+	})
+
+	buffer := new(bytes.Buffer)
+	err := network.DownloadResource(javaResource, 0, 10, buffer, context.TODO())
+
+	var statusErr download.StatusError
+	s.ErrorAs(err, &statusErr)
+	s.Equal(http.StatusServiceUnavailable, statusErr.StatusCode)
+	s.Equal(2*time.Minute, statusErr.RetryAfter)
+	s.Equal(0, buffer.Len())
+}
+
+func (s *NetworkSuite) TestNetwork_DownloadResource_ShouldAcceptFullResponseForWholeResource() {
+	network := download.NewNetwork()
+
+	body := make([]byte, golangResource.Size)
+	rand.Read(body)
+	httpmock.RegisterResponder("GET", golangResource.URL, httpmock.NewBytesResponder(http.StatusOK, body))
+
+	buffer := new(bytes.Buffer)
+	err := network.DownloadResource(golangResource, 0, golangResource.Size, buffer, context.TODO())
+	s.NoError(err)
+	s.Equal(body, buffer.Bytes())
+}
+
+func (s *NetworkSuite) TestNetwork_DownloadResource_ShouldFailIfRangeNotSupported() {
+	network := download.NewNetwork()
+
+	// The server ignores the range and sends the whole body.
+	httpmock.RegisterResponder("GET", javaResource.URL, httpmock.NewBytesResponder(http.StatusOK, make([]byte, javaResource.Size)))
+
+	buffer := new(bytes.Buffer)
+	segment := javaSample.Segments[0]
+
+	err := network.DownloadResource(javaResource, segment.Start, segment.End, buffer, context.TODO())
+	s.ErrorIs(err, download.RangeNotSupportedErr)
+	s.Equal(0, buffer.Len())
+}
+
+func (s *NetworkSuite) TestNetwork_DownloadResource_ShouldFailIfRangeMismatch() {
+	testCases := []struct {
+		name         string
+		status       int
+		contentRange string
+	}{
+		{"missing content range", http.StatusPartialContent, ""},
+		{"different start", http.StatusPartialContent, "bytes 0-1289/2583"},
+		{"shorter range", http.StatusPartialContent, "bytes 645-1000/2583"},
+		{"range not satisfiable", http.StatusRequestedRangeNotSatisfiable, "bytes */2583"},
+	}
+
+	for _, tc := range testCases {
+		s.Run(tc.name, func() {
+			network := download.NewNetwork()
+
+			httpmock.RegisterResponder("GET", javaResource.URL, func(request *http.Request) (*http.Response, error) {
+				response := httpmock.NewStringResponse(tc.status, "<html>Error</html>")
+				response.Header.Set("Content-Range", tc.contentRange)
+				return response, nil
+			})
+
+			buffer := new(bytes.Buffer)
+			segment := javaSample.Segments[1]
+
+			err := network.DownloadResource(javaResource, segment.Start, segment.End, buffer, context.TODO())
+
+			var rangeErr download.RangeError
+			s.ErrorAs(err, &rangeErr)
+			s.Equal(tc.status, rangeErr.StatusCode)
+			s.Equal(0, buffer.Len())
+		})
+	}
+}
+
+func (s *NetworkSuite) TestResource_Validator() {
+	s.Equal(`"v1"`, download.Resource{ETag: `"v1"`, LastModified: "Wed, 21 Oct 2015 07:28:00 GMT"}.Validator())
+	s.Equal("Wed, 21 Oct 2015 07:28:00 GMT", download.Resource{ETag: `W/"v1"`, LastModified: "Wed, 21 Oct 2015 07:28:00 GMT"}.Validator())
+	s.Empty(download.Resource{ETag: `W/"v1"`}.Validator())
+}
+
+func (s *NetworkSuite) TestResource_Changed() {
+	resource := download.Resource{Size: 10, ETag: `"v1"`}
+
+	s.False(resource.Changed(download.Resource{Size: 10, ETag: `"v1"`}))
+	s.False(resource.Changed(download.Resource{Size: 10}))
+	s.True(resource.Changed(download.Resource{Size: 10, ETag: `"v2"`}))
+	s.True(resource.Changed(download.Resource{Size: 11, ETag: `"v1"`}))
+}
+
+func (s *NetworkSuite) TestNetwork_ReadResource() {
+	network := download.NewNetwork()
+
+	httputil.RegisterResponder(golangSample.URL+".sha256", []byte(helloSha256), http.Header{})
+
+	content, err := network.ReadResource(golangSample.URL + ".sha256")
+	s.NoError(err)
+	s.Equal([]byte(helloSha256), content)
+}
+
+func (s *NetworkSuite) TestNetwork_ReadResource_ShouldFailIfNotFound() {
+	network := download.NewNetwork()
+	httpmock.RegisterResponder("GET", golangSample.URL+".sha256", httpmock.NewStringResponder(http.StatusNotFound, "Not Found"))
+
+	content, err := network.ReadResource(golangSample.URL + ".sha256")
+	s.Error(err)
+	s.Nil(content)
+}
+
+func (s *NetworkSuite) TestHTTPNetwork_ShouldSendHeadersAndCookies() {
+	content := make([]byte, 2583)
+	rand.Read(content)
+
+	var mu sync.Mutex
+	var requests []*http.Request
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		requests = append(requests, r)
+		mu.Unlock()
+
+		// The first response opens a session, required by the following requests.
+		if _, err := r.Cookie("session"); err != nil {
+			http.SetCookie(w, &http.Cookie{Name: "session", Value: "abc123", Path: "/"})
+		}
+
+		http.ServeContent(w, r, "file.bin", time.Time{}, bytes.NewReader(content))
+	}))
+	defer server.Close()
+
+	network, _ := download.NewHTTPNetwork(download.HTTPConfig{
+		Header: http.Header{"User-Agent": {"hget-test"}, "Referer": {"https://portal.test/"}, "X-Custom": {"1", "2"}},
+	})
+
+	resource, err := network.FetchResource(server.URL + "/file.bin")
+	s.NoError(err)
+
+	buffer := new(bytes.Buffer)
+	s.NoError(network.DownloadResource(resource, 0, 999, buffer, context.TODO()))
+	s.NoError(network.DownloadResource(resource, 1000, resource.Size, buffer, context.TODO()))
+	s.Equal(content, buffer.Bytes())
+
+	s.Len(requests, 4)
+	for i, request := range requests {
+		s.Equal("hget-test", request.UserAgent())
+		s.Equal("https://portal.test/", request.Referer())
+		s.Equal([]string{"1", "2"}, request.Header.Values("X-Custom"))
+
+		if i > 0 {
+			cookie, err := request.Cookie("session")
+			s.NoError(err)
+			s.Equal("abc123", cookie.Value)
+		}
+	}
+}
+
+func (s *NetworkSuite) TestDefaultNetwork_ShouldRequestWithDefaultScheme() {
+	content := make([]byte, 1000)
+	rand.Read(content)
+
+	// The server only answers https requests.
+	server := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.ServeContent(w, r, "file.bin", time.Time{}, bytes.NewReader(content))
+	}))
+	defer server.Close()
+
+	network, err := download.NewDefaultNetwork(download.NetworkConfig{
+		DefaultScheme: "https",
+		HTTP:          download.HTTPConfig{TLS: download.TLSConfig{Insecure: true}},
+	})
+	s.Require().NoError(err)
+
+	// The URL without scheme is requested with the default scheme, not the one of the http network.
+	resource, err := network.FetchResource(strings.TrimPrefix(server.URL, "https://") + "/file.bin")
+	s.Require().NoError(err)
+	s.Equal(server.URL+"/file.bin", resource.URL)
+	s.Equal(int64(len(content)), resource.Size)
+}
+
 func TestNetworkSuite(t *testing.T) {
 	suite.Run(t, new(NetworkSuite))
 }
@@ -0,0 +1,120 @@
+package download
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/http/httptrace"
+	"net/url"
+	"strings"
+)
+
+var PinnedAddressErr = errors.New("pinned address unreachable")
+
+// pinKey is the context key of the address the requests to a host are pinned to.
+type pinKey struct{}
+
+// addressPin pins the connections to a host, given as "host:port", to one of its addresses.
+type addressPin struct {
+	host    string
+	address string
+}
+
+// hostPort returns the lowercase host and port of a URL, with the default port of its scheme if it has none.
+func hostPort(u *url.URL) string {
+	port := u.Port()
+	if port == "" {
+		port = "80"
+		if u.Scheme == "https" {
+			port = "443"
+		}
+	}
+
+	return net.JoinHostPort(strings.ToLower(u.Hostname()), port)
+}
+
+// sameHost reports whether two URLs have the same host and port.
+func sameHost(a string, b string) bool {
+	aURL, aErr := url.Parse(a)
+	bURL, bErr := url.Parse(b)
+
+	return aErr == nil && bErr == nil && hostPort(aURL) == hostPort(bURL)
+}
+
+// withPinnedAddress returns a context pinning the connections of its requests to the host of the URL to the address.
+// The requests to other hosts, e.g. after a redirect, are not pinned.
+func withPinnedAddress(ctx context.Context, URL string, address string) context.Context {
+	u, err := url.Parse(URL)
+	if err != nil || address == "" {
+		return ctx
+	}
+
+	return context.WithValue(ctx, pinKey{}, addressPin{host: hostPort(u), address: address})
+}
+
+// pinnedAddress returns the address the connection of a request is pinned to, if any.
+func pinnedAddress(request *http.Request) (string, bool) {
+	pin, ok := request.Context().Value(pinKey{}).(addressPin)
+	if !ok || pin.host != hostPort(request.URL) {
+		return "", false
+	}
+
+	return pin.address, true
+}
+
+// traceAddress returns a context recording the IP address of the server answering its requests, i.e. the last one if
+// they are redirected.
+func traceAddress(ctx context.Context, address *string) context.Context {
+	return httptrace.WithClientTrace(ctx, &httptrace.ClientTrace{
+		GotConn: func(info httptrace.GotConnInfo) {
+			if tcpAddr, ok := info.Conn.RemoteAddr().(*net.TCPAddr); ok {
+				*address = tcpAddr.IP.String()
+			}
+		},
+	})
+}
+
+// pinnedTransport returns a transport sending the requests of the given transport to the address, keeping their
+// host for the Host header and the TLS server name. Its connections are not shared with the given transport, which
+// may be connected to other addresses of the host.
+func (t *hostTransport) pinnedTransport(transport *http.Transport, address string) *http.Transport {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	key := pinnedTransportKey{transport: transport, address: address}
+	if pinned, ok := t.pinned[key]; ok {
+		return pinned
+	}
+
+	dial := transport.DialContext
+	pinned := transport.Clone()
+	pinned.DialContext = func(ctx context.Context, network string, hostPort string) (net.Conn, error) {
+		_, port, err := net.SplitHostPort(hostPort)
+		if err != nil {
+			return nil, err
+		}
+
+		conn, err := dial(ctx, network, net.JoinHostPort(address, port))
+		if err != nil {
+			return nil, fmt.Errorf("%w: %s: %v", PinnedAddressErr, address, err)
+		}
+
+		return conn, nil
+	}
+
+	if t.pinned == nil {
+		t.pinned = map[pinnedTransportKey]*http.Transport{}
+	}
+
+	t.pinned[key] = pinned
+
+	return pinned
+}
+
+// pinnedTransportKey identifies the transport of a host pinned to an address.
+type pinnedTransportKey struct {
+	transport *http.Transport
+	address   string
+}
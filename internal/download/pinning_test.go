@@ -0,0 +1,276 @@
+package download_test
+
+import (
+	"bytes"
+	"context"
+	"github.com/MarcoTomasRodriguez/hget/internal/download"
+	"github.com/MarcoTomasRodriguez/hget/pkg/codec"
+	"github.com/MarcoTomasRodriguez/hget/pkg/logger"
+	"github.com/MarcoTomasRodriguez/hget/pkg/progressbar"
+	"github.com/spf13/afero"
+	"github.com/stretchr/testify/suite"
+	"math/rand"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+)
+
+// pinnedRequest is a request received by an edge.
+type pinnedRequest struct {
+	edge       string
+	host       string
+	serverName string
+}
+
+type PinningSuite struct {
+	suite.Suite
+	content []byte
+	// edges serve the same resource on 127.0.0.1 and 127.0.0.2, on the same port. Nothing listens on 127.0.0.3.
+	edges []*httptest.Server
+	port  string
+	// etags are the ETags of the resource served by each edge.
+	etags    []string
+	mu       sync.Mutex
+	requests []pinnedRequest
+}
+
+func (s *PinningSuite) SetupTest() {
+	s.content = make([]byte, 100_000)
+	rand.Read(s.content)
+	s.etags = []string{`"v1"`, `"v1"`}
+	s.requests = nil
+	s.edges = nil
+
+	for i, address := range []string{"127.0.0.1", "127.0.0.2"} {
+		listenAddress := address + ":0"
+		if s.port != "" {
+			listenAddress = net.JoinHostPort(address, s.port)
+		}
+
+		listener, err := net.Listen("tcp", listenAddress)
+		s.Require().NoError(err)
+
+		_, s.port, _ = net.SplitHostPort(listener.Addr().String())
+
+		i, address := i, address
+		edge := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			request := pinnedRequest{edge: address, host: r.Host}
+			if r.TLS != nil {
+				request.serverName = r.TLS.ServerName
+			}
+
+			s.mu.Lock()
+			s.requests = append(s.requests, request)
+			etag := s.etags[i]
+			s.mu.Unlock()
+
+			w.Header().Set("ETag", etag)
+			http.ServeContent(w, r, "file.bin", time.Time{}, bytes.NewReader(s.content))
+		}))
+		_ = edge.Listener.Close()
+		edge.Listener = listener
+
+		s.edges = append(s.edges, edge)
+	}
+}
+
+func (s *PinningSuite) TearDownTest() {
+	for _, edge := range s.edges {
+		edge.Close()
+	}
+
+	s.port = ""
+}
+
+func (s *PinningSuite) start() {
+	for _, edge := range s.edges {
+		edge.Start()
+	}
+}
+
+// url returns the URL of the resource on the host of the edges.
+func (s *PinningSuite) url(scheme string) string {
+	return scheme + "://edge.cdn.test:" + s.port + "/file.bin"
+}
+
+// network returns a network resolving the host of the edges to the addresses, separated by commas.
+func (s *PinningSuite) network(addresses string) download.Network {
+	network, err := download.NewHTTPNetwork(download.HTTPConfig{
+		DNS: download.DNSConfig{Resolve: []string{"edge.cdn.test:" + s.port + ":" + addresses}},
+		TLS: download.TLSConfig{Insecure: true},
+	})
+	s.Require().NoError(err)
+
+	return network
+}
+
+func (s *PinningSuite) downloader(network download.Network, storage download.Storage) download.Downloader {
+	return download.NewDownloader(network, storage, progressbar.NoopProgressBar{}, logger.NoopConsoleLogger{},
+		download.Config{Retry: download.RetryPolicy{MaxAttempts: 3, BaseDelay: time.Millisecond}})
+}
+
+// servedBy returns the edges which received the requests.
+func (s *PinningSuite) servedBy() map[string]bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	edges := map[string]bool{}
+	for _, request := range s.requests {
+		edges[request.edge] = true
+	}
+
+	return edges
+}
+
+func (s *PinningSuite) TestHTTPNetwork_ShouldRecordAddress() {
+	s.start()
+
+	resource, err := s.network("127.0.0.1,127.0.0.2").FetchResource(s.url("http"))
+	s.Require().NoError(err)
+	s.Equal("127.0.0.1", resource.Address)
+
+	// The unreachable addresses are skipped.
+	resource, err = s.network("127.0.0.3,127.0.0.2").FetchResource(s.url("http"))
+	s.Require().NoError(err)
+	s.Equal("127.0.0.2", resource.Address)
+
+	// The default network records the address too.
+	resource, err = download.NewNetwork().FetchResource("http://127.0.0.1:" + s.port + "/file.bin")
+	s.Require().NoError(err)
+	s.Equal("127.0.0.1", resource.Address)
+}
+
+func (s *PinningSuite) TestHTTPNetwork_ShouldPinConnections() {
+	for _, scheme := range []string{"http", "https"} {
+		for _, edge := range s.edges {
+			edge.Close()
+		}
+
+		s.SetupTest()
+		if scheme == "https" {
+			for _, edge := range s.edges {
+				edge.StartTLS()
+			}
+		} else {
+			s.start()
+		}
+
+		network := s.network("127.0.0.1,127.0.0.2")
+		resource, err := network.FetchResource(s.url(scheme))
+		s.Require().NoError(err)
+
+		s.mu.Lock()
+		s.requests = nil
+		s.mu.Unlock()
+
+		resource.Address = "127.0.0.2"
+		buffer := new(bytes.Buffer)
+		s.Require().NoError(network.DownloadResource(resource, 0, resource.Size, buffer, context.TODO()))
+		s.Equal(s.content, buffer.Bytes())
+
+		// The request keeps the host of the URL, and its server name.
+		s.Require().Len(s.requests, 1)
+		s.Equal("127.0.0.2", s.requests[0].edge)
+		s.Equal("edge.cdn.test:"+s.port, s.requests[0].host)
+		if scheme == "https" {
+			s.Equal("edge.cdn.test", s.requests[0].serverName)
+		}
+	}
+}
+
+func (s *PinningSuite) TestHTTPNetwork_ShouldFailIfPinnedAddressUnreachable() {
+	s.start()
+
+	network := s.network("127.0.0.1")
+	resource, err := network.FetchResource(s.url("http"))
+	s.Require().NoError(err)
+
+	resource.Address = "127.0.0.3"
+	err = network.DownloadResource(resource, 0, resource.Size, new(bytes.Buffer), context.TODO())
+	s.ErrorIs(err, download.PinnedAddressErr)
+	s.True(download.Retryable(err))
+}
+
+func (s *PinningSuite) TestDownloader_ShouldPinSegments() {
+	s.start()
+
+	storage := download.NewStorage(afero.NewMemMapFs(), codec.NewYAMLCodec())
+	spec, err := s.downloader(s.network("127.0.0.1,127.0.0.2"), storage).InitDownload(s.url("http"), download.Options{Workers: 4})
+	s.Require().NoError(err)
+	s.Equal("127.0.0.1", spec.Address)
+
+	// The download is resumed from the pinned address, even if the host resolves to another one first.
+	s.mu.Lock()
+	s.requests = nil
+	s.mu.Unlock()
+
+	s.Require().NoError(s.downloader(s.network("127.0.0.2,127.0.0.1"), storage).Download(spec, context.TODO()))
+	s.Equal(map[string]bool{"127.0.0.1": true}, s.servedBy())
+
+	saved, err := storage.ReadDownloadSpec(spec.Id)
+	s.Require().NoError(err)
+	s.Equal("127.0.0.1", saved.Address)
+}
+
+func (s *PinningSuite) TestDownloader_ShouldNotPin() {
+	s.start()
+
+	downloader := s.downloader(s.network("127.0.0.1,127.0.0.2"), download.NewStorage(afero.NewMemMapFs(), codec.NewYAMLCodec()))
+
+	spec, err := downloader.InitDownload(s.url("http"), download.Options{Workers: 4, NoPin: true})
+	s.Require().NoError(err)
+	s.Empty(spec.Address)
+
+	// Downloads with a single segment are not pinned.
+	spec, err = downloader.InitDownload(s.url("http"), download.Options{Workers: 1})
+	s.Require().NoError(err)
+	s.Empty(spec.Address)
+}
+
+func (s *PinningSuite) TestDownloader_ShouldFailOverUnreachableAddress() {
+	s.start()
+
+	fs := afero.NewMemMapFs()
+	storage := download.NewStorage(fs, codec.NewYAMLCodec())
+	downloader := s.downloader(s.network("127.0.0.3,127.0.0.2"), storage)
+
+	spec, err := downloader.InitDownload(s.url("http"), download.Options{Workers: 4})
+	s.Require().NoError(err)
+	s.Equal("127.0.0.2", spec.Address)
+
+	// The pinned address goes down.
+	spec.Address = "127.0.0.3"
+	s.Require().NoError(downloader.Download(spec, context.TODO()))
+
+	output, err := afero.ReadFile(fs, spec.Id+"/output")
+	s.Require().NoError(err)
+	s.Equal(s.content, output)
+
+	saved, err := storage.ReadDownloadSpec(spec.Id)
+	s.Require().NoError(err)
+	s.Equal("127.0.0.2", saved.Address)
+}
+
+func (s *PinningSuite) TestDownloader_ShouldNotFailOverToChangedResource() {
+	s.start()
+
+	downloader := s.downloader(s.network("127.0.0.3,127.0.0.2"), download.NewStorage(afero.NewMemMapFs(), codec.NewYAMLCodec()))
+
+	spec, err := downloader.InitDownload(s.url("http"), download.Options{Workers: 4})
+	s.Require().NoError(err)
+
+	// The pinned address goes down, and the other edge serves another version of the resource.
+	spec.Address = "127.0.0.3"
+	s.mu.Lock()
+	s.etags[1] = `"v2"`
+	s.mu.Unlock()
+
+	s.ErrorIs(downloader.Download(spec, context.TODO()), download.ResourceChangedErr)
+}
+
+func TestPinningSuite(t *testing.T) {
+	suite.Run(t, new(PinningSuite))
+}
@@ -0,0 +1,70 @@
+package download
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+)
+
+// defaultMaxRedirects is the maximum number of redirects followed by a request, unless configured otherwise.
+const defaultMaxRedirects = 10
+
+var (
+	TooManyRedirectsErr = errors.New("too many redirects")
+	DowngradeErr        = errors.New("refused redirect from https to http")
+	HTTPSOnlyErr        = errors.New("refused non-https URL")
+)
+
+// RedirectConfig configures how the http network follows redirects.
+type RedirectConfig struct {
+	// MaxRedirects is the maximum number of redirects followed by a request. If it is zero, up to 10 redirects are
+	// followed; if it is negative, redirects are not followed.
+	MaxRedirects int
+	// HTTPSOnly refuses the URLs, requested or redirected to, with another scheme than https.
+	HTTPSOnly bool
+}
+
+// checkURL returns HTTPSOnlyErr if only https URLs are allowed and the URL has another scheme.
+func (c RedirectConfig) checkURL(u *url.URL) error {
+	if c.HTTPSOnly && u.Scheme != "https" {
+		return fmt.Errorf("%w: %s", HTTPSOnlyErr, u.Redacted())
+	}
+
+	return nil
+}
+
+// checkRedirect is the CheckRedirect function of the clients. It limits the number of redirects, and refuses the
+// redirects from https to http, which would send the request, including its headers and cookies, in clear.
+func (c RedirectConfig) checkRedirect(request *http.Request, via []*http.Request) error {
+	maxRedirects := c.MaxRedirects
+	if maxRedirects == 0 {
+		maxRedirects = defaultMaxRedirects
+	}
+
+	if len(via) > maxRedirects {
+		return fmt.Errorf("%w: stopped after %d redirects", TooManyRedirectsErr, len(via)-1)
+	}
+
+	if via[len(via)-1].URL.Scheme == "https" && request.URL.Scheme != "https" {
+		return fmt.Errorf("%w: %s", DowngradeErr, request.URL.Redacted())
+	}
+
+	return c.checkURL(request.URL)
+}
+
+// redirectError reports whether an error was returned by checkRedirect or checkURL. Such errors are permanent.
+func redirectError(err error) bool {
+	return errors.Is(err, TooManyRedirectsErr) || errors.Is(err, DowngradeErr) || errors.Is(err, HTTPSOnlyErr)
+}
+
+// redirectChain returns the URLs a response was redirected through after the requested one, the last being the URL
+// of the response.
+func redirectChain(response *http.Response) []string {
+	var chain []string
+	for request := response.Request; request != nil && request.Response != nil; request = request.Response.Request {
+		chain = append([]string{request.URL.String()}, chain...)
+	}
+
+	return chain
+}
@@ -0,0 +1,133 @@
+package download_test
+
+import (
+	"bytes"
+	"context"
+	"github.com/MarcoTomasRodriguez/hget/internal/download"
+	"github.com/MarcoTomasRodriguez/hget/pkg/codec"
+	"github.com/MarcoTomasRodriguez/hget/pkg/logger"
+	"github.com/MarcoTomasRodriguez/hget/pkg/progressbar"
+	"github.com/spf13/afero"
+	"github.com/stretchr/testify/suite"
+	"math/rand"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+type RedirectSuite struct {
+	suite.Suite
+	content []byte
+	// server redirects /file.bin to /redirect.bin, then to /files/resolved.bin.
+	server *httptest.Server
+}
+
+func (s *RedirectSuite) SetupTest() {
+	s.content = make([]byte, 3187)
+	rand.Read(s.content)
+
+	mux := http.NewServeMux()
+	mux.Handle("/file.bin", http.RedirectHandler("/redirect.bin", http.StatusFound))
+	mux.Handle("/redirect.bin", http.RedirectHandler("/files/resolved.bin", http.StatusMovedPermanently))
+	mux.HandleFunc("/files/resolved.bin", func(w http.ResponseWriter, r *http.Request) {
+		http.ServeContent(w, r, "resolved.bin", time.Time{}, bytes.NewReader(s.content))
+	})
+
+	s.server = httptest.NewServer(mux)
+}
+
+func (s *RedirectSuite) TearDownTest() {
+	s.server.Close()
+}
+
+func (s *RedirectSuite) network(config download.RedirectConfig) download.Network {
+	network, err := download.NewHTTPNetwork(download.HTTPConfig{Redirect: config, TLS: download.TLSConfig{Insecure: true}})
+	s.Require().NoError(err)
+
+	return network
+}
+
+func (s *RedirectSuite) TestHTTPNetwork_FetchResource_ShouldRecordRedirects() {
+	resource, err := s.network(download.RedirectConfig{}).FetchResource(s.server.URL + "/file.bin")
+	s.Require().NoError(err)
+
+	s.Equal(s.server.URL+"/files/resolved.bin", resource.URL)
+	s.Equal(s.server.URL+"/file.bin", resource.OriginalURL)
+	s.Equal(s.server.URL+"/file.bin", resource.RequestedURL())
+	s.Equal([]string{s.server.URL + "/redirect.bin", s.server.URL + "/files/resolved.bin"}, resource.Redirects)
+	s.Equal("resolved.bin", resource.Filename)
+	s.Equal(int64(len(s.content)), resource.Size)
+	s.True(resource.AcceptRanges)
+}
+
+func (s *RedirectSuite) TestHTTPNetwork_FetchResource_ShouldNotRecordOriginalURLWithoutRedirect() {
+	resource, err := s.network(download.RedirectConfig{}).FetchResource(s.server.URL + "/files/resolved.bin")
+	s.Require().NoError(err)
+
+	s.Equal(s.server.URL+"/files/resolved.bin", resource.URL)
+	s.Empty(resource.OriginalURL)
+	s.Empty(resource.Redirects)
+}
+
+func (s *RedirectSuite) TestHTTPNetwork_ShouldLimitRedirects() {
+	_, err := s.network(download.RedirectConfig{MaxRedirects: 1}).FetchResource(s.server.URL + "/file.bin")
+	s.ErrorIs(err, download.TooManyRedirectsErr)
+	s.False(download.Retryable(err))
+
+	_, err = s.network(download.RedirectConfig{MaxRedirects: -1}).FetchResource(s.server.URL + "/file.bin")
+	s.ErrorIs(err, download.TooManyRedirectsErr)
+
+	_, err = s.network(download.RedirectConfig{MaxRedirects: 2}).FetchResource(s.server.URL + "/file.bin")
+	s.NoError(err)
+}
+
+func (s *RedirectSuite) TestHTTPNetwork_ShouldRefuseDowngrade() {
+	secure := httptest.NewTLSServer(http.RedirectHandler(s.server.URL+"/files/resolved.bin", http.StatusFound))
+	defer secure.Close()
+
+	_, err := s.network(download.RedirectConfig{}).FetchResource(secure.URL + "/file.bin")
+	s.ErrorIs(err, download.DowngradeErr)
+	s.False(download.Retryable(err))
+}
+
+func (s *RedirectSuite) TestHTTPNetwork_ShouldRefuseHTTPIfHTTPSOnly() {
+	network := s.network(download.RedirectConfig{HTTPSOnly: true})
+
+	_, err := network.FetchResource(s.server.URL + "/files/resolved.bin")
+	s.ErrorIs(err, download.HTTPSOnlyErr)
+
+	secure := httptest.NewTLSServer(s.server.Config.Handler)
+	defer secure.Close()
+
+	resource, err := network.FetchResource(secure.URL + "/file.bin")
+	s.NoError(err)
+	s.Equal(secure.URL+"/files/resolved.bin", resource.URL)
+}
+
+func (s *RedirectSuite) TestDownloader_ShouldDownloadFromResolvedURL() {
+	fs := afero.NewMemMapFs()
+	storage := download.NewStorage(fs, codec.NewYAMLCodec())
+	downloader := download.NewDownloader(s.network(download.RedirectConfig{}), storage, progressbar.NoopProgressBar{},
+		logger.NoopConsoleLogger{}, download.Config{Retry: download.RetryPolicy{MaxAttempts: 1}})
+
+	spec, err := downloader.InitDownload(s.server.URL+"/file.bin", download.Options{Workers: 4})
+	s.Require().NoError(err)
+	s.Equal(s.server.URL+"/file.bin", spec.URL)
+	s.Equal(s.server.URL+"/files/resolved.bin", spec.ResolvedURL)
+	s.Equal("resolved.bin", spec.Name)
+
+	s.Require().NoError(downloader.Download(spec, context.TODO()))
+
+	saved, err := storage.ReadDownloadSpec(spec.Id)
+	s.Require().NoError(err)
+	s.Equal(spec.ResolvedURL, saved.ResolvedURL)
+
+	output, err := afero.ReadFile(fs, spec.Id+"/output")
+	s.Require().NoError(err)
+	s.Equal(s.content, output)
+}
+
+func TestRedirectSuite(t *testing.T) {
+	suite.Run(t, new(RedirectSuite))
+}
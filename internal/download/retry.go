@@ -0,0 +1,108 @@
+package download
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math/rand"
+	"net/http"
+	"time"
+)
+
+// RetryPolicy configures how a failed segment is retried.
+type RetryPolicy struct {
+	// MaxAttempts is the maximum number of attempts per segment, including the first one.
+	MaxAttempts int
+	// BaseDelay is the delay before the first retry, which is doubled on every subsequent retry.
+	BaseDelay time.Duration
+	// MaxDelay caps the delay between two attempts, unless the server requested a longer one via Retry-After.
+	MaxDelay time.Duration
+	// MaxRetryAfter caps the delay requested by the server via Retry-After. The attempts requested to wait longer are
+	// not retried. If it is not positive, it is ten times MaxDelay.
+	MaxRetryAfter time.Duration
+}
+
+// DefaultRetryPolicy is the retry policy used when none is configured.
+var DefaultRetryPolicy = RetryPolicy{MaxAttempts: 5, BaseDelay: time.Second, MaxDelay: 30 * time.Second, MaxRetryAfter: 5 * time.Minute}
+
+// maxRetryAfter returns the longest delay requested via Retry-After which is honored.
+func (p RetryPolicy) maxRetryAfter() time.Duration {
+	if p.MaxRetryAfter > 0 {
+		return p.MaxRetryAfter
+	}
+
+	return 10 * p.MaxDelay
+}
+
+// checkRetryAfter returns the error, with the requested delay, if the server requested to retry after a longer delay
+// than the policy honors, so that the worker fails instead of waiting with no visible progress.
+func (p RetryPolicy) checkRetryAfter(err error) error {
+	var statusErr StatusError
+	if errors.As(err, &statusErr) && statusErr.RetryAfter > p.maxRetryAfter() {
+		return fmt.Errorf("%w: the server asked to retry after %s, more than %s", err, statusErr.RetryAfter, p.maxRetryAfter())
+	}
+
+	return nil
+}
+
+// Backoff returns the delay before the given retry (starting at 1), using exponential backoff with jitter. If the
+// error carries a Retry-After delay, it takes precedence, up to the maximum Retry-After delay of the policy.
+func (p RetryPolicy) Backoff(retry int, err error) time.Duration {
+	var statusErr StatusError
+	if errors.As(err, &statusErr) && statusErr.RetryAfter > 0 {
+		if statusErr.RetryAfter > p.maxRetryAfter() {
+			return p.maxRetryAfter()
+		}
+
+		return statusErr.RetryAfter
+	}
+
+	delay := p.BaseDelay
+	for i := 1; i < retry && delay < p.MaxDelay; i++ {
+		delay *= 2
+	}
+
+	if delay > p.MaxDelay {
+		delay = p.MaxDelay
+	}
+
+	// Randomize the delay between its half and its full value, to avoid retrying all segments at the same time.
+	if delay > 1 {
+		delay = delay/2 + time.Duration(rand.Int63n(int64(delay/2)+1))
+	}
+
+	return delay
+}
+
+// Retryable reports whether a segment that failed with the given error can be retried.
+func Retryable(err error) bool {
+	var networkErr NetworkError
+	var statusErr StatusError
+
+	switch {
+	case errors.Is(err, context.Canceled), errors.Is(err, context.DeadlineExceeded):
+		return false
+	case errors.Is(err, BufferCopyErr), errors.Is(err, StalledErr), errors.Is(err, TooSlowErr),
+		errors.Is(err, PinnedAddressErr), errors.As(err, &networkErr):
+		return true
+	case errors.As(err, &statusErr):
+		return statusErr.StatusCode == http.StatusRequestTimeout ||
+			statusErr.StatusCode == http.StatusTooManyRequests ||
+			statusErr.StatusCode >= http.StatusInternalServerError
+	default:
+		return false
+	}
+}
+
+// sleep waits for the given delay, returning early with the context's error if it is cancelled.
+func sleep(ctx context.Context, delay time.Duration) error {
+	timer := time.NewTimer(delay)
+	defer timer.Stop()
+
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-timer.C:
+		return nil
+	}
+}
@@ -0,0 +1,69 @@
+package download_test
+
+import (
+	"context"
+	"errors"
+	"github.com/MarcoTomasRodriguez/hget/internal/download"
+	"github.com/stretchr/testify/assert"
+	"net/http"
+	"testing"
+	"time"
+)
+
+func TestRetryPolicy_Backoff(t *testing.T) {
+	policy := download.RetryPolicy{MaxAttempts: 5, BaseDelay: time.Second, MaxDelay: 5 * time.Second}
+
+	testCases := []struct {
+		retry    int
+		min, max time.Duration
+	}{
+		{1, 500 * time.Millisecond, time.Second},
+		{2, time.Second, 2 * time.Second},
+		{3, 2 * time.Second, 4 * time.Second},
+		{10, 2500 * time.Millisecond, 5 * time.Second},
+	}
+
+	for _, tc := range testCases {
+		delay := policy.Backoff(tc.retry, download.NetworkError("connection reset"))
+		assert.GreaterOrEqual(t, delay, tc.min)
+		assert.LessOrEqual(t, delay, tc.max)
+	}
+}
+
+func TestRetryPolicy_Backoff_ShouldHonorRetryAfter(t *testing.T) {
+	policy := download.RetryPolicy{MaxAttempts: 5, BaseDelay: time.Second, MaxDelay: 5 * time.Second, MaxRetryAfter: 2 * time.Minute}
+	err := download.StatusError{StatusCode: http.StatusServiceUnavailable, RetryAfter: time.Minute}
+
+	assert.Equal(t, time.Minute, policy.Backoff(1, err))
+
+	// The delay is capped.
+	err.RetryAfter = 24 * time.Hour
+	assert.Equal(t, 2*time.Minute, policy.Backoff(1, err))
+
+	// By default, to ten times the maximum delay.
+	policy.MaxRetryAfter = 0
+	assert.Equal(t, 50*time.Second, policy.Backoff(1, err))
+}
+
+func TestRetryable(t *testing.T) {
+	testCases := []struct {
+		name      string
+		err       error
+		retryable bool
+	}{
+		{"network error", download.NetworkError("connection reset"), true},
+		{"interrupted body", download.BufferCopyErr, true},
+		{"server error", download.StatusError{StatusCode: http.StatusBadGateway}, true},
+		{"too many requests", download.StatusError{StatusCode: http.StatusTooManyRequests}, true},
+		{"not found", download.StatusError{StatusCode: http.StatusNotFound}, false},
+		{"resource changed", download.ResourceChangedErr, false},
+		{"cancelled", context.Canceled, false},
+		{"unknown", errors.New("unknown"), false},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			assert.Equal(t, tc.retryable, download.Retryable(tc.err))
+		})
+	}
+}
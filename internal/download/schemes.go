@@ -0,0 +1,122 @@
+package download
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/MarcoTomasRodriguez/hget/pkg/httputil"
+	"github.com/spf13/afero"
+	"io"
+	"strings"
+)
+
+var UnsupportedSchemeErr = errors.New("unsupported url scheme")
+
+// schemeNetwork forwards each call to the network registered for the scheme of the URL, so that the mirrors of a
+// download may use different protocols.
+type schemeNetwork struct {
+	networks      map[string]Network
+	defaultScheme string
+}
+
+// localSchemes are the schemes of the URLs without host, which are not resolved.
+var localSchemes = []string{"file", "data"}
+
+// resolve resolves a URL, with the default scheme if it has none. The URLs of the local schemes are kept as is.
+func (n schemeNetwork) resolve(rawURL string) (string, error) {
+	rawURL = strings.TrimSpace(rawURL)
+	for _, scheme := range localSchemes {
+		if len(rawURL) > len(scheme) && strings.EqualFold(rawURL[:len(scheme)+1], scheme+":") {
+			return rawURL, nil
+		}
+	}
+
+	return httputil.ResolveURL(rawURL, n.defaultScheme)
+}
+
+// network returns the network of the scheme of a resolved URL.
+func (n schemeNetwork) network(rawURL string) (Network, error) {
+	scheme, _, _ := strings.Cut(rawURL, ":")
+	scheme = strings.ToLower(scheme)
+
+	network, ok := n.networks[scheme]
+	if !ok {
+		return nil, fmt.Errorf("%w: %s", UnsupportedSchemeErr, scheme)
+	}
+
+	return network, nil
+}
+
+// FetchResource resolves the URL, with the default scheme if it has none, and fetches it with the network of its scheme.
+func (n schemeNetwork) FetchResource(url string) (Resource, error) {
+	url, err := n.resolve(url)
+	if err != nil {
+		return Resource{}, err
+	}
+
+	network, err := n.network(url)
+	if err != nil {
+		return Resource{}, err
+	}
+
+	return network.FetchResource(url)
+}
+
+// ReadResource resolves the URL, with the default scheme if it has none, and reads it with the network of its scheme.
+func (n schemeNetwork) ReadResource(url string) ([]byte, error) {
+	url, err := n.resolve(url)
+	if err != nil {
+		return nil, err
+	}
+
+	network, err := n.network(url)
+	if err != nil {
+		return nil, err
+	}
+
+	return network.ReadResource(url)
+}
+
+func (n schemeNetwork) DownloadResource(resource Resource, start int64, end int64, writer io.Writer, ctx context.Context) error {
+	network, err := n.network(resource.URL)
+	if err != nil {
+		return err
+	}
+
+	return network.DownloadResource(resource, start, end, writer, ctx)
+}
+
+// NewSchemeNetwork creates a network which selects the network of each URL by its scheme, e.g. "https". URLs without a
+// scheme get the default scheme, or httputil.DefaultScheme if empty.
+func NewSchemeNetwork(networks map[string]Network, defaultScheme string) Network {
+	return &schemeNetwork{networks: networks, defaultScheme: defaultScheme}
+}
+
+// NetworkConfig configures the networks of every scheme.
+type NetworkConfig struct {
+	// DefaultScheme is the scheme of the URLs without scheme, or httputil.DefaultScheme if empty.
+	DefaultScheme string
+	HTTP          HTTPConfig
+	FTP           FTPConfig
+	SFTP          SFTPConfig
+}
+
+// NewDefaultNetwork creates a network supporting every scheme implemented by hget.
+func NewDefaultNetwork(config NetworkConfig) (Network, error) {
+	httpNetwork, err := NewHTTPNetwork(config.HTTP)
+	if err != nil {
+		return nil, err
+	}
+
+	ftpNetwork := NewFTPNetwork(config.FTP)
+
+	return NewSchemeNetwork(map[string]Network{
+		"http":  httpNetwork,
+		"https": httpNetwork,
+		"ftp":   ftpNetwork,
+		"ftps":  ftpNetwork,
+		"sftp":  NewSFTPNetwork(config.SFTP),
+		"file":  NewFileNetwork(afero.NewOsFs()),
+		"data":  NewDataNetwork(),
+	}, config.DefaultScheme), nil
+}
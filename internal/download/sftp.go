@@ -0,0 +1,368 @@
+package download
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/MarcoTomasRodriguez/hget/pkg/fsutil"
+	"github.com/pkg/sftp"
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/agent"
+	"golang.org/x/crypto/ssh/knownhosts"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"os/user"
+	"path"
+	"path/filepath"
+	"strings"
+)
+
+// sftpPort is the default port of the sftp scheme.
+const sftpPort = "22"
+
+var SSHAuthErr = errors.New("ssh authentication failed")
+
+// SFTPConfig configures the authentication of the sftp network.
+type SFTPConfig struct {
+	// KeyFiles are the private keys used to authenticate. Keys protected by a passphrase are skipped, as they can be
+	// used through the agent.
+	KeyFiles []string
+	// AgentSocket is the socket of the ssh-agent used to authenticate, if any.
+	AgentSocket string
+	// KnownHostsFiles are the known_hosts files used to verify the host keys of the servers.
+	KnownHostsFiles []string
+	// Auth holds the username and password of the URL given without them, and the netrc file.
+	Auth AuthConfig
+}
+
+// DefaultSFTPConfig returns the configuration used by ssh: the default keys and known_hosts file of the user, and the
+// agent listening on SSH_AUTH_SOCK.
+func DefaultSFTPConfig() SFTPConfig {
+	config := SFTPConfig{AgentSocket: os.Getenv("SSH_AUTH_SOCK")}
+
+	homeDir, err := os.UserHomeDir()
+	if err != nil {
+		return config
+	}
+
+	for _, name := range []string{"id_ed25519", "id_ecdsa", "id_rsa"} {
+		if keyFile := filepath.Join(homeDir, ".ssh", name); fileExists(keyFile) {
+			config.KeyFiles = append(config.KeyFiles, keyFile)
+		}
+	}
+
+	config.KnownHostsFiles = []string{filepath.Join(homeDir, ".ssh", "known_hosts")}
+
+	return config
+}
+
+// fileExists reports whether a local file exists.
+func fileExists(name string) bool {
+	_, err := os.Stat(name)
+	return err == nil
+}
+
+// sftpNetwork implements the Network interface for the sftp scheme. Each call uses its own connection, so that
+// segments are read in parallel, each from its offset.
+type sftpNetwork struct {
+	config SFTPConfig
+}
+
+// parseSFTPURL parses an sftp URL, and returns the path of the file. Paths are absolute, except those starting with
+// "/~/", which are relative to the home directory of the user.
+func parseSFTPURL(rawURL string) (*url.URL, string, error) {
+	u, err := url.Parse(rawURL)
+	if err != nil || u.Scheme != "sftp" || u.Host == "" {
+		return nil, "", fmt.Errorf("%w: %s", UnsupportedSchemeErr, rawURL)
+	}
+
+	filePath := u.Path
+	if strings.HasPrefix(filePath, "/~/") {
+		filePath = strings.TrimPrefix(filePath, "/~/")
+	}
+
+	if filePath == "" || strings.HasSuffix(filePath, "/") {
+		return nil, "", InvalidFilenameErr
+	}
+
+	return u, filePath, nil
+}
+
+// clientConfig returns the ssh configuration of the URL's user, or the user of the configuration, authenticating with
+// their password, the agent and the key files, in this order, and verifying the host key against the known_hosts files.
+func (n sftpNetwork) clientConfig(u *url.URL) (*ssh.ClientConfig, func(), error) {
+	if len(n.config.KnownHostsFiles) == 0 {
+		return nil, nil, fmt.Errorf("%w: no known_hosts file", SSHAuthErr)
+	}
+
+	hostKeyCallback, err := knownhosts.New(n.config.KnownHostsFiles...)
+	if err != nil {
+		return nil, nil, fmt.Errorf("%w: %v", SSHAuthErr, err)
+	}
+
+	username := u.User.Username()
+	password, hasPassword := u.User.Password()
+	if credentials := n.config.Auth.credentialsOf(u); u.User == nil && credentials != nil && credentials.Username != "" {
+		username, password, hasPassword = credentials.Username, credentials.Password, credentials.Password != ""
+	}
+
+	if username == "" {
+		if current, err := user.Current(); err == nil {
+			username = current.Username
+		}
+	}
+
+	var auth []ssh.AuthMethod
+	closeAgent := func() {}
+
+	if hasPassword {
+		auth = append(auth, ssh.Password(password))
+	}
+
+	if n.config.AgentSocket != "" {
+		if conn, err := net.Dial("unix", n.config.AgentSocket); err == nil {
+			auth = append(auth, ssh.PublicKeysCallback(agent.NewClient(conn).Signers))
+			closeAgent = func() { _ = conn.Close() }
+		}
+	}
+
+	var signers []ssh.Signer
+	for _, keyFile := range n.config.KeyFiles {
+		key, err := os.ReadFile(keyFile)
+		if err != nil {
+			closeAgent()
+			return nil, nil, fmt.Errorf("%w: %v", SSHAuthErr, err)
+		}
+
+		signer, err := ssh.ParsePrivateKey(key)
+		var passphraseErr *ssh.PassphraseMissingError
+		if errors.As(err, &passphraseErr) {
+			continue
+		}
+
+		if err != nil {
+			closeAgent()
+			return nil, nil, fmt.Errorf("%w: %s: %v", SSHAuthErr, keyFile, err)
+		}
+
+		signers = append(signers, signer)
+	}
+
+	if len(signers) > 0 {
+		auth = append(auth, ssh.PublicKeys(signers...))
+	}
+
+	return &ssh.ClientConfig{User: username, Auth: auth, HostKeyCallback: hostKeyCallback}, closeAgent, nil
+}
+
+// connect opens an sftp session with the server of the URL. The connection is closed if the context is cancelled.
+func (n sftpNetwork) connect(u *url.URL, ctx context.Context) (*sftp.Client, func(), error) {
+	config, closeAgent, err := n.clientConfig(u)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	defer closeAgent()
+
+	port := sftpPort
+	if u.Port() != "" {
+		port = u.Port()
+	}
+
+	address := net.JoinHostPort(u.Hostname(), port)
+	conn, err := new(net.Dialer).DialContext(ctx, "tcp", address)
+	if err != nil {
+		return nil, nil, NetworkError(err.Error())
+	}
+
+	// Close the connection if the context is cancelled, which interrupts the handshake or the transfer.
+	done := make(chan struct{})
+	closeConn := func() {
+		close(done)
+		_ = conn.Close()
+	}
+
+	go func() {
+		select {
+		case <-ctx.Done():
+			_ = conn.Close()
+		case <-done:
+		}
+	}()
+
+	sshConn, channels, requests, err := ssh.NewClientConn(conn, address, config)
+	if err != nil {
+		closeConn()
+
+		// Authentication and host key errors are permanent, unlike connection errors.
+		var keyErr *knownhosts.KeyError
+		if errors.As(err, &keyErr) || strings.Contains(err.Error(), "unable to authenticate") {
+			return nil, nil, fmt.Errorf("%w: %v", SSHAuthErr, err)
+		}
+
+		return nil, nil, NetworkError(err.Error())
+	}
+
+	client, err := sftp.NewClient(ssh.NewClient(sshConn, channels, requests))
+	if err != nil {
+		closeConn()
+		return nil, nil, NetworkError(err.Error())
+	}
+
+	return client, func() {
+		_ = client.Close()
+		closeConn()
+	}, nil
+}
+
+// sftpError converts an sftp error: status errors, such as a missing file, are returned as is, whereas connection
+// errors are network errors, which can be retried.
+func sftpError(err error) error {
+	var statusErr *sftp.StatusError
+	if errors.As(err, &statusErr) || errors.Is(err, os.ErrNotExist) || errors.Is(err, os.ErrPermission) {
+		return fmt.Errorf("sftp: %w", err)
+	}
+
+	return NetworkError(err.Error())
+}
+
+// FetchResource gets the size and modification time of a remote file.
+func (n sftpNetwork) FetchResource(URL string) (Resource, error) {
+	u, filePath, err := parseSFTPURL(URL)
+	if err != nil {
+		return Resource{}, err
+	}
+
+	client, closeClient, err := n.connect(u, context.Background())
+	if err != nil {
+		return Resource{}, err
+	}
+
+	defer closeClient()
+
+	info, err := client.Stat(filePath)
+	if err != nil {
+		return Resource{}, sftpError(err)
+	}
+
+	// Extract the download filename from the URL, and validate it.
+	filename := path.Base(filePath)
+	if !info.Mode().IsRegular() || !fsutil.ValidateFilename(filename) {
+		return Resource{}, InvalidFilenameErr
+	}
+
+	return Resource{
+		URL:          u.String(),
+		Filename:     filename,
+		Size:         info.Size(),
+		AcceptRanges: true,
+		LastModified: info.ModTime().UTC().Format(http.TimeFormat),
+	}, nil
+}
+
+// ReadResource reads a small remote file in memory.
+func (n sftpNetwork) ReadResource(URL string) ([]byte, error) {
+	u, filePath, err := parseSFTPURL(URL)
+	if err != nil {
+		return nil, err
+	}
+
+	client, closeClient, err := n.connect(u, context.Background())
+	if err != nil {
+		return nil, err
+	}
+
+	defer closeClient()
+
+	file, err := client.Open(filePath)
+	if err != nil {
+		return nil, sftpError(err)
+	}
+
+	defer file.Close()
+
+	// Read one byte more than the limit to detect larger resources.
+	content, err := io.ReadAll(io.LimitReader(file, maxReadResourceSize+1))
+	if err != nil {
+		return nil, BufferCopyErr
+	}
+
+	if len(content) > maxReadResourceSize {
+		return nil, ResourceTooLargeErr
+	}
+
+	return content, nil
+}
+
+// DownloadResource reads a range of a remote file from its offset. It fails if the file changed since it was fetched.
+func (n sftpNetwork) DownloadResource(resource Resource, start int64, end int64, writer io.Writer, ctx context.Context) error {
+	// Check if the segment has an overflow.
+	if start < 0 || start > end {
+		return SegmentOverflowErr
+	}
+
+	// Check if download already finished. The end point is inclusive, except for the last segment, whose end point is
+	// the size of the resource.
+	if start == end && end >= resource.Size {
+		return nil
+	}
+
+	u, filePath, err := parseSFTPURL(resource.URL)
+	if err != nil {
+		return err
+	}
+
+	client, closeClient, err := n.connect(u, ctx)
+	if err != nil {
+		return err
+	}
+
+	defer closeClient()
+
+	file, err := client.Open(filePath)
+	if err != nil {
+		return sftpError(err)
+	}
+
+	defer file.Close()
+
+	// Check that the file did not change, as there is no conditional read.
+	info, err := file.Stat()
+	if err != nil {
+		return sftpError(err)
+	}
+
+	if (resource.Size > 0 && info.Size() != resource.Size) ||
+		(resource.LastModified != "" && info.ModTime().UTC().Format(http.TimeFormat) != resource.LastModified) {
+		return ResourceChangedErr
+	}
+
+	// The end point is inclusive, except for the last segment, whose end point is the size of the resource.
+	length := end - start + 1
+	if resource.Size > 0 && end >= resource.Size {
+		length = resource.Size - start
+	}
+
+	written, err := io.Copy(writer, io.NewSectionReader(file, start, length))
+	if err != nil {
+		if ctx.Err() != nil {
+			return NetworkError(ctx.Err().Error())
+		}
+
+		return BufferCopyErr
+	}
+
+	if written < length {
+		return NetworkError(io.ErrUnexpectedEOF.Error())
+	}
+
+	return nil
+}
+
+// NewSFTPNetwork creates a network for the sftp scheme.
+func NewSFTPNetwork(config SFTPConfig) Network {
+	return &sftpNetwork{config: config}
+}
@@ -0,0 +1,234 @@
+package download_test
+
+import (
+	"bytes"
+	"context"
+	"crypto/ed25519"
+	"crypto/rand"
+	"encoding/pem"
+	"fmt"
+	"github.com/MarcoTomasRodriguez/hget/internal/download"
+	"github.com/pkg/sftp"
+	"github.com/stretchr/testify/suite"
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/agent"
+	"golang.org/x/crypto/ssh/knownhosts"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// sshServer is an in-process SSH server with the sftp subsystem, serving the local filesystem to a single user
+// authenticated by public key.
+type sshServer struct {
+	listener net.Listener
+	hostKey  ssh.Signer
+}
+
+func newSSHServer(userKey ssh.PublicKey) *sshServer {
+	_, hostPrivateKey, _ := ed25519.GenerateKey(rand.Reader)
+	hostKey, _ := ssh.NewSignerFromKey(hostPrivateKey)
+
+	config := &ssh.ServerConfig{
+		PublicKeyCallback: func(conn ssh.ConnMetadata, key ssh.PublicKey) (*ssh.Permissions, error) {
+			if conn.User() == "hget" && bytes.Equal(key.Marshal(), userKey.Marshal()) {
+				return nil, nil
+			}
+
+			return nil, fmt.Errorf("unknown public key for %s", conn.User())
+		},
+	}
+	config.AddHostKey(hostKey)
+
+	listener, _ := net.Listen("tcp", "127.0.0.1:0")
+	server := &sshServer{listener: listener, hostKey: hostKey}
+
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+
+			go server.serve(conn, config)
+		}
+	}()
+
+	return server
+}
+
+func (s *sshServer) serve(conn net.Conn, config *ssh.ServerConfig) {
+	_, channels, requests, err := ssh.NewServerConn(conn, config)
+	if err != nil {
+		return
+	}
+
+	go ssh.DiscardRequests(requests)
+
+	for newChannel := range channels {
+		if newChannel.ChannelType() != "session" {
+			_ = newChannel.Reject(ssh.UnknownChannelType, "unknown channel type")
+			continue
+		}
+
+		channel, channelRequests, err := newChannel.Accept()
+		if err != nil {
+			return
+		}
+
+		go func() {
+			for request := range channelRequests {
+				ok := request.Type == "subsystem" && string(request.Payload[4:]) == "sftp"
+				_ = request.Reply(ok, nil)
+
+				if ok {
+					server, _ := sftp.NewServer(channel)
+					_ = server.Serve()
+					_ = channel.Close()
+				}
+			}
+		}()
+	}
+}
+
+func (s *sshServer) URL(path string) string {
+	return fmt.Sprintf("sftp://hget@%s%s", s.listener.Addr(), path)
+}
+
+func (s *sshServer) Close() {
+	_ = s.listener.Close()
+}
+
+type SFTPNetworkSuite struct {
+	suite.Suite
+	dir     string
+	content []byte
+	userKey ed25519.PrivateKey
+	server  *sshServer
+	config  download.SFTPConfig
+}
+
+func (s *SFTPNetworkSuite) SetupTest() {
+	s.dir = s.T().TempDir()
+
+	s.content = make([]byte, 2583)
+	_, _ = rand.Read(s.content)
+	s.Require().NoError(os.WriteFile(filepath.Join(s.dir, "file.bin"), s.content, 0644))
+
+	// Generate the user key, and save it in OpenSSH format.
+	_, s.userKey, _ = ed25519.GenerateKey(rand.Reader)
+	userSigner, _ := ssh.NewSignerFromKey(s.userKey)
+	block, err := ssh.MarshalPrivateKey(s.userKey, "")
+	s.Require().NoError(err)
+	keyFile := filepath.Join(s.dir, "id_ed25519")
+	s.Require().NoError(os.WriteFile(keyFile, pem.EncodeToMemory(block), 0600))
+
+	s.server = newSSHServer(userSigner.PublicKey())
+
+	// Trust the host key of the server.
+	knownHostsFile := filepath.Join(s.dir, "known_hosts")
+	line := knownhosts.Line([]string{knownhosts.Normalize(s.server.listener.Addr().String())}, s.server.hostKey.PublicKey())
+	s.Require().NoError(os.WriteFile(knownHostsFile, []byte(line+"\n"), 0644))
+
+	s.config = download.SFTPConfig{KeyFiles: []string{keyFile}, KnownHostsFiles: []string{knownHostsFile}}
+}
+
+func (s *SFTPNetworkSuite) TearDownTest() {
+	s.server.Close()
+}
+
+func (s *SFTPNetworkSuite) TestSFTPNetwork_FetchResource() {
+	resource, err := download.NewSFTPNetwork(s.config).FetchResource(s.server.URL(filepath.Join(s.dir, "file.bin")))
+	s.NoError(err)
+	s.Equal("file.bin", resource.Filename)
+	s.Equal(int64(len(s.content)), resource.Size)
+	s.True(resource.AcceptRanges)
+	s.NotEmpty(resource.LastModified)
+}
+
+func (s *SFTPNetworkSuite) TestSFTPNetwork_FetchResource_ShouldFailIfNotFound() {
+	_, err := download.NewSFTPNetwork(s.config).FetchResource(s.server.URL(filepath.Join(s.dir, "missing.bin")))
+	s.ErrorIs(err, os.ErrNotExist)
+	s.False(download.Retryable(err))
+}
+
+func (s *SFTPNetworkSuite) TestSFTPNetwork_DownloadResource() {
+	network := download.NewSFTPNetwork(s.config)
+	resource, _ := network.FetchResource(s.server.URL(filepath.Join(s.dir, "file.bin")))
+
+	buffer := new(bytes.Buffer)
+	err := network.DownloadResource(resource, 1000, 1999, buffer, context.TODO())
+	s.NoError(err)
+	s.Equal(s.content[1000:2000], buffer.Bytes())
+
+	// The last segment ends at the size of the resource.
+	buffer.Reset()
+	err = network.DownloadResource(resource, 2000, resource.Size, buffer, context.TODO())
+	s.NoError(err)
+	s.Equal(s.content[2000:], buffer.Bytes())
+}
+
+func (s *SFTPNetworkSuite) TestSFTPNetwork_DownloadResource_ShouldFailIfResourceChanged() {
+	network := download.NewSFTPNetwork(s.config)
+	resource, _ := network.FetchResource(s.server.URL(filepath.Join(s.dir, "file.bin")))
+	resource.LastModified = "Thu, 01 Jan 2015 00:00:00 GMT"
+
+	err := network.DownloadResource(resource, 0, 999, new(bytes.Buffer), context.TODO())
+	s.ErrorIs(err, download.ResourceChangedErr)
+}
+
+func (s *SFTPNetworkSuite) TestSFTPNetwork_ReadResource() {
+	content, err := download.NewSFTPNetwork(s.config).ReadResource(s.server.URL(filepath.Join(s.dir, "file.bin")))
+	s.NoError(err)
+	s.Equal(s.content, content)
+}
+
+func (s *SFTPNetworkSuite) TestSFTPNetwork_ShouldAuthenticateWithAgent() {
+	keyring := agent.NewKeyring()
+	s.Require().NoError(keyring.Add(agent.AddedKey{PrivateKey: s.userKey}))
+
+	socket := filepath.Join(s.dir, "agent.sock")
+	listener, err := net.Listen("unix", socket)
+	s.Require().NoError(err)
+	defer listener.Close()
+
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+
+			go func() { _ = agent.ServeAgent(keyring, conn) }()
+		}
+	}()
+
+	config := s.config
+	config.KeyFiles, config.AgentSocket = nil, socket
+
+	_, err = download.NewSFTPNetwork(config).FetchResource(s.server.URL(filepath.Join(s.dir, "file.bin")))
+	s.NoError(err)
+}
+
+func (s *SFTPNetworkSuite) TestSFTPNetwork_ShouldFailIfUnknownHost() {
+	config := s.config
+	config.KnownHostsFiles = []string{filepath.Join(s.dir, "empty_known_hosts")}
+	s.Require().NoError(os.WriteFile(config.KnownHostsFiles[0], nil, 0644))
+
+	_, err := download.NewSFTPNetwork(config).FetchResource(s.server.URL(filepath.Join(s.dir, "file.bin")))
+	s.ErrorIs(err, download.SSHAuthErr)
+	s.False(download.Retryable(err))
+}
+
+func (s *SFTPNetworkSuite) TestSFTPNetwork_ShouldFailIfUnauthorized() {
+	config := s.config
+	config.KeyFiles = nil
+
+	_, err := download.NewSFTPNetwork(config).FetchResource(s.server.URL(filepath.Join(s.dir, "file.bin")))
+	s.ErrorIs(err, download.SSHAuthErr)
+}
+
+func TestSFTPNetworkSuite(t *testing.T) {
+	suite.Run(t, new(SFTPNetworkSuite))
+}
@@ -8,6 +8,7 @@ import (
 	"github.com/spf13/afero"
 	"io"
 	ioFs "io/fs"
+	"net/http"
 	"os"
 	"path/filepath"
 )
@@ -23,6 +24,7 @@ type Storage interface {
 	OpenDownloadOutput(id string) (io.ReadWriteCloser, error)
 	DeleteDownload(id string) error
 	OpenSegment(id string) (io.ReadWriteCloser, error)
+	AppendSegment(id string) (io.WriteCloser, error)
 	GetSegmentSize(id string) (int64, error)
 	DeleteSegment(id string) error
 }
@@ -33,6 +35,11 @@ func (e FilesystemError) Error() string {
 	return fmt.Sprintf("storage error: %s", string(e))
 }
 
+// downloadSecrets stores the headers carrying credentials of a download, apart from its specification.
+type downloadSecrets struct {
+	Header http.Header `yaml:"header,omitempty"`
+}
+
 type storage struct {
 	afs   afero.Afero
 	codec codec.Codec
@@ -74,6 +81,23 @@ func (f storage) ReadDownloadSpec(id string) (Download, error) {
 		return Download{}, BrokenDownloadErr
 	}
 
+	// Read the secrets saved apart from the specification, if any.
+	in, err = f.afs.ReadFile(filepath.Join(id, "secrets."+f.codec.Extension()))
+	if err == nil {
+		secrets := downloadSecrets{}
+		if err := f.codec.Unmarshal(in, &secrets); err != nil {
+			return Download{}, BrokenDownloadErr
+		}
+
+		for name, values := range secrets.Header {
+			if download.Header == nil {
+				download.Header = http.Header{}
+			}
+
+			download.Header[name] = values
+		}
+	}
+
 	return download, nil
 }
 
@@ -81,6 +105,13 @@ func (f storage) ReadDownloadSpec(id string) (Download, error) {
 func (f storage) WriteDownloadSpec(download Download) error {
 	_ = f.afs.MkdirAll(download.Id, 0755)
 
+	// Save the headers carrying credentials apart from the specification, only readable by the user.
+	secrets := downloadSecrets{}
+	download.Header, secrets.Header = splitSecretHeaders(download.Header)
+	if err := f.writeSecrets(download.Id, secrets); err != nil {
+		return err
+	}
+
 	// Marshall download.
 	out, err := f.codec.Marshal(download)
 	if err != nil {
@@ -91,9 +122,29 @@ func (f storage) WriteDownloadSpec(download Download) error {
 	return f.afs.WriteFile(filepath.Join(download.Id, "download."+f.codec.Extension()), out, 0644)
 }
 
-// OpenDownloadOutput opens the download output file by id and returns the file for read and write.
+// writeSecrets saves the secrets of a download in a file only readable by the user, or deletes it if there are none.
+func (f storage) writeSecrets(id string, secrets downloadSecrets) error {
+	secretsFile := filepath.Join(id, "secrets."+f.codec.Extension())
+	if len(secrets.Header) == 0 {
+		if err := f.afs.Remove(secretsFile); err != nil && !errors.Is(err, ioFs.ErrNotExist) {
+			return err
+		}
+
+		return nil
+	}
+
+	out, err := f.codec.Marshal(secrets)
+	if err != nil {
+		return err
+	}
+
+	return f.afs.WriteFile(secretsFile, out, 0600)
+}
+
+// OpenDownloadOutput opens the download output file by id and returns the file for read and write. The file is
+// truncated, as the output is merged again from the segments, e.g. after a failed verification.
 func (f storage) OpenDownloadOutput(id string) (io.ReadWriteCloser, error) {
-	return f.afs.OpenFile(filepath.Join(id, "output"), os.O_CREATE|os.O_RDWR|os.O_APPEND, 0644)
+	return f.afs.OpenFile(filepath.Join(id, "output"), os.O_CREATE|os.O_RDWR|os.O_TRUNC, 0644)
 }
 
 // DeleteDownload deletes the whole download folder from the filesystem.
@@ -106,6 +157,12 @@ func (f storage) OpenSegment(id string) (io.ReadWriteCloser, error) {
 	return f.afs.OpenFile(id, os.O_CREATE|os.O_RDWR, 0644)
 }
 
+// AppendSegment opens a segment by id and returns the file for append, so that writes resume after the bytes already
+// downloaded.
+func (f storage) AppendSegment(id string) (io.WriteCloser, error) {
+	return f.afs.OpenFile(id, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+}
+
 // GetSegmentSize gets the size of a segment by id.
 func (f storage) GetSegmentSize(id string) (int64, error) {
 	fileInfo, err := f.afs.Stat(id)
@@ -9,6 +9,7 @@ import (
 	"github.com/spf13/afero"
 	"github.com/stretchr/testify/suite"
 	"gopkg.in/yaml.v3"
+	"net/http"
 	"os"
 	"testing"
 )
@@ -38,6 +39,46 @@ func (s *StorageSuite) TestStorage_ReadDownloadSpec() {
 	s.Equal(golangSample, spec)
 }
 
+func (s *StorageSuite) TestStorage_WriteDownloadSpec_ShouldSaveSecretsApart() {
+	spec := javaSample
+	spec.Header = http.Header{
+		"User-Agent":    {"hget"},
+		"Authorization": {"Bearer secret"},
+		"X-Api-Key":     {"secret"},
+	}
+	spec.Credentials = &download.Credentials{Username: "alice", Password: "hunter2"}
+	spec.CredentialSource = &download.CredentialSource{From: "user", Username: "alice", Secret: true}
+
+	s.NoError(s.storage.WriteDownloadSpec(spec))
+
+	// The specification does not contain the secrets.
+	content, _ := s.afs.ReadFile(spec.Id + "/download.yml")
+	s.Contains(string(content), "hget")
+	s.NotContains(string(content), "Bearer")
+	s.NotContains(string(content), "hunter2")
+
+	info, err := s.afs.Stat(spec.Id + "/secrets.yml")
+	s.NoError(err)
+	s.Equal(os.FileMode(0600), info.Mode().Perm())
+
+	// Reading the specification restores the secrets.
+	read, err := s.storage.ReadDownloadSpec(spec.Id)
+	s.NoError(err)
+	s.Equal(spec.Header, read.Header)
+
+	// The credentials are not saved, only where they were given.
+	secrets, _ := s.afs.ReadFile(spec.Id + "/secrets.yml")
+	s.NotContains(string(secrets), "hunter2")
+	s.Nil(read.Credentials)
+	s.Equal(spec.CredentialSource, read.CredentialSource)
+
+	// The secrets file is deleted once there are no more secrets.
+	spec.Header = nil
+	s.NoError(s.storage.WriteDownloadSpec(spec))
+	exists, _ := s.afs.Exists(spec.Id + "/secrets.yml")
+	s.False(exists)
+}
+
 func (s *StorageSuite) TestStorage_ListDownloads() {
 	golangSampleYml, _ := yaml.Marshal(golangSample)
 	javaSampleYml, _ := yaml.Marshal(javaSample)
@@ -66,6 +107,20 @@ func (s *StorageSuite) TestStorage_DeleteDownload() {
 	s.False(exists)
 }
 
+func (s *StorageSuite) TestStorage_AppendSegment() {
+	_ = s.afs.WriteFile(javaSample.Segments[0].Id, []byte("hello"), os.ModePerm)
+
+	writer, err := s.storage.AppendSegment(javaSample.Segments[0].Id)
+	s.NoError(err)
+
+	_, err = writer.Write([]byte(" world"))
+	s.NoError(err)
+	s.NoError(writer.Close())
+
+	content, _ := s.afs.ReadFile(javaSample.Segments[0].Id)
+	s.Equal("hello world", string(content))
+}
+
 func TestStorageSuite(t *testing.T) {
 	suite.Run(t, new(StorageSuite))
 }
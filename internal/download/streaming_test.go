@@ -0,0 +1,155 @@
+package download_test
+
+import (
+	"bytes"
+	"context"
+	"github.com/MarcoTomasRodriguez/hget/internal/download"
+	"github.com/MarcoTomasRodriguez/hget/pkg/codec"
+	"github.com/MarcoTomasRodriguez/hget/pkg/logger"
+	"github.com/MarcoTomasRodriguez/hget/pkg/progressbar"
+	"github.com/spf13/afero"
+	"github.com/stretchr/testify/suite"
+	"math/rand"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+)
+
+type StreamingSuite struct {
+	suite.Suite
+	content []byte
+	etag    string
+	// server streams the content in chunks, without length and range support, as generated exports.
+	server *httptest.Server
+	mu     sync.Mutex
+	// ranges are the Range headers of the GET requests.
+	ranges     []string
+	fs         afero.Fs
+	storage    download.Storage
+	downloader download.Downloader
+}
+
+func (s *StreamingSuite) SetupTest() {
+	s.content = make([]byte, 10_000)
+	rand.Read(s.content)
+	s.etag = `"v1"`
+	s.ranges = nil
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/export.csv", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("ETag", s.etag)
+		if r.Method == "HEAD" {
+			return
+		}
+
+		s.mu.Lock()
+		s.ranges = append(s.ranges, r.Header.Get("Range"))
+		s.mu.Unlock()
+
+		for start := 0; start < len(s.content); start += 1000 {
+			end := start + 1000
+			if end > len(s.content) {
+				end = len(s.content)
+			}
+
+			_, _ = w.Write(s.content[start:end])
+			w.(http.Flusher).Flush()
+		}
+	})
+
+	// The ranged file supports ranges, but its size is only known once transferred.
+	mux.HandleFunc("/ranged.csv", func(w http.ResponseWriter, r *http.Request) {
+		http.ServeContent(w, r, "ranged.csv", time.Time{}, bytes.NewReader(s.content))
+	})
+
+	s.server = httptest.NewServer(mux)
+
+	network, err := download.NewHTTPNetwork(download.HTTPConfig{})
+	s.Require().NoError(err)
+
+	s.fs = afero.NewMemMapFs()
+	s.storage = download.NewStorage(s.fs, codec.NewYAMLCodec())
+	s.downloader = download.NewDownloader(network, s.storage, progressbar.NoopProgressBar{}, logger.NoopConsoleLogger{},
+		download.Config{Retry: download.RetryPolicy{MaxAttempts: 1}})
+}
+
+func (s *StreamingSuite) TearDownTest() {
+	s.server.Close()
+}
+
+func (s *StreamingSuite) TestHTTPNetwork_FetchResource_ShouldReportUnknownSize() {
+	network, _ := download.NewHTTPNetwork(download.HTTPConfig{})
+
+	resource, err := network.FetchResource(s.server.URL + "/export.csv")
+	s.Require().NoError(err)
+	s.Equal(int64(-1), resource.Size)
+	s.False(resource.AcceptRanges)
+}
+
+func (s *StreamingSuite) TestDownloader_ShouldStreamUnknownSize() {
+	spec, err := s.downloader.InitDownload(s.server.URL+"/export.csv", download.Options{Workers: 4})
+	s.Require().NoError(err)
+	s.True(spec.Streaming())
+	s.Equal([]download.Segment{{Id: spec.Id + "/segment.00", Start: 0, End: -1}}, spec.Segments)
+
+	s.Require().NoError(s.downloader.Download(spec, context.TODO()))
+
+	output, err := afero.ReadFile(s.fs, spec.Id+"/output")
+	s.Require().NoError(err)
+	s.Equal(s.content, output)
+
+	// The size is recorded once known.
+	saved, err := s.storage.ReadDownloadSpec(spec.Id)
+	s.Require().NoError(err)
+	s.Equal(int64(len(s.content)), saved.Size)
+	s.Equal(int64(len(s.content)), saved.Segments[0].End)
+}
+
+func (s *StreamingSuite) TestDownloader_ShouldResumeBySkippingWrittenBytes() {
+	spec, err := s.downloader.InitDownload(s.server.URL+"/export.csv", download.Options{Workers: 4})
+	s.Require().NoError(err)
+
+	// Simulate an interrupted transfer.
+	segment, err := s.storage.AppendSegment(spec.Segments[0].Id)
+	s.Require().NoError(err)
+	_, _ = segment.Write(s.content[:4321])
+	_ = segment.Close()
+
+	s.ranges = nil
+	s.Require().NoError(s.downloader.Download(spec, context.TODO()))
+	s.Equal([]string{"bytes=4321-"}, s.ranges)
+
+	output, err := afero.ReadFile(s.fs, spec.Id+"/output")
+	s.Require().NoError(err)
+	s.Equal(s.content, output)
+}
+
+func (s *StreamingSuite) TestDownloader_ShouldNotResumeChangedResource() {
+	spec, err := s.downloader.InitDownload(s.server.URL+"/export.csv", download.Options{Workers: 4})
+	s.Require().NoError(err)
+
+	segment, err := s.storage.AppendSegment(spec.Segments[0].Id)
+	s.Require().NoError(err)
+	_, _ = segment.Write(s.content[:4321])
+	_ = segment.Close()
+
+	s.etag = `"v2"`
+	s.ErrorIs(s.downloader.Download(spec, context.TODO()), download.ResourceChangedErr)
+}
+
+func (s *StreamingSuite) TestHTTPNetwork_DownloadResource_ShouldStreamRangeUntilEnd() {
+	network, _ := download.NewHTTPNetwork(download.HTTPConfig{})
+	resource := download.Resource{URL: s.server.URL + "/ranged.csv", Size: -1}
+
+	buffer := new(bytes.Buffer)
+	s.NoError(network.DownloadResource(resource, 1234, -1, buffer, context.TODO()))
+	s.Equal(s.content[1234:], buffer.Bytes())
+
+	s.ErrorIs(network.DownloadResource(resource, -1, -1, buffer, context.TODO()), download.SegmentOverflowErr)
+}
+
+func TestStreamingSuite(t *testing.T) {
+	suite.Run(t, new(StreamingSuite))
+}
@@ -0,0 +1,254 @@
+package download
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	// defaultConnectTimeout is the timeout of the connections, when none is configured.
+	defaultConnectTimeout = 30 * time.Second
+	// defaultSpeedTime is the duration of the speed limit, when only the speed limit is configured, as in curl.
+	defaultSpeedTime = 30 * time.Second
+	// maxWatchInterval is the maximum interval between two checks of a transfer.
+	maxWatchInterval = time.Second
+)
+
+var (
+	StalledErr = errors.New("transfer stalled")
+	TooSlowErr = errors.New("transfer too slow")
+)
+
+// TimeoutConfig configures the timeouts of the http network. Zero values keep the default behavior.
+type TimeoutConfig struct {
+	// ConnectTimeout limits the time to connect to a server, including the TLS handshake. If zero, connections time
+	// out after 30 seconds.
+	ConnectTimeout time.Duration `mapstructure:"connect_timeout"`
+	// ReadTimeout cancels the requests receiving no data, including the response headers, for this duration.
+	ReadTimeout time.Duration `mapstructure:"read_timeout"`
+	// SpeedLimit and SpeedTime cancel the transfers slower than SpeedLimit bytes per second during SpeedTime, as in
+	// curl. If only one of them is set, the other defaults to 1 byte per second or 30 seconds.
+	SpeedLimit int64         `mapstructure:"speed_limit"`
+	SpeedTime  time.Duration `mapstructure:"speed_time"`
+}
+
+// merge returns the configuration of a host, whose non-zero timeouts override those of this configuration.
+func (c TimeoutConfig) merge(host TimeoutConfig) TimeoutConfig {
+	merged := c
+
+	if host.ConnectTimeout > 0 {
+		merged.ConnectTimeout = host.ConnectTimeout
+	}
+
+	if host.ReadTimeout > 0 {
+		merged.ReadTimeout = host.ReadTimeout
+	}
+
+	if host.SpeedLimit > 0 {
+		merged.SpeedLimit = host.SpeedLimit
+	}
+
+	if host.SpeedTime > 0 {
+		merged.SpeedTime = host.SpeedTime
+	}
+
+	return merged
+}
+
+// connectTimeout returns the timeout of the connections.
+func (c TimeoutConfig) connectTimeout() time.Duration {
+	if c.ConnectTimeout > 0 {
+		return c.ConnectTimeout
+	}
+
+	return defaultConnectTimeout
+}
+
+// speedLimit returns the minimum speed of the transfers, and the duration during which it is measured, if any.
+func (c TimeoutConfig) speedLimit() (int64, time.Duration) {
+	switch {
+	case c.SpeedLimit <= 0 && c.SpeedTime <= 0:
+		return 0, 0
+	case c.SpeedLimit <= 0:
+		return 1, c.SpeedTime
+	case c.SpeedTime <= 0:
+		return c.SpeedLimit, defaultSpeedTime
+	default:
+		return c.SpeedLimit, c.SpeedTime
+	}
+}
+
+// watch derives a context from the given one, which the returned watchdog cancels once the transfer stalls.
+func (c TimeoutConfig) watch(ctx context.Context) (context.Context, *watchdog) {
+	ctx, cancel := context.WithCancel(ctx)
+	w := &watchdog{config: c, cancel: cancel, stopped: make(chan struct{}), exited: make(chan struct{}), start: time.Now()}
+
+	limit, speedTime := c.speedLimit()
+	if c.ReadTimeout <= 0 && limit <= 0 {
+		close(w.exited)
+		return ctx, w
+	}
+
+	go w.run(limit, speedTime)
+
+	return ctx, w
+}
+
+// watchdog cancels the request of a transfer receiving no data for the read timeout, or receiving less than the speed
+// limit during the speed time, so that it is retried instead of hanging. Only the time the transfer waits for the
+// server counts: the time the reader of the response body spends between two reads, e.g. blocked by a rate limiter,
+// does not.
+type watchdog struct {
+	config   TimeoutConfig
+	cancel   context.CancelFunc
+	received atomic.Int64
+	stopped  chan struct{}
+	exited   chan struct{}
+	stopOnce sync.Once
+	// err is the reason of the cancellation, only read once the watchdog exited.
+	err error
+	// mu guards the time the transfer was paused, i.e. not waiting for the server.
+	mu          sync.Mutex
+	start       time.Time
+	paused      time.Duration
+	pausedSince time.Time
+}
+
+// speedSample is the number of bytes received at some point of a transfer, given as the time it waited for the server.
+type speedSample struct {
+	at       time.Duration
+	received int64
+}
+
+// pause stops the clock of the transfer once a read of the response body returned.
+func (w *watchdog) pause() {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	w.pausedSince = time.Now()
+}
+
+// resume restarts the clock of the transfer once the response body is read again.
+func (w *watchdog) resume() {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	if !w.pausedSince.IsZero() {
+		w.paused += time.Since(w.pausedSince)
+		w.pausedSince = time.Time{}
+	}
+}
+
+// clock returns the time the transfer waited for the server until now, excluding the pauses.
+func (w *watchdog) clock(now time.Time) time.Duration {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	paused := w.paused
+	if !w.pausedSince.IsZero() {
+		paused += now.Sub(w.pausedSince)
+	}
+
+	return now.Sub(w.start) - paused
+}
+
+// interval returns the interval between two checks of the transfer, a fraction of the shortest timeout.
+func (w *watchdog) interval(speedTime time.Duration) time.Duration {
+	interval := maxWatchInterval
+	for _, timeout := range []time.Duration{w.config.ReadTimeout, speedTime} {
+		if timeout > 0 && timeout/10 < interval {
+			interval = timeout / 10
+		}
+	}
+
+	if interval < time.Millisecond {
+		interval = time.Millisecond
+	}
+
+	return interval
+}
+
+// run checks the transfer at regular intervals, until it stalls or the watchdog is stopped.
+func (w *watchdog) run(limit int64, speedTime time.Duration) {
+	defer close(w.exited)
+
+	ticker := time.NewTicker(w.interval(speedTime))
+	defer ticker.Stop()
+
+	lastReceived, lastProgress := int64(0), time.Duration(0)
+	samples := []speedSample{{}}
+
+	for {
+		select {
+		case <-w.stopped:
+			return
+		case tick := <-ticker.C:
+			now := w.clock(tick)
+			received := w.received.Load()
+			if received != lastReceived {
+				lastReceived, lastProgress = received, now
+			}
+
+			if w.config.ReadTimeout > 0 && now-lastProgress >= w.config.ReadTimeout {
+				w.err = fmt.Errorf("%w: no data received for %s", StalledErr, w.config.ReadTimeout)
+				w.cancel()
+				return
+			}
+
+			if limit <= 0 {
+				continue
+			}
+
+			// Measure the speed over the last speed time, dropping the samples no longer needed.
+			samples = append(samples, speedSample{at: now, received: received})
+			for len(samples) > 1 && now-samples[1].at >= speedTime {
+				samples = samples[1:]
+			}
+
+			if elapsed := now - samples[0].at; elapsed >= speedTime {
+				speed := float64(received-samples[0].received) / elapsed.Seconds()
+				if speed < float64(limit) {
+					w.err = fmt.Errorf("%w: less than %d bytes per second during %s", TooSlowErr, limit, speedTime)
+					w.cancel()
+					return
+				}
+			}
+		}
+	}
+}
+
+// body counts the bytes read from a response body.
+func (w *watchdog) body(body io.ReadCloser) io.ReadCloser {
+	return watchedBody{ReadCloser: body, watchdog: w}
+}
+
+// stop stops watching the transfer, and returns the reason of the cancellation, if the transfer stalled.
+func (w *watchdog) stop() error {
+	w.stopOnce.Do(func() { close(w.stopped) })
+
+	<-w.exited
+	w.cancel()
+
+	return w.err
+}
+
+// watchedBody reports the bytes read from a response body to its watchdog.
+type watchedBody struct {
+	io.ReadCloser
+	watchdog *watchdog
+}
+
+func (b watchedBody) Read(p []byte) (int, error) {
+	b.watchdog.resume()
+	defer b.watchdog.pause()
+
+	n, err := b.ReadCloser.Read(p)
+	b.watchdog.received.Add(int64(n))
+
+	return n, err
+}
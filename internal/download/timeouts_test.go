@@ -0,0 +1,221 @@
+package download_test
+
+import (
+	"bytes"
+	"context"
+	"github.com/MarcoTomasRodriguez/hget/internal/download"
+	"github.com/MarcoTomasRodriguez/hget/pkg/codec"
+	"github.com/MarcoTomasRodriguez/hget/pkg/logger"
+	"github.com/MarcoTomasRodriguez/hget/pkg/progressbar"
+	"github.com/spf13/afero"
+	"github.com/stretchr/testify/suite"
+	"math/rand"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// stallingWriter writes the first bytes of a response, then stalls until the client gives up.
+type stallingWriter struct {
+	http.ResponseWriter
+	ctx     context.Context
+	limit   int
+	written int
+}
+
+func (w *stallingWriter) Write(p []byte) (int, error) {
+	if w.written+len(p) <= w.limit {
+		w.written += len(p)
+		return w.ResponseWriter.Write(p)
+	}
+
+	n, _ := w.ResponseWriter.Write(p[:w.limit-w.written])
+	w.written += n
+	w.ResponseWriter.(http.Flusher).Flush()
+	<-w.ctx.Done()
+
+	return n, w.ctx.Err()
+}
+
+type TimeoutSuite struct {
+	suite.Suite
+	content []byte
+	// stalls is the number of segment requests of /stall.bin stalling after their first 1000 bytes.
+	stalls atomic.Int32
+	server *httptest.Server
+}
+
+func (s *TimeoutSuite) SetupTest() {
+	s.content = make([]byte, 10_000)
+	rand.Read(s.content)
+	s.stalls.Store(0)
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/stall.bin", func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == "GET" && r.Header.Get("Range") != "bytes=0-0" && s.stalls.Add(-1) >= 0 {
+			w = &stallingWriter{ResponseWriter: w, ctx: r.Context(), limit: 1000}
+		}
+
+		http.ServeContent(w, r, "stall.bin", time.Time{}, bytes.NewReader(s.content))
+	})
+
+	// The slow file, of 1000 bytes, is sent at about 1000 bytes per second.
+	mux.HandleFunc("/slow.bin", func(w http.ResponseWriter, r *http.Request) {
+		for start := 0; start < 1000; start += 10 {
+			_, _ = w.Write(s.content[start : start+10])
+			w.(http.Flusher).Flush()
+
+			select {
+			case <-r.Context().Done():
+				return
+			case <-time.After(10 * time.Millisecond):
+			}
+		}
+	})
+
+	// The late file sends its headers after a second.
+	mux.HandleFunc("/late.bin", func(w http.ResponseWriter, r *http.Request) {
+		select {
+		case <-r.Context().Done():
+		case <-time.After(time.Second):
+		}
+	})
+
+	s.server = httptest.NewServer(mux)
+}
+
+func (s *TimeoutSuite) TearDownTest() {
+	s.server.Close()
+}
+
+func (s *TimeoutSuite) network(config download.HTTPConfig) download.Network {
+	network, err := download.NewHTTPNetwork(config)
+	s.Require().NoError(err)
+
+	return network
+}
+
+func (s *TimeoutSuite) TestHTTPNetwork_DownloadResource_ShouldCancelStalledTransfer() {
+	network := s.network(download.HTTPConfig{Timeouts: download.TimeoutConfig{ReadTimeout: 100 * time.Millisecond}})
+	resource, err := network.FetchResource(s.server.URL + "/stall.bin")
+	s.Require().NoError(err)
+
+	s.stalls.Store(1)
+	buffer := new(bytes.Buffer)
+	start := time.Now()
+	err = network.DownloadResource(resource, 0, resource.Size, buffer, context.TODO())
+
+	s.ErrorIs(err, download.StalledErr)
+	s.True(download.Retryable(err))
+	s.Less(time.Since(start), 5*time.Second)
+	s.Equal(s.content[:1000], buffer.Bytes())
+}
+
+func (s *TimeoutSuite) TestHTTPNetwork_DownloadResource_ShouldCancelSlowTransfer() {
+	network := s.network(download.HTTPConfig{Timeouts: download.TimeoutConfig{
+		ReadTimeout: time.Second,
+		SpeedLimit:  100_000,
+		SpeedTime:   200 * time.Millisecond,
+	}})
+	resource := download.Resource{URL: s.server.URL + "/slow.bin", Size: -1}
+
+	start := time.Now()
+	err := network.DownloadResource(resource, 0, -1, new(bytes.Buffer), context.TODO())
+
+	s.ErrorIs(err, download.TooSlowErr)
+	s.True(download.Retryable(err))
+	s.Less(time.Since(start), 5*time.Second)
+}
+
+func (s *TimeoutSuite) TestHTTPNetwork_DownloadResource_ShouldUseHostTimeouts() {
+	network := s.network(download.HTTPConfig{
+		Timeouts:     download.TimeoutConfig{SpeedLimit: 1, SpeedTime: time.Minute},
+		HostTimeouts: map[string]download.TimeoutConfig{"127.0.0.1": {ReadTimeout: 100 * time.Millisecond}},
+	})
+	resource, err := network.FetchResource(s.server.URL + "/stall.bin")
+	s.Require().NoError(err)
+
+	s.stalls.Store(1)
+	s.ErrorIs(network.DownloadResource(resource, 0, resource.Size, new(bytes.Buffer), context.TODO()), download.StalledErr)
+}
+
+func (s *TimeoutSuite) TestHTTPNetwork_DownloadResource_ShouldNotCancelActiveTransfer() {
+	network := s.network(download.HTTPConfig{Timeouts: download.TimeoutConfig{
+		ReadTimeout: 100 * time.Millisecond,
+		SpeedLimit:  100,
+		SpeedTime:   100 * time.Millisecond,
+	}})
+	resource := download.Resource{URL: s.server.URL + "/slow.bin", Size: -1}
+
+	buffer := new(bytes.Buffer)
+	s.NoError(network.DownloadResource(resource, 0, -1, buffer, context.TODO()))
+	s.Equal(s.content[:1000], buffer.Bytes())
+}
+
+func (s *TimeoutSuite) TestHTTPNetwork_FetchResource_ShouldTimeOutWaitingForHeaders() {
+	network := s.network(download.HTTPConfig{Timeouts: download.TimeoutConfig{ReadTimeout: 100 * time.Millisecond}})
+
+	start := time.Now()
+	_, err := network.FetchResource(s.server.URL + "/late.bin")
+
+	s.Error(err)
+	s.True(download.Retryable(err))
+	s.Less(time.Since(start), 900*time.Millisecond)
+}
+
+func (s *TimeoutSuite) TestHTTPNetwork_FetchResource_ShouldTimeOutConnecting() {
+	// The listener never answers the TLS handshake.
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	s.Require().NoError(err)
+	defer listener.Close()
+
+	network := s.network(download.HTTPConfig{Timeouts: download.TimeoutConfig{ConnectTimeout: 100 * time.Millisecond}})
+
+	start := time.Now()
+	_, err = network.FetchResource("https://" + listener.Addr().String() + "/file.bin")
+
+	s.Error(err)
+	s.True(download.Retryable(err))
+	s.Less(time.Since(start), 5*time.Second)
+}
+
+func (s *TimeoutSuite) TestDownloader_ShouldRetryStalledSegment() {
+	network := s.network(download.HTTPConfig{Timeouts: download.TimeoutConfig{ReadTimeout: 100 * time.Millisecond}})
+	fs := afero.NewMemMapFs()
+	downloader := download.NewDownloader(network, download.NewStorage(fs, codec.NewYAMLCodec()),
+		progressbar.NoopProgressBar{}, logger.NoopConsoleLogger{},
+		download.Config{Retry: download.RetryPolicy{MaxAttempts: 2, BaseDelay: time.Millisecond, MaxDelay: time.Millisecond}})
+
+	spec, err := downloader.InitDownload(s.server.URL+"/stall.bin", download.Options{Workers: 2})
+	s.Require().NoError(err)
+
+	s.stalls.Store(1)
+	s.Require().NoError(downloader.Download(spec, context.TODO()))
+
+	output, err := afero.ReadFile(fs, spec.Id+"/output")
+	s.Require().NoError(err)
+	s.Equal(s.content, output)
+}
+
+func (s *TimeoutSuite) TestDownloader_ShouldNotCancelRateLimitedSegments() {
+	network := s.network(download.HTTPConfig{Timeouts: download.TimeoutConfig{ReadTimeout: 200 * time.Millisecond}})
+	fs := afero.NewMemMapFs()
+	downloader := download.NewDownloader(network, download.NewStorage(fs, codec.NewYAMLCodec()),
+		progressbar.NoopProgressBar{}, logger.NoopConsoleLogger{}, download.Config{Retry: download.RetryPolicy{MaxAttempts: 1}})
+
+	// The workers spend more than the read timeout waiting for the rate limiter, not for the server.
+	spec, err := downloader.InitDownload(s.server.URL+"/stall.bin", download.Options{Workers: 2, RateLimit: 8_000})
+	s.Require().NoError(err)
+	s.Require().NoError(downloader.Download(spec, context.TODO()))
+
+	output, err := afero.ReadFile(fs, spec.Id+"/output")
+	s.Require().NoError(err)
+	s.Equal(s.content, output)
+}
+
+func TestTimeoutSuite(t *testing.T) {
+	suite.Run(t, new(TimeoutSuite))
+}
@@ -0,0 +1,200 @@
+package download
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+)
+
+// pinnedKeyPrefix prefixes the base64 encoded SHA-256 digests of the pinned public keys, as in curl.
+const pinnedKeyPrefix = "sha256//"
+
+// tlsVersions are the TLS versions accepted as minimum version. The deprecated TLS 1.0 and 1.1 are not allowed.
+var tlsVersions = map[string]uint16{
+	"1.2": tls.VersionTLS12,
+	"1.3": tls.VersionTLS13,
+}
+
+var (
+	InvalidTLSConfigErr  = errors.New("invalid tls configuration")
+	CertificateErr       = errors.New("certificate verification failed")
+	PinnedKeyMismatchErr = errors.New("public key does not match the pinned public keys")
+)
+
+// TLSConfig configures the TLS connections of the http network.
+type TLSConfig struct {
+	// CACertFiles are PEM bundles of the certificate authorities trusted in addition to the system ones.
+	CACertFiles []string `mapstructure:"cacert"`
+	// CertFile and KeyFile are the PEM client certificate and private key presented to the servers requiring one.
+	// The key may be in the certificate file.
+	CertFile string `mapstructure:"cert"`
+	KeyFile  string `mapstructure:"key"`
+	// PinnedPublicKeys are the public keys accepted for the server certificate: "sha256//" followed by the base64
+	// encoded SHA-256 digest of a public key, several separated by ";", or a PEM or DER public key file.
+	PinnedPublicKeys []string `mapstructure:"pinned_pubkey"`
+	// Insecure disables the verification of the server certificates. Pinned public keys are still checked.
+	Insecure bool `mapstructure:"insecure"`
+	// MinVersion is the minimum TLS version of the connections, e.g. "1.3". If empty, TLS 1.2 is required.
+	MinVersion string `mapstructure:"min_version"`
+}
+
+// merge returns the configuration of a host, overriding this configuration. Certificate authorities are added to
+// those of this configuration.
+func (c TLSConfig) merge(host TLSConfig) TLSConfig {
+	merged := c
+	merged.CACertFiles = append(append([]string(nil), c.CACertFiles...), host.CACertFiles...)
+
+	if host.CertFile != "" || host.KeyFile != "" {
+		merged.CertFile, merged.KeyFile = host.CertFile, host.KeyFile
+	}
+
+	if len(host.PinnedPublicKeys) > 0 {
+		merged.PinnedPublicKeys = host.PinnedPublicKeys
+	}
+
+	merged.Insecure = c.Insecure || host.Insecure
+
+	if host.MinVersion != "" {
+		merged.MinVersion = host.MinVersion
+	}
+
+	return merged
+}
+
+// ClientConfig loads the certificates and keys of the configuration, and returns the TLS configuration of the clients.
+func (c TLSConfig) ClientConfig() (*tls.Config, error) {
+	config := &tls.Config{InsecureSkipVerify: c.Insecure, MinVersion: tls.VersionTLS12}
+
+	if c.MinVersion != "" {
+		minVersion, ok := tlsVersions[strings.TrimPrefix(strings.ToLower(c.MinVersion), "tlsv")]
+		if !ok {
+			return nil, fmt.Errorf("%w: unsupported tls version %q", InvalidTLSConfigErr, c.MinVersion)
+		}
+
+		config.MinVersion = minVersion
+	}
+
+	if len(c.CACertFiles) > 0 {
+		pool, err := x509.SystemCertPool()
+		if err != nil {
+			pool = x509.NewCertPool()
+		}
+
+		for _, caCertFile := range c.CACertFiles {
+			content, err := os.ReadFile(caCertFile)
+			if err != nil {
+				return nil, fmt.Errorf("%w: %v", InvalidTLSConfigErr, err)
+			}
+
+			if !pool.AppendCertsFromPEM(content) {
+				return nil, fmt.Errorf("%w: no certificate found in %s", InvalidTLSConfigErr, caCertFile)
+			}
+		}
+
+		config.RootCAs = pool
+	}
+
+	if c.CertFile != "" {
+		keyFile := c.KeyFile
+		if keyFile == "" {
+			keyFile = c.CertFile
+		}
+
+		certificate, err := tls.LoadX509KeyPair(c.CertFile, keyFile)
+		if err != nil {
+			return nil, fmt.Errorf("%w: %v", InvalidTLSConfigErr, err)
+		}
+
+		config.Certificates = []tls.Certificate{certificate}
+	} else if c.KeyFile != "" {
+		return nil, fmt.Errorf("%w: key without certificate", InvalidTLSConfigErr)
+	}
+
+	if len(c.PinnedPublicKeys) > 0 {
+		pins, err := parsePinnedPublicKeys(c.PinnedPublicKeys)
+		if err != nil {
+			return nil, err
+		}
+
+		config.VerifyConnection = func(state tls.ConnectionState) error {
+			if len(state.PeerCertificates) == 0 {
+				return PinnedKeyMismatchErr
+			}
+
+			digest := sha256.Sum256(state.PeerCertificates[0].RawSubjectPublicKeyInfo)
+			for _, pin := range pins {
+				if bytes.Equal(pin, digest[:]) {
+					return nil
+				}
+			}
+
+			return PinnedKeyMismatchErr
+		}
+	}
+
+	return config, nil
+}
+
+// parsePinnedPublicKeys returns the SHA-256 digests of the pinned public keys.
+func parsePinnedPublicKeys(pinnedKeys []string) ([][]byte, error) {
+	var pins [][]byte
+	for _, pinnedKey := range pinnedKeys {
+		if !strings.HasPrefix(pinnedKey, pinnedKeyPrefix) {
+			pin, err := publicKeyFileDigest(pinnedKey)
+			if err != nil {
+				return nil, err
+			}
+
+			pins = append(pins, pin)
+			continue
+		}
+
+		for _, encoded := range strings.Split(pinnedKey, ";") {
+			pin, err := base64.StdEncoding.DecodeString(strings.TrimPrefix(strings.TrimSpace(encoded), pinnedKeyPrefix))
+			if err != nil || len(pin) != sha256.Size {
+				return nil, fmt.Errorf("%w: invalid pinned public key %q", InvalidTLSConfigErr, encoded)
+			}
+
+			pins = append(pins, pin)
+		}
+	}
+
+	return pins, nil
+}
+
+// publicKeyFileDigest returns the SHA-256 digest of the public key of a PEM or DER file.
+func publicKeyFileDigest(publicKeyFile string) ([]byte, error) {
+	content, err := os.ReadFile(publicKeyFile)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", InvalidTLSConfigErr, err)
+	}
+
+	if block, _ := pem.Decode(content); block != nil {
+		content = block.Bytes
+	}
+
+	if _, err := x509.ParsePKIXPublicKey(content); err != nil {
+		return nil, fmt.Errorf("%w: %s: %v", InvalidTLSConfigErr, publicKeyFile, err)
+	}
+
+	digest := sha256.Sum256(content)
+	return digest[:], nil
+}
+
+// certificateError reports whether an error is caused by the verification of a server certificate, which is permanent,
+// unlike the other connection errors.
+func certificateError(err error) bool {
+	var unknownAuthorityErr x509.UnknownAuthorityError
+	var certificateInvalidErr x509.CertificateInvalidError
+	var hostnameErr x509.HostnameError
+
+	return errors.As(err, &unknownAuthorityErr) || errors.As(err, &certificateInvalidErr) ||
+		errors.As(err, &hostnameErr) || errors.Is(err, PinnedKeyMismatchErr)
+}
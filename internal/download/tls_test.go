@@ -0,0 +1,170 @@
+package download_test
+
+import (
+	"bytes"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/base64"
+	"encoding/pem"
+	"github.com/MarcoTomasRodriguez/hget/internal/download"
+	"github.com/stretchr/testify/suite"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+type TLSSuite struct {
+	suite.Suite
+	dir    string
+	server *httptest.Server
+	// caCertFile is the PEM certificate of the server, which is self-signed.
+	caCertFile string
+}
+
+func (s *TLSSuite) SetupTest() {
+	s.dir = s.T().TempDir()
+	s.server = httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.ServeContent(w, r, "file.bin", time.Time{}, bytes.NewReader([]byte("hello world")))
+	}))
+
+	s.caCertFile = s.writePEM("ca.pem", "CERTIFICATE", s.server.Certificate().Raw)
+}
+
+func (s *TLSSuite) TearDownTest() {
+	s.server.Close()
+}
+
+func (s *TLSSuite) writePEM(name string, blockType string, der []byte) string {
+	file := filepath.Join(s.dir, name)
+	s.Require().NoError(os.WriteFile(file, pem.EncodeToMemory(&pem.Block{Type: blockType, Bytes: der}), 0600))
+
+	return file
+}
+
+// fetch fetches the resource of the server with a network using the configuration.
+func (s *TLSSuite) fetch(config download.HTTPConfig) error {
+	network, err := download.NewHTTPNetwork(config)
+	s.Require().NoError(err)
+
+	_, err = network.FetchResource(s.server.URL + "/file.bin")
+	return err
+}
+
+func (s *TLSSuite) TestHTTPNetwork_ShouldFailIfUnknownAuthority() {
+	err := s.fetch(download.HTTPConfig{})
+	s.ErrorIs(err, download.CertificateErr)
+	s.False(download.Retryable(err))
+}
+
+func (s *TLSSuite) TestHTTPNetwork_ShouldTrustCACertificate() {
+	s.NoError(s.fetch(download.HTTPConfig{TLS: download.TLSConfig{CACertFiles: []string{s.caCertFile}}}))
+}
+
+func (s *TLSSuite) TestHTTPNetwork_ShouldSkipVerificationIfInsecure() {
+	s.NoError(s.fetch(download.HTTPConfig{TLS: download.TLSConfig{Insecure: true}}))
+}
+
+func (s *TLSSuite) TestHTTPNetwork_ShouldCheckPinnedPublicKey() {
+	digest := sha256.Sum256(s.server.Certificate().RawSubjectPublicKeyInfo)
+	pin := "sha256//" + base64.StdEncoding.EncodeToString(digest[:])
+	otherPin := "sha256//" + base64.StdEncoding.EncodeToString(make([]byte, sha256.Size))
+
+	s.NoError(s.fetch(download.HTTPConfig{TLS: download.TLSConfig{Insecure: true, PinnedPublicKeys: []string{otherPin + ";" + pin}}}))
+
+	publicKeyFile := s.writePEM("pubkey.pem", "PUBLIC KEY", s.server.Certificate().RawSubjectPublicKeyInfo)
+	s.NoError(s.fetch(download.HTTPConfig{TLS: download.TLSConfig{CACertFiles: []string{s.caCertFile}, PinnedPublicKeys: []string{publicKeyFile}}}))
+
+	err := s.fetch(download.HTTPConfig{TLS: download.TLSConfig{Insecure: true, PinnedPublicKeys: []string{otherPin}}})
+	s.ErrorIs(err, download.CertificateErr)
+	s.False(download.Retryable(err))
+}
+
+func (s *TLSSuite) TestHTTPNetwork_ShouldPresentClientCertificate() {
+	// Generate a self-signed client certificate, and require it.
+	key, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: "hget"},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+	}
+
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	s.Require().NoError(err)
+	certificate, _ := x509.ParseCertificate(der)
+	keyDER, _ := x509.MarshalPKCS8PrivateKey(key)
+
+	s.server.Close()
+	s.server = httptest.NewUnstartedServer(s.server.Config.Handler)
+	s.server.TLS = &tls.Config{ClientAuth: tls.RequireAndVerifyClientCert, ClientCAs: x509.NewCertPool()}
+	s.server.TLS.ClientCAs.AddCert(certificate)
+	s.server.StartTLS()
+	caCertFile := s.writePEM("server.pem", "CERTIFICATE", s.server.Certificate().Raw)
+
+	config := download.TLSConfig{CACertFiles: []string{caCertFile}}
+	s.Error(s.fetch(download.HTTPConfig{TLS: config}))
+
+	config.CertFile = s.writePEM("client.pem", "CERTIFICATE", der)
+	config.KeyFile = s.writePEM("client.key", "PRIVATE KEY", keyDER)
+	s.NoError(s.fetch(download.HTTPConfig{TLS: config}))
+}
+
+func (s *TLSSuite) TestHTTPNetwork_ShouldUseHostTLSConfig() {
+	hostTLS := map[string]download.TLSConfig{"127.0.0.1": {CACertFiles: []string{s.caCertFile}}}
+	s.NoError(s.fetch(download.HTTPConfig{HostTLS: hostTLS}))
+
+	hostTLS = map[string]download.TLSConfig{"other.test": {CACertFiles: []string{s.caCertFile}}}
+	s.ErrorIs(s.fetch(download.HTTPConfig{HostTLS: hostTLS}), download.CertificateErr)
+}
+
+func (s *TLSSuite) TestHTTPNetwork_ShouldRequireMinVersion() {
+	server := httptest.NewUnstartedServer(s.server.Config.Handler)
+	server.TLS = &tls.Config{MaxVersion: tls.VersionTLS12}
+	server.StartTLS()
+	defer server.Close()
+
+	for version, ok := range map[string]bool{"": true, "1.2": true, "TLSv1.2": true, "1.3": false} {
+		network, err := download.NewHTTPNetwork(download.HTTPConfig{TLS: download.TLSConfig{Insecure: true, MinVersion: version}})
+		s.Require().NoError(err)
+
+		_, err = network.FetchResource(server.URL + "/file.bin")
+		s.Equal(ok, err == nil, version)
+	}
+}
+
+func (s *TLSSuite) TestNewHTTPNetwork_ShouldFailIfInvalidTLSConfig() {
+	configs := []download.TLSConfig{
+		{CACertFiles: []string{filepath.Join(s.dir, "missing.pem")}},
+		{CACertFiles: []string{s.writePEM("empty.pem", "EMPTY", nil)}},
+		{CertFile: s.caCertFile},
+		{KeyFile: s.caCertFile},
+		{PinnedPublicKeys: []string{"sha256//invalid"}},
+		{PinnedPublicKeys: []string{s.caCertFile}},
+		{MinVersion: "1.1"},
+		{MinVersion: "TLSv1.0"},
+		{MinVersion: "2.0"},
+	}
+
+	for _, config := range configs {
+		_, err := download.NewHTTPNetwork(download.HTTPConfig{TLS: config})
+		s.ErrorIs(err, download.InvalidTLSConfigErr)
+
+		_, err = download.NewHTTPNetwork(download.HTTPConfig{HostTLS: map[string]download.TLSConfig{"test.com": config}})
+		s.ErrorIs(err, download.InvalidTLSConfigErr)
+	}
+}
+
+func TestTLSSuite(t *testing.T) {
+	suite.Run(t, new(TLSSuite))
+}
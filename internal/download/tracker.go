@@ -0,0 +1,244 @@
+package download
+
+import (
+	"errors"
+	"fmt"
+	"github.com/MarcoTomasRodriguez/hget/pkg/progressbar"
+	"io"
+	"math"
+	"sync"
+)
+
+// DefaultMinSplitSize is the minimum size of the segments created by splitting a running segment.
+const DefaultMinSplitSize = 1 << 20
+
+// segmentCompleteErr is returned by a segment writer once the segment reached its end, which may have moved since
+// the request was sent if the segment was split.
+var segmentCompleteErr = errors.New("segment complete")
+
+// segmentTracker tracks the progress of the segments of a download while they are being downloaded. When a worker
+// becomes free, it splits the running segment with the most remaining bytes, and hands its second half to the worker.
+type segmentTracker struct {
+	// mu guards the download specification and the running state of the segments.
+	mu           sync.Mutex
+	download     *Download
+	storage      Storage
+	segments     []*trackedSegment
+	minSplitSize int64
+}
+
+// trackedSegment stores the progress of a segment.
+type trackedSegment struct {
+	// mu guards the offset and the end points, which are read by the segment writer and moved by splits.
+	mu sync.Mutex
+	// offset is the position of the next byte to write.
+	offset int64
+	// end is the end point of the segment, as recorded in its specification.
+	end int64
+	// limit is the position after the last byte of the segment.
+	limit   int64
+	running bool
+	bar     progressbar.Bar
+}
+
+// newSegmentTracker initializes a tracker with the progress of the segments saved on the storage. If minSplitSize is
+// not positive, segments are never split.
+func newSegmentTracker(download *Download, storage Storage, minSplitSize int64) *segmentTracker {
+	t := &segmentTracker{download: download, storage: storage, minSplitSize: minSplitSize}
+
+	for _, segment := range download.Segments {
+		segmentSize, _ := storage.GetSegmentSize(segment.Id)
+		t.segments = append(t.segments, &trackedSegment{
+			offset: segment.Start + segmentSize,
+			end:    segment.End,
+			limit:  t.limit(segment.End),
+		})
+	}
+
+	return t
+}
+
+// limit returns the position after the last byte of a segment with the given end point. The end point is inclusive,
+// except for the last segment, whose end point is the download size. Streamed segments have no limit.
+func (t *segmentTracker) limit(end int64) int64 {
+	if end < 0 {
+		return math.MaxInt64
+	}
+
+	if t.download.Size >= 0 && end >= t.download.Size {
+		return t.download.Size
+	}
+
+	return end + 1
+}
+
+// start marks a segment as running and attaches it to the worker's progress bar.
+func (t *segmentTracker) start(i int, bar progressbar.Bar) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	t.segments[i].running = true
+	t.segments[i].bar = bar
+}
+
+// segment returns the specification of a segment.
+func (t *segmentTracker) segment(i int) Segment {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	return t.download.Segments[i]
+}
+
+// tracked returns the progress of a segment. The slice of segments is guarded, as it grows with splits.
+func (t *segmentTracker) tracked(i int) *trackedSegment {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	return t.segments[i]
+}
+
+// remaining returns the range of a segment that is left to download.
+func (t *segmentTracker) remaining(i int) (start int64, end int64) {
+	segment := t.tracked(i)
+	segment.mu.Lock()
+	defer segment.mu.Unlock()
+
+	return segment.offset, segment.end
+}
+
+// complete reports whether all the bytes of a segment were written.
+func (t *segmentTracker) complete(i int) bool {
+	segment := t.tracked(i)
+	segment.mu.Lock()
+	defer segment.mu.Unlock()
+
+	return segment.offset >= segment.limit
+}
+
+// left returns the number of bytes of a segment that are left to download, or zero if it is streamed.
+func (t *segmentTracker) left(i int) int64 {
+	segment := t.tracked(i)
+	segment.mu.Lock()
+	defer segment.mu.Unlock()
+
+	if segment.limit == math.MaxInt64 {
+		return 0
+	}
+
+	return segment.limit - segment.offset
+}
+
+// writer returns a writer for a segment which never writes past its end, even if it moves.
+func (t *segmentTracker) writer(i int, writer io.Writer) io.Writer {
+	return &segmentWriter{segment: t.tracked(i), writer: writer}
+}
+
+// recordRetry records a failed attempt to download a segment in the download specification.
+func (t *segmentTracker) recordRetry(i int, err error) error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	t.download.Segments[i].Retries++
+	t.download.Segments[i].LastError = err.Error()
+
+	return t.storage.WriteDownloadSpec(*t.download)
+}
+
+// recordAddress records the address the download is pinned to in the download specification.
+func (t *segmentTracker) recordAddress(address string) error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	t.download.Address = address
+
+	return t.storage.WriteDownloadSpec(*t.download)
+}
+
+// split is called when the worker of a segment finished it. It splits the running segment with the most remaining
+// bytes in two halves, persists the new layout, and returns the index of the new segment, which should be downloaded
+// by the same worker. If no segment is large enough to be split, it returns false.
+func (t *segmentTracker) split(finished int) (int, bool, error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	t.segments[finished].running = false
+	bar := t.segments[finished].bar
+
+	if t.minSplitSize <= 0 {
+		return 0, false, nil
+	}
+
+	// Find the running segment with the most remaining bytes.
+	victim, victimRemaining := -1, int64(0)
+	for i, segment := range t.segments {
+		if !segment.running {
+			continue
+		}
+
+		segment.mu.Lock()
+		remaining := segment.limit - segment.offset
+		segment.mu.Unlock()
+
+		if remaining > victimRemaining {
+			victim, victimRemaining = i, remaining
+		}
+	}
+
+	if victim < 0 || victimRemaining < 2*t.minSplitSize {
+		return 0, false, nil
+	}
+
+	// Move the end of the victim to the middle of its remaining bytes. The segment is locked, so that its writer
+	// cannot write past the new end.
+	segment := t.segments[victim]
+	segment.mu.Lock()
+	middle := segment.offset + (segment.limit-segment.offset)/2
+	end, limit := segment.end, segment.limit
+	segment.end, segment.limit = middle-1, middle
+	segment.mu.Unlock()
+
+	segment.bar.AddTotal(middle - limit)
+	bar.AddTotal(limit - middle)
+
+	// Append the second half as a new segment. The segments are sorted by their start point when merged.
+	index := len(t.download.Segments)
+	t.download.Segments[victim].End = middle - 1
+	t.download.Segments = append(t.download.Segments, Segment{
+		Id:    fmt.Sprintf("%s/segment.%02d", t.download.Id, index),
+		Start: middle,
+		End:   end,
+	})
+	t.segments = append(t.segments, &trackedSegment{offset: middle, end: end, limit: limit, running: true, bar: bar})
+
+	return index, true, t.storage.WriteDownloadSpec(*t.download)
+}
+
+// segmentWriter writes the bytes of a segment, up to its end.
+type segmentWriter struct {
+	segment *trackedSegment
+	writer  io.Writer
+}
+
+func (w *segmentWriter) Write(p []byte) (int, error) {
+	w.segment.mu.Lock()
+	defer w.segment.mu.Unlock()
+
+	// Drop the bytes past the end of the segment, which now belong to another segment.
+	var err error
+	if remaining := w.segment.limit - w.segment.offset; int64(len(p)) > remaining {
+		if remaining < 0 {
+			remaining = 0
+		}
+
+		p = p[:remaining]
+		err = segmentCompleteErr
+	}
+
+	n, writeErr := w.writer.Write(p)
+	w.segment.offset += int64(n)
+	if writeErr != nil {
+		return n, writeErr
+	}
+
+	return n, err
+}
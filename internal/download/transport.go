@@ -0,0 +1,200 @@
+package download
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// defaultKeepAlive is the interval of the TCP keep-alive probes, when none is configured.
+	defaultKeepAlive = 30 * time.Second
+	// defaultMaxIdleConns is the number of idle connections kept for all hosts, when none is configured.
+	defaultMaxIdleConns = 100
+	// defaultIdleConnTimeout is the time an idle connection is kept, when none is configured.
+	defaultIdleConnTimeout = 90 * time.Second
+	// maxDrainSize is the number of bytes read from a response body before closing it, so that its connection is
+	// reused. Larger bodies close their connection.
+	maxDrainSize = 4 << 10
+)
+
+var InvalidTransportConfigErr = errors.New("invalid transport configuration")
+
+// TransportConfig configures the connections of the http network. Connections are kept alive and reused by the
+// probing and segment requests of every download.
+type TransportConfig struct {
+	// MaxConnsPerHost limits the connections to each host, usually to the number of workers. If zero, the connections
+	// are not limited.
+	MaxConnsPerHost int
+	// MaxIdleConnsPerHost limits the idle connections kept for each host. If zero, as many connections as allowed by
+	// MaxConnsPerHost are kept, or 2 without limit.
+	MaxIdleConnsPerHost int
+	// MaxIdleConns limits the idle connections kept for all hosts. If zero, 100 connections are kept.
+	MaxIdleConns int
+	// IdleConnTimeout closes the connections idle for this duration. If zero, they are closed after 90 seconds.
+	IdleConnTimeout time.Duration
+	// KeepAlive is the interval of the TCP keep-alive probes. If zero, probes are sent every 30 seconds; if negative,
+	// they are disabled.
+	KeepAlive time.Duration
+	// LocalAddress is the local IP address of the connections, e.g. to send them through a network interface.
+	LocalAddress string
+	// DisableHTTP2 restricts the connections to HTTP/1.1.
+	DisableHTTP2 bool
+}
+
+// dialer returns the dialer of the connections, with the given connect timeout.
+func (c TransportConfig) dialer(timeout time.Duration) (*net.Dialer, error) {
+	dialer := &net.Dialer{Timeout: timeout, KeepAlive: c.KeepAlive}
+	if c.KeepAlive == 0 {
+		dialer.KeepAlive = defaultKeepAlive
+	}
+
+	if c.LocalAddress != "" {
+		ip := net.ParseIP(c.LocalAddress)
+		if ip == nil {
+			return nil, fmt.Errorf("%w: invalid local address %q", InvalidTransportConfigErr, c.LocalAddress)
+		}
+
+		dialer.LocalAddr = &net.TCPAddr{IP: ip}
+	}
+
+	return dialer, nil
+}
+
+// idlePools returns the number of idle connections kept for all hosts, and for each host.
+func (c TransportConfig) idlePools() (int, int) {
+	perHost := c.MaxIdleConnsPerHost
+	if perHost <= 0 {
+		perHost = c.MaxConnsPerHost
+	}
+
+	if perHost <= 0 {
+		perHost = http.DefaultMaxIdleConnsPerHost
+	}
+
+	total := c.MaxIdleConns
+	if total <= 0 {
+		total = defaultMaxIdleConns
+	}
+
+	if total < perHost {
+		total = perHost
+	}
+
+	return total, perHost
+}
+
+// newTransport creates a transport with the proxy, DNS and connection settings of the configuration, and the given TLS
+// configuration and timeouts. The read timeout limits the wait for the response headers.
+func newTransport(config HTTPConfig, tlsConfig TLSConfig, timeouts TimeoutConfig) (*http.Transport, error) {
+	clientConfig, err := tlsConfig.ClientConfig()
+	if err != nil {
+		return nil, err
+	}
+
+	dialer, err := config.Transport.dialer(timeouts.connectTimeout())
+	if err != nil {
+		return nil, err
+	}
+
+	dnsDialer, err := config.DNS.newDialer(dialer)
+	if err != nil {
+		return nil, err
+	}
+
+	idleConnTimeout := config.Transport.IdleConnTimeout
+	if idleConnTimeout <= 0 {
+		idleConnTimeout = defaultIdleConnTimeout
+	}
+
+	maxIdleConns, maxIdleConnsPerHost := config.Transport.idlePools()
+
+	transport := &http.Transport{
+		Proxy:                 config.Proxy.proxyFunc(),
+		DialContext:           dnsDialer.DialContext,
+		TLSClientConfig:       clientConfig,
+		ForceAttemptHTTP2:     !config.Transport.DisableHTTP2,
+		MaxConnsPerHost:       config.Transport.MaxConnsPerHost,
+		MaxIdleConns:          maxIdleConns,
+		MaxIdleConnsPerHost:   maxIdleConnsPerHost,
+		IdleConnTimeout:       idleConnTimeout,
+		TLSHandshakeTimeout:   timeouts.connectTimeout(),
+		ResponseHeaderTimeout: timeouts.ReadTimeout,
+		ExpectContinueTimeout: 1 * time.Second,
+	}
+
+	// An empty map of protocols upgraded by TLS disables HTTP/2.
+	if config.Transport.DisableHTTP2 {
+		transport.TLSNextProto = map[string]func(string, *tls.Conn) http.RoundTripper{}
+	}
+
+	return transport, nil
+}
+
+// closeResponse closes a response, reading the rest of a small body first, so that the connection is reused.
+func closeResponse(response *http.Response) {
+	_, _ = io.CopyN(io.Discard, response.Body, maxDrainSize)
+	_ = response.Body.Close()
+}
+
+// hostTransport sends each request through the transport of its host, or the fallback transport. Requests pinned to
+// an address of their host are sent through a copy of this transport connecting to it, unless they are proxied.
+type hostTransport struct {
+	fallback *http.Transport
+	hosts    map[string]*http.Transport
+	// resolver looks up the addresses the requests may be pinned to.
+	resolver *dnsDialer
+	// mu guards the pinned transports, created on demand.
+	mu     sync.Mutex
+	pinned map[pinnedTransportKey]*http.Transport
+}
+
+func (t *hostTransport) RoundTrip(request *http.Request) (*http.Response, error) {
+	transport := t.transport(request)
+	if address, ok := pinnedAddress(request); ok && !proxied(transport, request) {
+		transport = t.pinnedTransport(transport, address)
+	}
+
+	return transport.RoundTrip(request)
+}
+
+// transport returns the transport of the host of a request.
+func (t *hostTransport) transport(request *http.Request) *http.Transport {
+	if transport, ok := t.hosts[strings.ToLower(request.URL.Hostname())]; ok {
+		return transport
+	}
+
+	return t.fallback
+}
+
+// addresses returns the IP addresses the requests to the host of a URL may be pinned to, in order, or none if they are
+// proxied.
+func (t *hostTransport) addresses(ctx context.Context, URL string) ([]string, error) {
+	request, err := http.NewRequestWithContext(ctx, "GET", URL, http.NoBody)
+	if err != nil {
+		return nil, err
+	}
+
+	if proxied(t.transport(request), request) {
+		return nil, nil
+	}
+
+	return t.resolver.lookup(ctx, hostPort(request.URL))
+}
+
+// proxied reports whether a transport sends the request through a proxy.
+func proxied(transport *http.Transport, request *http.Request) bool {
+	if transport.Proxy == nil {
+		return false
+	}
+
+	proxyURL, err := transport.Proxy(request)
+	return err != nil || proxyURL != nil
+}
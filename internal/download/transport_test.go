@@ -0,0 +1,142 @@
+package download_test
+
+import (
+	"bytes"
+	"context"
+	"github.com/MarcoTomasRodriguez/hget/internal/download"
+	"github.com/MarcoTomasRodriguez/hget/pkg/codec"
+	"github.com/MarcoTomasRodriguez/hget/pkg/logger"
+	"github.com/MarcoTomasRodriguez/hget/pkg/progressbar"
+	"github.com/spf13/afero"
+	"github.com/stretchr/testify/suite"
+	"math/rand"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+type TransportSuite struct {
+	suite.Suite
+	content []byte
+	server  *httptest.Server
+	// connections is the number of connections opened to the server.
+	connections atomic.Int32
+	mu          sync.Mutex
+	// protocols are the protocols of the requests, e.g. HTTP/2.0.
+	protocols []string
+}
+
+func (s *TransportSuite) SetupTest() {
+	s.content = make([]byte, 100_000)
+	rand.Read(s.content)
+	s.connections.Store(0)
+	s.protocols = nil
+
+	s.server = httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		s.mu.Lock()
+		s.protocols = append(s.protocols, r.Proto)
+		s.mu.Unlock()
+
+		http.ServeContent(w, r, "file.bin", time.Time{}, bytes.NewReader(s.content))
+	}))
+	s.server.Config.ConnState = func(conn net.Conn, state http.ConnState) {
+		if state == http.StateNew {
+			s.connections.Add(1)
+		}
+	}
+}
+
+func (s *TransportSuite) TearDownTest() {
+	s.server.Close()
+}
+
+func (s *TransportSuite) downloader(config download.HTTPConfig) (download.Downloader, afero.Fs) {
+	network, err := download.NewHTTPNetwork(config)
+	s.Require().NoError(err)
+
+	fs := afero.NewMemMapFs()
+	downloader := download.NewDownloader(network, download.NewStorage(fs, codec.NewYAMLCodec()),
+		progressbar.NoopProgressBar{}, logger.NoopConsoleLogger{}, download.Config{Retry: download.RetryPolicy{MaxAttempts: 1}})
+
+	return downloader, fs
+}
+
+func (s *TransportSuite) TestHTTPNetwork_ShouldReuseConnections() {
+	s.server.Start()
+
+	network, err := download.NewHTTPNetwork(download.HTTPConfig{})
+	s.Require().NoError(err)
+
+	resource, err := network.FetchResource(s.server.URL + "/file.bin")
+	s.Require().NoError(err)
+
+	for start := int64(0); start < resource.Size; start += 10_000 {
+		s.Require().NoError(network.DownloadResource(resource, start, start+9_999, new(bytes.Buffer), context.TODO()))
+	}
+
+	// The probing and segment requests are sent through the same connection.
+	s.Equal(int32(1), s.connections.Load())
+}
+
+func (s *TransportSuite) TestDownloader_ShouldLimitConnectionsPerHost() {
+	s.server.Start()
+
+	downloader, fs := s.downloader(download.HTTPConfig{Transport: download.TransportConfig{MaxConnsPerHost: 2}})
+
+	for i := 0; i < 2; i++ {
+		spec, err := downloader.InitDownload(s.server.URL+"/file.bin", download.Options{Workers: 8})
+		s.Require().NoError(err)
+		s.Require().NoError(downloader.Download(spec, context.TODO()))
+
+		output, err := afero.ReadFile(fs, spec.Id+"/output")
+		s.Require().NoError(err)
+		s.Equal(s.content, output)
+	}
+
+	// The connections are reused by the following download.
+	s.LessOrEqual(s.connections.Load(), int32(2))
+}
+
+func (s *TransportSuite) TestHTTPNetwork_ShouldNegotiateHTTP2() {
+	s.server.EnableHTTP2 = true
+	s.server.StartTLS()
+
+	for _, disableHTTP2 := range []bool{false, true} {
+		network, err := download.NewHTTPNetwork(download.HTTPConfig{
+			TLS:       download.TLSConfig{Insecure: true},
+			Transport: download.TransportConfig{DisableHTTP2: disableHTTP2},
+		})
+		s.Require().NoError(err)
+
+		s.protocols = nil
+		_, err = network.FetchResource(s.server.URL + "/file.bin")
+		s.Require().NoError(err)
+
+		if disableHTTP2 {
+			s.Equal([]string{"HTTP/1.1", "HTTP/1.1"}, s.protocols)
+		} else {
+			s.Equal([]string{"HTTP/2.0", "HTTP/2.0"}, s.protocols)
+		}
+	}
+}
+
+func (s *TransportSuite) TestHTTPNetwork_ShouldConnectFromLocalAddress() {
+	s.server.Start()
+
+	network, err := download.NewHTTPNetwork(download.HTTPConfig{Transport: download.TransportConfig{LocalAddress: "127.0.0.1"}})
+	s.Require().NoError(err)
+
+	_, err = network.FetchResource(s.server.URL + "/file.bin")
+	s.NoError(err)
+
+	_, err = download.NewHTTPNetwork(download.HTTPConfig{Transport: download.TransportConfig{LocalAddress: "eth0"}})
+	s.ErrorIs(err, download.InvalidTransportConfigErr)
+}
+
+func TestTransportSuite(t *testing.T) {
+	suite.Run(t, new(TransportSuite))
+}
@@ -107,20 +107,83 @@ func (_m *Downloader) FindDownloadByUrl(url string) (download.Download, error) {
 	return r0, r1
 }
 
-// InitDownload provides a mock function with given fields: url, workers
-func (_m *Downloader) InitDownload(url string, workers uint8) (download.Download, error) {
-	ret := _m.Called(url, workers)
+// InitDownload provides a mock function with given fields: url, options
+func (_m *Downloader) InitDownload(url string, options download.Options) (download.Download, error) {
+	ret := _m.Called(url, options)
 
 	var r0 download.Download
-	if rf, ok := ret.Get(0).(func(string, uint8) download.Download); ok {
-		r0 = rf(url, workers)
+	if rf, ok := ret.Get(0).(func(string, download.Options) download.Download); ok {
+		r0 = rf(url, options)
 	} else {
 		r0 = ret.Get(0).(download.Download)
 	}
 
 	var r1 error
-	if rf, ok := ret.Get(1).(func(string, uint8) error); ok {
-		r1 = rf(url, workers)
+	if rf, ok := ret.Get(1).(func(string, download.Options) error); ok {
+		r1 = rf(url, options)
+	} else {
+		r1 = ret.Error(1)
+	}
+
+	return r0, r1
+}
+
+// InitMetalinkDownload provides a mock function with given fields: metalink, options
+func (_m *Downloader) InitMetalinkDownload(metalink download.Metalink, options download.Options) (download.Download, error) {
+	ret := _m.Called(metalink, options)
+
+	var r0 download.Download
+	if rf, ok := ret.Get(0).(func(download.Metalink, download.Options) download.Download); ok {
+		r0 = rf(metalink, options)
+	} else {
+		r0 = ret.Get(0).(download.Download)
+	}
+
+	var r1 error
+	if rf, ok := ret.Get(1).(func(download.Metalink, download.Options) error); ok {
+		r1 = rf(metalink, options)
+	} else {
+		r1 = ret.Error(1)
+	}
+
+	return r0, r1
+}
+
+// RestartDownload provides a mock function with given fields: _a0
+func (_m *Downloader) RestartDownload(_a0 download.Download) (download.Download, error) {
+	ret := _m.Called(_a0)
+
+	var r0 download.Download
+	if rf, ok := ret.Get(0).(func(download.Download) download.Download); ok {
+		r0 = rf(_a0)
+	} else {
+		r0 = ret.Get(0).(download.Download)
+	}
+
+	var r1 error
+	if rf, ok := ret.Get(1).(func(download.Download) error); ok {
+		r1 = rf(_a0)
+	} else {
+		r1 = ret.Error(1)
+	}
+
+	return r0, r1
+}
+
+// ValidateDownload provides a mock function with given fields: _a0
+func (_m *Downloader) ValidateDownload(_a0 download.Download) (download.Download, error) {
+	ret := _m.Called(_a0)
+
+	var r0 download.Download
+	if rf, ok := ret.Get(0).(func(download.Download) download.Download); ok {
+		r0 = rf(_a0)
+	} else {
+		r0 = ret.Get(0).(download.Download)
+	}
+
+	var r1 error
+	if rf, ok := ret.Get(1).(func(download.Download) error); ok {
+		r1 = rf(_a0)
 	} else {
 		r1 = ret.Error(1)
 	}
@@ -16,13 +16,13 @@ type Network struct {
 	mock.Mock
 }
 
-// DownloadResource provides a mock function with given fields: url, start, end, writer, ctx
-func (_m *Network) DownloadResource(url string, start int64, end int64, writer io.Writer, ctx context.Context) error {
-	ret := _m.Called(url, start, end, writer, ctx)
+// DownloadResource provides a mock function with given fields: resource, start, end, writer, ctx
+func (_m *Network) DownloadResource(resource download.Resource, start int64, end int64, writer io.Writer, ctx context.Context) error {
+	ret := _m.Called(resource, start, end, writer, ctx)
 
 	var r0 error
-	if rf, ok := ret.Get(0).(func(string, int64, int64, io.Writer, context.Context) error); ok {
-		r0 = rf(url, start, end, writer, ctx)
+	if rf, ok := ret.Get(0).(func(download.Resource, int64, int64, io.Writer, context.Context) error); ok {
+		r0 = rf(resource, start, end, writer, ctx)
 	} else {
 		r0 = ret.Error(0)
 	}
@@ -51,6 +51,29 @@ func (_m *Network) FetchResource(url string) (download.Resource, error) {
 	return r0, r1
 }
 
+// ReadResource provides a mock function with given fields: url
+func (_m *Network) ReadResource(url string) ([]byte, error) {
+	ret := _m.Called(url)
+
+	var r0 []byte
+	if rf, ok := ret.Get(0).(func(string) []byte); ok {
+		r0 = rf(url)
+	} else {
+		if ret.Get(0) != nil {
+			r0 = ret.Get(0).([]byte)
+		}
+	}
+
+	var r1 error
+	if rf, ok := ret.Get(1).(func(string) error); ok {
+		r1 = rf(url)
+	} else {
+		r1 = ret.Error(1)
+	}
+
+	return r0, r1
+}
+
 type mockConstructorTestingTNewNetwork interface {
 	mock.TestingT
 	Cleanup(func())
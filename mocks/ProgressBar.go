@@ -3,8 +3,6 @@
 package mocks
 
 import (
-	io "io"
-
 	progressbar "github.com/MarcoTomasRodriguez/hget/pkg/progressbar"
 	mock "github.com/stretchr/testify/mock"
 )
@@ -15,19 +13,26 @@ type ProgressBar struct {
 }
 
 // Add provides a mock function with given fields: total, units, prefix
-func (_m *ProgressBar) Add(total int64, units progressbar.Units, prefix string) io.Writer {
+func (_m *ProgressBar) Add(total int64, units progressbar.Units, prefix string) (progressbar.Bar, error) {
 	ret := _m.Called(total, units, prefix)
 
-	var r0 io.Writer
-	if rf, ok := ret.Get(0).(func(int64, progressbar.Units, string) io.Writer); ok {
+	var r0 progressbar.Bar
+	if rf, ok := ret.Get(0).(func(int64, progressbar.Units, string) progressbar.Bar); ok {
 		r0 = rf(total, units, prefix)
 	} else {
 		if ret.Get(0) != nil {
-			r0 = ret.Get(0).(io.Writer)
+			r0 = ret.Get(0).(progressbar.Bar)
 		}
 	}
 
-	return r0
+	var r1 error
+	if rf, ok := ret.Get(1).(func(int64, progressbar.Units, string) error); ok {
+		r1 = rf(total, units, prefix)
+	} else {
+		r1 = ret.Error(1)
+	}
+
+	return r0, r1
 }
 
 // Start provides a mock function with given fields:
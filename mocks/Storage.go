@@ -15,6 +15,29 @@ type Storage struct {
 	mock.Mock
 }
 
+// AppendSegment provides a mock function with given fields: id
+func (_m *Storage) AppendSegment(id string) (io.WriteCloser, error) {
+	ret := _m.Called(id)
+
+	var r0 io.WriteCloser
+	if rf, ok := ret.Get(0).(func(string) io.WriteCloser); ok {
+		r0 = rf(id)
+	} else {
+		if ret.Get(0) != nil {
+			r0 = ret.Get(0).(io.WriteCloser)
+		}
+	}
+
+	var r1 error
+	if rf, ok := ret.Get(1).(func(string) error); ok {
+		r1 = rf(id)
+	} else {
+		r1 = ret.Error(1)
+	}
+
+	return r0, r1
+}
+
 // DeleteDownload provides a mock function with given fields: id
 func (_m *Storage) DeleteDownload(id string) error {
 	ret := _m.Called(id)
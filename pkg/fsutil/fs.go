@@ -1,8 +1,11 @@
 package fsutil
 
 import (
+	"errors"
 	"fmt"
 	"regexp"
+	"strconv"
+	"strings"
 )
 
 const (
@@ -13,6 +16,13 @@ const (
 	TB = SI * GB
 )
 
+var InvalidMemorySizeErr = errors.New("invalid memory size")
+
+// memorySizeUnits maps each unit suffix to its power of 1024.
+var memorySizeUnits = map[string]int{"": 0, "K": 1, "M": 2, "G": 3, "T": 4}
+
+var memorySizeRegex = regexp.MustCompile(`^(\d+(?:\.\d+)?)\s*([kKmMgGtT]?)i?[bB]?$`)
+
 type Number interface {
 	uint | uint16 | uint32 | uint64 | int | int16 | int32 | int64
 }
@@ -39,3 +49,23 @@ func ValidateFilename(filename string) bool {
 
 	return valid
 }
+
+// ParseMemorySize parses a memory size in bytes with an optional unit suffix, e.g. "512", "100k", "5M" or "1.5GiB".
+// Like curl's --limit-rate, the units are powers of 1024.
+func ParseMemorySize(size string) (int64, error) {
+	parts := memorySizeRegex.FindStringSubmatch(strings.TrimSpace(size))
+	if parts == nil {
+		return 0, InvalidMemorySizeErr
+	}
+
+	value, err := strconv.ParseFloat(parts[1], 64)
+	if err != nil {
+		return 0, InvalidMemorySizeErr
+	}
+
+	for i := 0; i < memorySizeUnits[strings.ToUpper(parts[2])]; i++ {
+		value *= 1024
+	}
+
+	return int64(value), nil
+}
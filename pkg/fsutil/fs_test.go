@@ -48,3 +48,28 @@ func TestReadableMemorySize(t *testing.T) {
 		})
 	}
 }
+
+func TestParseMemorySize(t *testing.T) {
+	cases := []struct {
+		size     string
+		expected int64
+		valid    bool
+	}{
+		{"512", 512, true},
+		{"100k", 100 * 1024, true},
+		{"5M", 5 * 1024 * 1024, true},
+		{"1.5GiB", 1536 * 1024 * 1024, true},
+		{"2 MB", 2 * 1024 * 1024, true},
+		{"", 0, false},
+		{"-5M", 0, false},
+		{"5X", 0, false},
+	}
+
+	for _, v := range cases {
+		t.Run(v.size, func(t *testing.T) {
+			size, err := ParseMemorySize(v.size)
+			assert.Equal(t, v.valid, err == nil)
+			assert.Equal(t, v.expected, size)
+		})
+	}
+}
@@ -0,0 +1,82 @@
+package httputil
+
+import (
+	"bufio"
+	"fmt"
+	"golang.org/x/net/publicsuffix"
+	"io"
+	"net/http"
+	"net/http/cookiejar"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+var InvalidCookieFileErr = fmt.Errorf("invalid cookie file")
+
+// httpOnlyPrefix prefixes the domain of the HttpOnly cookies in the files exported by curl and browsers.
+const httpOnlyPrefix = "#HttpOnly_"
+
+// NewCookieJar creates a cookie jar which only shares cookies within a registrable domain, as browsers do.
+func NewCookieJar() http.CookieJar {
+	jar, _ := cookiejar.New(&cookiejar.Options{PublicSuffixList: publicsuffix.List})
+	return jar
+}
+
+// LoadCookieJar creates a cookie jar holding the cookies of a file in the Netscape format, as exported by curl and
+// browser extensions. Each line describes a cookie with seven tab-separated fields: domain, include subdomains, path,
+// secure, expiration (Unix time, 0 for session cookies), name and value. Expired cookies are skipped.
+func LoadCookieJar(reader io.Reader) (http.CookieJar, error) {
+	jar := NewCookieJar()
+
+	scanner := bufio.NewScanner(reader)
+	for lineNumber := 1; scanner.Scan(); lineNumber++ {
+		line := strings.TrimRight(scanner.Text(), "\r")
+
+		httpOnly := strings.HasPrefix(line, httpOnlyPrefix)
+		line = strings.TrimPrefix(line, httpOnlyPrefix)
+		if strings.TrimSpace(line) == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		fields := strings.Split(line, "\t")
+		if len(fields) != 7 {
+			return nil, fmt.Errorf("%w: line %d: expected 7 fields, got %d", InvalidCookieFileErr, lineNumber, len(fields))
+		}
+
+		expires, err := strconv.ParseInt(fields[4], 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("%w: line %d: invalid expiration %q", InvalidCookieFileErr, lineNumber, fields[4])
+		}
+
+		if expires > 0 && time.Unix(expires, 0).Before(time.Now()) {
+			continue
+		}
+
+		secure := strings.EqualFold(fields[3], "TRUE")
+		cookie := &http.Cookie{Name: fields[5], Value: fields[6], Path: fields[2], Secure: secure, HttpOnly: httpOnly}
+		if expires > 0 {
+			cookie.Expires = time.Unix(expires, 0)
+		}
+
+		// Cookies including subdomains have a domain attribute, whereas the others are only sent to their host.
+		host := strings.TrimPrefix(fields[0], ".")
+		if strings.EqualFold(fields[1], "TRUE") {
+			cookie.Domain = host
+		}
+
+		scheme := "http"
+		if secure {
+			scheme = "https"
+		}
+
+		jar.SetCookies(&url.URL{Scheme: scheme, Host: host, Path: cookie.Path}, []*http.Cookie{cookie})
+	}
+
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("%w: %v", InvalidCookieFileErr, err)
+	}
+
+	return jar, nil
+}
@@ -0,0 +1,52 @@
+package httputil
+
+import (
+	"github.com/stretchr/testify/suite"
+	"net/http"
+	"net/url"
+	"strings"
+	"testing"
+)
+
+const cookieFile = "# Netscape HTTP Cookie File\n" +
+	"\n" +
+	".example.com\tTRUE\t/\tFALSE\t0\tsession\tabc123\n" +
+	"#HttpOnly_portal.example.com\tFALSE\t/downloads\tTRUE\t4102444800\ttoken\txyz\n" +
+	"example.com\tFALSE\t/\tFALSE\t946684800\texpired\told\n"
+
+type CookiesSuite struct {
+	suite.Suite
+}
+
+func (s *CookiesSuite) cookies(jar http.CookieJar, rawURL string) map[string]string {
+	u, _ := url.Parse(rawURL)
+	cookies := map[string]string{}
+	for _, cookie := range jar.Cookies(u) {
+		cookies[cookie.Name] = cookie.Value
+	}
+
+	return cookies
+}
+
+func (s *CookiesSuite) TestLoadCookieJar() {
+	jar, err := LoadCookieJar(strings.NewReader(cookieFile))
+	s.NoError(err)
+
+	// Cookies including subdomains are sent to the subdomains, and host-only cookies only to their host and path.
+	s.Equal(map[string]string{"session": "abc123"}, s.cookies(jar, "http://files.example.com/file.iso"))
+	s.Equal(map[string]string{"session": "abc123"}, s.cookies(jar, "http://portal.example.com/downloads/file.iso"))
+	s.Equal(map[string]string{"session": "abc123", "token": "xyz"}, s.cookies(jar, "https://portal.example.com/downloads/file.iso"))
+	s.Empty(s.cookies(jar, "https://other.com/file.iso"))
+}
+
+func (s *CookiesSuite) TestLoadCookieJar_ShouldFailIfInvalid() {
+	_, err := LoadCookieJar(strings.NewReader("example.com\tFALSE\t/\tFALSE\t0\tsession\n"))
+	s.ErrorIs(err, InvalidCookieFileErr)
+
+	_, err = LoadCookieJar(strings.NewReader("example.com\tFALSE\t/\tFALSE\tnever\tsession\tabc123\n"))
+	s.ErrorIs(err, InvalidCookieFileErr)
+}
+
+func TestCookiesSuite(t *testing.T) {
+	suite.Run(t, new(CookiesSuite))
+}
@@ -2,56 +2,214 @@ package httputil
 
 import (
 	"bytes"
+	"errors"
 	"fmt"
 	"github.com/jarcoal/httpmock"
+	"golang.org/x/net/idna"
 	"io"
+	"net"
 	"net/http"
+	"net/url"
 	"regexp"
 	"strconv"
+	"strings"
 )
 
+// DefaultScheme is the scheme of the URLs resolved without scheme, unless another one is configured.
+const DefaultScheme = "https"
+
 var (
-	InvalidUrlErr         = fmt.Errorf("invalid url")
-	ServerNotAvailableErr = fmt.Errorf("server not available")
+	InvalidUrlErr          = errors.New("invalid url")
+	EmptyUrlErr            = fmt.Errorf("%w: empty url", InvalidUrlErr)
+	MalformedUrlErr        = fmt.Errorf("%w: malformed url", InvalidUrlErr)
+	InvalidSchemeErr       = fmt.Errorf("%w: invalid scheme", InvalidUrlErr)
+	MissingHostErr         = fmt.Errorf("%w: missing host", InvalidUrlErr)
+	InvalidHostErr         = fmt.Errorf("%w: invalid host", InvalidUrlErr)
+	InvalidPortErr         = fmt.Errorf("%w: invalid port", InvalidUrlErr)
+	InvalidContentRangeErr = fmt.Errorf("invalid content range")
 )
 
-var urlRegex = regexp.MustCompile("^(https?://)?[-A-Za-z\\d+&@#/%?=~_|!,.;]+[-A-Za-z\\d+&@#/%=~_|]$")
+var contentRangeRegex = regexp.MustCompile(`^bytes (\d+)-(\d+)/(\d+|\*)$`)
+
+// schemeRegex matches the scheme of an absolute URL, as defined by RFC 3986.
+var schemeRegex = regexp.MustCompile(`^[A-Za-z][A-Za-z\d+.-]*://`)
+
+// defaultPorts maps the schemes to their default port, which is omitted from the resolved URLs.
+var defaultPorts = map[string]string{"http": "80", "https": "443", "ftp": "21", "ftps": "990", "sftp": "22"}
+
+// hostProfile converts host names for lookup, allowing the underscores found in some internal host names.
+var hostProfile = idna.New(idna.MapForLookup(), idna.StrictDomainName(false), idna.BidiRule())
+
+// ResolveURL parses and normalizes a URL, without any network round-trip. URLs without a scheme get the default
+// scheme, or DefaultScheme if empty. The scheme and host are lowercased, internationalized domain names are converted
+// to punycode, default ports and fragments are removed, and the path is escaped. Each way a URL can be invalid is
+// reported by an error wrapping InvalidUrlErr.
+func ResolveURL(rawURL string, defaultScheme string) (string, error) {
+	rawURL = strings.TrimSpace(rawURL)
+	if rawURL == "" {
+		return "", EmptyUrlErr
+	}
+
+	if defaultScheme == "" {
+		defaultScheme = DefaultScheme
+	}
+
+	if !schemeRegex.MatchString(rawURL) {
+		if strings.Contains(rawURL, "://") {
+			return "", fmt.Errorf("%w: %q", InvalidSchemeErr, rawURL)
+		}
+
+		rawURL = defaultScheme + "://" + rawURL
+	}
+
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return "", fmt.Errorf("%w: %v", MalformedUrlErr, err)
+	}
+
+	if u.Host == "" || u.Hostname() == "" {
+		return "", fmt.Errorf("%w: %q", MissingHostErr, rawURL)
+	}
 
-// ResolveURL resolves the url adding the http scheme, preferring https over http.
-func ResolveURL(rawURL string) (string, error) {
-	urlParts := urlRegex.FindStringSubmatch(rawURL)
+	// Convert internationalized domain names to punycode. IPv6 addresses are bracketed, and kept with their zone.
+	host := strings.ToLower(u.Hostname())
+	if strings.Contains(host, ":") {
+		if ip := net.ParseIP(host); ip != nil {
+			host = ip.String()
+		}
 
-	// Check if rawURL matches the regex.
-	if len(urlParts) < 1 {
-		return "", InvalidUrlErr
+		host = "[" + host + "]"
+	} else if net.ParseIP(host) == nil {
+		if host, err = hostProfile.ToASCII(host); err != nil {
+			return "", fmt.Errorf("%w: %q: %v", InvalidHostErr, u.Hostname(), err)
+		}
 	}
 
-	// If scheme is provided, attempt to execute a request.
-	if urlParts[1] != "" {
-		if _, err := http.Get(rawURL); err != nil {
-			return "", ServerNotAvailableErr
+	// Check the port range, and omit the default port of the scheme.
+	if port := u.Port(); port != "" {
+		if number, err := strconv.Atoi(port); err != nil || number < 1 || number > 65535 {
+			return "", fmt.Errorf("%w: %q", InvalidPortErr, port)
 		}
 
-		return rawURL, nil
+		if port != defaultPorts[u.Scheme] {
+			host += ":" + port
+		}
 	}
 
-	// Prefer https over http.
-	if url, err := ResolveURL("https://" + rawURL); err == nil {
-		return url, nil
+	u.Host = host
+	u.Fragment, u.RawFragment = "", ""
+
+	return u.String(), nil
+}
+
+// Origin returns the origin of a URL, i.e. its scheme, host and port, e.g. "https://example.com:8443". Default ports
+// are omitted, so that equivalent URLs have the same origin.
+func Origin(u *url.URL) string {
+	scheme := strings.ToLower(u.Scheme)
+
+	host := strings.ToLower(u.Hostname())
+	if strings.Contains(host, ":") {
+		host = "[" + host + "]"
 	}
 
-	// If not available over https, try with http.
-	return ResolveURL("http://" + rawURL)
+	if port := u.Port(); port != "" && port != defaultPorts[scheme] {
+		host += ":" + port
+	}
+
+	return scheme + "://" + host
 }
 
-// RegisterResponder registers a mock HTTP GET responder with support for ranges.
+// ParseContentRange parses a Content-Range header of a partial response, e.g. "bytes 0-499/1234", and returns the
+// first and last byte positions, both inclusive, and the complete length, which is -1 if unknown.
+func ParseContentRange(contentRange string) (first int64, last int64, length int64, err error) {
+	parts := contentRangeRegex.FindStringSubmatch(contentRange)
+	if parts == nil {
+		return 0, 0, 0, InvalidContentRangeErr
+	}
+
+	first, _ = strconv.ParseInt(parts[1], 10, 64)
+	last, _ = strconv.ParseInt(parts[2], 10, 64)
+
+	length = -1
+	if parts[3] != "*" {
+		length, _ = strconv.ParseInt(parts[3], 10, 64)
+	}
+
+	if first > last || (length >= 0 && last >= length) {
+		return 0, 0, 0, InvalidContentRangeErr
+	}
+
+	return first, last, length, nil
+}
+
+// Link is a link of a Link header, as defined by RFC 8288.
+type Link struct {
+	URL string
+	// Params maps the lowercase name of each parameter to its unquoted value, e.g. "rel" to "duplicate".
+	Params map[string]string
+}
+
+// ParseLinkHeader parses the values of Link headers, e.g. `<https://mirror.test/file>; rel=duplicate; pri=1`. Links
+// which are not well-formed are skipped.
+func ParseLinkHeader(values []string) []Link {
+	var links []Link
+
+	for _, value := range values {
+		for _, field := range splitOutsideQuotes(value, ',') {
+			field = strings.TrimSpace(field)
+			if !strings.HasPrefix(field, "<") || !strings.Contains(field, ">") {
+				continue
+			}
+
+			end := strings.Index(field, ">")
+			link := Link{URL: strings.TrimSpace(field[1:end]), Params: map[string]string{}}
+
+			for _, param := range splitOutsideQuotes(field[end+1:], ';') {
+				name, value, _ := strings.Cut(param, "=")
+				name = strings.ToLower(strings.TrimSpace(name))
+				if name == "" {
+					continue
+				}
+
+				link.Params[name] = strings.Trim(strings.TrimSpace(value), `"`)
+			}
+
+			links = append(links, link)
+		}
+	}
+
+	return links
+}
+
+// splitOutsideQuotes splits a header value by a separator, ignoring the separators within quoted strings.
+func splitOutsideQuotes(value string, separator rune) []string {
+	var parts []string
+	quoted := false
+	start := 0
+
+	for i, c := range value {
+		switch {
+		case c == '"':
+			quoted = !quoted
+		case c == separator && !quoted:
+			parts = append(parts, value[start:i])
+			start = i + 1
+		}
+	}
+
+	return append(parts, value[start:])
+}
+
+// RegisterResponder registers a mock HTTP GET and HEAD responder with support for ranges.
 func RegisterResponder(url string, body []byte, header http.Header) {
-	httpmock.RegisterResponder("GET", url, func(request *http.Request) (*http.Response, error) {
+	responder := func(request *http.Request) (*http.Response, error) {
 		start := 0
 		end := len(body)
+		status := http.StatusOK
 
 		rangeHeader := request.Header.Get("Range")
 		if rangeHeader != "" {
+			status = http.StatusPartialContent
 			regex, _ := regexp.Compile("^(bytes=(\\d+)-(\\d+))$")
 
 			rangeHeaderParsed := regex.FindStringSubmatch(rangeHeader)[2:4]
@@ -65,12 +223,27 @@ func RegisterResponder(url string, body []byte, header http.Header) {
 			}
 		}
 
-		body := io.NopCloser(bytes.NewReader(body[start:end]))
+		// Describe the returned range, without modifying the shared headers.
+		header := header.Clone()
+		if status == http.StatusPartialContent {
+			header.Set("Content-Range", fmt.Sprintf("bytes %d-%d/%d", start, end-1, len(body)))
+		}
+
+		// The response to a HEAD request has the headers of a GET response, without body.
+		content := body[start:end]
+		if request.Method == "HEAD" {
+			content = nil
+		}
 
 		return &http.Response{
+			Status:        http.StatusText(status),
+			StatusCode:    status,
 			ContentLength: int64(end - start),
-			Body:          body,
+			Body:          io.NopCloser(bytes.NewReader(content)),
 			Header:        header,
 		}, nil
-	})
+	}
+
+	httpmock.RegisterResponder("GET", url, responder)
+	httpmock.RegisterResponder("HEAD", url, responder)
 }
@@ -0,0 +1,104 @@
+package httputil
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"strings"
+)
+
+var InvalidNetrcErr = fmt.Errorf("invalid netrc")
+
+// NetrcMachine holds the credentials of a machine in a netrc file. The default entry has no name.
+type NetrcMachine struct {
+	Name     string
+	Login    string
+	Password string
+}
+
+// Netrc holds the entries of a netrc file, in order.
+type Netrc []NetrcMachine
+
+// ParseNetrc parses a netrc file, as used by curl and ftp: a sequence of "machine NAME", or "default", entries followed
+// by their "login", "password" and "account" tokens. Macro definitions and comments are skipped.
+func ParseNetrc(reader io.Reader) (Netrc, error) {
+	var netrc Netrc
+
+	scanner := bufio.NewScanner(reader)
+	inMacro := false
+	for scanner.Scan() {
+		line := scanner.Text()
+
+		// Macro definitions end at the first empty line.
+		if inMacro {
+			inMacro = strings.TrimSpace(line) != ""
+			continue
+		}
+
+		if i := strings.Index(line, "#"); i >= 0 {
+			line = line[:i]
+		}
+
+		tokens := strings.Fields(line)
+		for i := 0; i < len(tokens); i++ {
+			token := tokens[i]
+
+			switch token {
+			case "default":
+				netrc = append(netrc, NetrcMachine{})
+				continue
+			case "macdef":
+				inMacro = true
+				i = len(tokens)
+				continue
+			}
+
+			if i+1 >= len(tokens) {
+				return nil, fmt.Errorf("%w: missing value of %q", InvalidNetrcErr, token)
+			}
+
+			value := tokens[i+1]
+			i++
+
+			switch token {
+			case "machine":
+				netrc = append(netrc, NetrcMachine{Name: value})
+			case "login", "password", "account":
+				if len(netrc) == 0 {
+					return nil, fmt.Errorf("%w: %q outside of a machine", InvalidNetrcErr, token)
+				}
+
+				if token == "login" {
+					netrc[len(netrc)-1].Login = value
+				} else if token == "password" {
+					netrc[len(netrc)-1].Password = value
+				}
+			default:
+				return nil, fmt.Errorf("%w: unknown token %q", InvalidNetrcErr, token)
+			}
+		}
+	}
+
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("%w: %v", InvalidNetrcErr, err)
+	}
+
+	return netrc, nil
+}
+
+// Lookup returns the credentials of a host: those of the first machine with its name, or of the default entry.
+func (n Netrc) Lookup(host string) (NetrcMachine, bool) {
+	for _, machine := range n {
+		if machine.Name != "" && strings.EqualFold(machine.Name, host) {
+			return machine, true
+		}
+	}
+
+	for _, machine := range n {
+		if machine.Name == "" {
+			return machine, true
+		}
+	}
+
+	return NetrcMachine{}, false
+}
@@ -0,0 +1,65 @@
+package httputil
+
+import (
+	"github.com/stretchr/testify/suite"
+	"strings"
+	"testing"
+)
+
+const netrcFile = `# Credentials of the download portals.
+machine files.example.com login alice password s3cret
+machine ftp.example.com
+	login bob
+	password hunter2
+	account ignored
+
+macdef init
+cd /pub
+bin
+
+default login anonymous password guest@example.com
+`
+
+type NetrcSuite struct {
+	suite.Suite
+}
+
+func (s *NetrcSuite) TestParseNetrc() {
+	netrc, err := ParseNetrc(strings.NewReader(netrcFile))
+	s.NoError(err)
+	s.Equal(Netrc{
+		{Name: "files.example.com", Login: "alice", Password: "s3cret"},
+		{Name: "ftp.example.com", Login: "bob", Password: "hunter2"},
+		{Login: "anonymous", Password: "guest@example.com"},
+	}, netrc)
+}
+
+func (s *NetrcSuite) TestParseNetrc_ShouldFailIfInvalid() {
+	_, err := ParseNetrc(strings.NewReader("login alice password s3cret"))
+	s.ErrorIs(err, InvalidNetrcErr)
+
+	_, err = ParseNetrc(strings.NewReader("machine files.example.com login"))
+	s.ErrorIs(err, InvalidNetrcErr)
+
+	_, err = ParseNetrc(strings.NewReader("machine files.example.com user alice"))
+	s.ErrorIs(err, InvalidNetrcErr)
+}
+
+func (s *NetrcSuite) TestNetrc_Lookup() {
+	netrc, _ := ParseNetrc(strings.NewReader(netrcFile))
+
+	machine, ok := netrc.Lookup("FILES.example.com")
+	s.True(ok)
+	s.Equal("alice", machine.Login)
+
+	machine, ok = netrc.Lookup("other.example.com")
+	s.True(ok)
+	s.Equal("anonymous", machine.Login)
+
+	_, ok = netrc[:2].Lookup("other.example.com")
+	s.False(ok)
+}
+
+func TestNetrcSuite(t *testing.T) {
+	suite.Run(t, new(NetrcSuite))
+}
@@ -6,6 +6,7 @@ import (
 	"github.com/mattn/go-isatty"
 	"io"
 	"os"
+	"sync/atomic"
 )
 
 type Units int
@@ -18,7 +19,14 @@ var AlreadyStoppedErr = errors.New("progressbar has already been stopped")
 type ProgressBar interface {
 	Start() error
 	Stop() error
-	Add(total int64, units Units, prefix string) (io.Writer, error)
+	Add(total int64, units Units, prefix string) (Bar, error)
+}
+
+// Bar is a progress bar, which advances with the bytes written to it.
+type Bar interface {
+	io.Writer
+	// AddTotal adds a delta, which may be negative, to the total of the progress bar.
+	AddTotal(delta int64)
 }
 
 type progressBar struct {
@@ -37,7 +45,7 @@ func (p *progressBar) Start() error {
 	return err
 }
 
-// Stop stops the progress bar pool.
+// Stop stops the progress bar pool and removes its progress bars.
 func (p *progressBar) Stop() error {
 	if p.pool == nil {
 		return AlreadyStoppedErr
@@ -48,11 +56,13 @@ func (p *progressBar) Stop() error {
 	}
 
 	p.pool = nil
+	p.bars = nil
 	return nil
 }
 
-// Add adds a progress bar to the pool before it started its execution.
-func (p *progressBar) Add(total int64, units Units, prefix string) (io.Writer, error) {
+// Add adds a progress bar to the pool before it started its execution. If the total is not positive, it is unknown,
+// and the bar only shows the progress and the rate.
+func (p *progressBar) Add(total int64, units Units, prefix string) (Bar, error) {
 	// Check if progress bar is already running.
 	if p.pool != nil {
 		return nil, AlreadyRunningErr
@@ -68,7 +78,16 @@ func (p *progressBar) Add(total int64, units Units, prefix string) (io.Writer, e
 	bar := pb.New64(total).SetUnits(pbUnit).Prefix(prefix)
 	p.bars = append(p.bars, bar)
 
-	return bar, nil
+	return &resizableBar{bar}, nil
+}
+
+type resizableBar struct {
+	*pb.ProgressBar
+}
+
+// AddTotal adds a delta to the total of the progress bar.
+func (b *resizableBar) AddTotal(delta int64) {
+	b.SetTotal64(atomic.LoadInt64(&b.Total) + delta)
 }
 
 type NoopProgressBar struct{}
@@ -77,7 +96,13 @@ func (n NoopProgressBar) Start() error { return nil }
 
 func (n NoopProgressBar) Stop() error { return nil }
 
-func (n NoopProgressBar) Add(int64, Units, string) (io.Writer, error) { return io.Discard, nil }
+func (n NoopProgressBar) Add(int64, Units, string) (Bar, error) { return noopBar{io.Discard}, nil }
+
+type noopBar struct {
+	io.Writer
+}
+
+func (n noopBar) AddTotal(int64) {}
 
 // NewProgressBar creates a wrapper over cheggaaa's progress bar, that simplifies the creation and execution of a
 // progress bar pool. If the program is being executed outside a terminal, it returns a no-op progress bar.
@@ -93,3 +118,5 @@ func NewProgressBar() ProgressBar {
 
 var _ ProgressBar = (*progressBar)(nil)
 var _ ProgressBar = (*NoopProgressBar)(nil)
+var _ Bar = (*resizableBar)(nil)
+var _ Bar = (*noopBar)(nil)
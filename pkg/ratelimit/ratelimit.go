@@ -0,0 +1,117 @@
+package ratelimit
+
+import (
+	"context"
+	"io"
+	"sync"
+	"time"
+)
+
+// Limiter is a token bucket limiting the number of bytes per second, which can be shared by many writers.
+type Limiter struct {
+	mu     sync.Mutex
+	rate   int64
+	burst  int64
+	tokens float64
+	last   time.Time
+}
+
+// Wait blocks until n bytes can be transferred without exceeding the rate, or until the context is cancelled. The
+// bytes are reserved before waiting, so that concurrent callers share the rate fairly.
+func (l *Limiter) Wait(ctx context.Context, n int) error {
+	l.mu.Lock()
+	now := time.Now()
+	l.tokens += now.Sub(l.last).Seconds() * float64(l.rate)
+	if l.tokens > float64(l.burst) {
+		l.tokens = float64(l.burst)
+	}
+
+	l.last = now
+	l.tokens -= float64(n)
+	delay := time.Duration(-l.tokens / float64(l.rate) * float64(time.Second))
+	l.mu.Unlock()
+
+	if delay <= 0 {
+		return nil
+	}
+
+	timer := time.NewTimer(delay)
+	defer timer.Stop()
+
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-timer.C:
+		return nil
+	}
+}
+
+// Burst returns the maximum number of bytes that can be transferred at once.
+func (l *Limiter) Burst() int {
+	return int(l.burst)
+}
+
+// NewLimiter creates a limiter allowing the given number of bytes per second, with a burst of at most one tenth of a
+// second, so that the rate is smooth. The rate must be positive.
+func NewLimiter(rate int64) *Limiter {
+	burst := rate / 10
+	if burst < 1 {
+		burst = 1
+	}
+
+	return &Limiter{rate: rate, burst: burst, tokens: float64(burst), last: time.Now()}
+}
+
+type writer struct {
+	ctx      context.Context
+	writer   io.Writer
+	limiters []*Limiter
+}
+
+// Write writes the bytes in chunks no larger than the smallest burst, waiting for every limiter before each chunk.
+func (w *writer) Write(p []byte) (int, error) {
+	chunkSize := len(p)
+	for _, limiter := range w.limiters {
+		if limiter.Burst() < chunkSize {
+			chunkSize = limiter.Burst()
+		}
+	}
+
+	written := 0
+	for written < len(p) {
+		chunk := p[written:]
+		if len(chunk) > chunkSize {
+			chunk = chunk[:chunkSize]
+		}
+
+		for _, limiter := range w.limiters {
+			if err := limiter.Wait(w.ctx, len(chunk)); err != nil {
+				return written, err
+			}
+		}
+
+		n, err := w.writer.Write(chunk)
+		written += n
+		if err != nil {
+			return written, err
+		}
+	}
+
+	return written, nil
+}
+
+// NewWriter wraps a writer so that writes do not exceed the rate of any of the limiters. Nil limiters are ignored.
+func NewWriter(ctx context.Context, w io.Writer, limiters ...*Limiter) io.Writer {
+	var active []*Limiter
+	for _, limiter := range limiters {
+		if limiter != nil {
+			active = append(active, limiter)
+		}
+	}
+
+	if len(active) == 0 {
+		return w
+	}
+
+	return &writer{ctx: ctx, writer: w, limiters: active}
+}
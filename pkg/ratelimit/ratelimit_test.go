@@ -0,0 +1,58 @@
+package ratelimit
+
+import (
+	"bytes"
+	"context"
+	"github.com/stretchr/testify/assert"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestWriter_ShouldLimitRate(t *testing.T) {
+	limiter := NewLimiter(10_000)
+	buffer := new(bytes.Buffer)
+	writer := NewWriter(context.Background(), buffer, limiter)
+
+	// The first burst is immediately available, the rest takes ~200ms.
+	start := time.Now()
+	n, err := writer.Write(make([]byte, 3_000))
+	elapsed := time.Since(start)
+
+	assert.NoError(t, err)
+	assert.Equal(t, 3_000, n)
+	assert.Equal(t, 3_000, buffer.Len())
+	assert.GreaterOrEqual(t, elapsed, 150*time.Millisecond)
+	assert.Less(t, elapsed, time.Second)
+}
+
+func TestWriter_ShouldShareRateBetweenWriters(t *testing.T) {
+	limiter := NewLimiter(10_000)
+
+	var wg sync.WaitGroup
+	start := time.Now()
+	for i := 0; i < 2; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			_, _ = NewWriter(context.Background(), new(bytes.Buffer), limiter).Write(make([]byte, 1_500))
+		}()
+	}
+
+	wg.Wait()
+	assert.GreaterOrEqual(t, time.Since(start), 150*time.Millisecond)
+}
+
+func TestWriter_ShouldStopIfCancelled(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	writer := NewWriter(ctx, new(bytes.Buffer), NewLimiter(10))
+	_, err := writer.Write(make([]byte, 100))
+	assert.ErrorIs(t, err, context.Canceled)
+}
+
+func TestNewWriter_ShouldIgnoreNilLimiters(t *testing.T) {
+	buffer := new(bytes.Buffer)
+	assert.Same(t, buffer, NewWriter(context.Background(), buffer, nil))
+}